@@ -1,17 +1,28 @@
 -- =================================================================
 -- SQL-скрипт для инициализации базы данных "notes_api_db"
--- Версия: 1.3 (с поддержкой вложенных пользовательских таблиц, без админов)
+-- Версия: 1.4 (с поддержкой вложенных пользовательских таблиц и ролей пользователей)
 -- =================================================================
 
 -- Удаление существующих объектов в обратном порядке зависимостей
+DROP TABLE IF EXISTS note_templates;
+DROP TABLE IF EXISTS note_links;
+DROP TABLE IF EXISTS images;
+DROP TABLE IF EXISTS attachments;
 DROP TABLE IF EXISTS table_cells;
 DROP TABLE IF EXISTS table_rows;
 DROP TABLE IF EXISTS table_columns;
 DROP TABLE IF EXISTS note_tables;
 DROP TABLE IF EXISTS checklist_items;
 DROP TABLE IF EXISTS notes;
+DROP TABLE IF EXISTS password_reset_tokens;
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS api_tokens;
+DROP TABLE IF EXISTS oidc_states;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS user_identities;
 DROP TABLE IF EXISTS users;
 DROP TYPE IF EXISTS text_style;
+DROP TYPE IF EXISTS user_role;
 
 CREATE TYPE text_style AS ENUM (
     'normal',
@@ -19,19 +30,130 @@ CREATE TYPE text_style AS ENUM (
     'italic'
     );
 
+CREATE TYPE user_role AS ENUM (
+    'user',
+    'admin'
+    );
+
 CREATE TABLE users (
                        id BIGSERIAL PRIMARY KEY,
                        username VARCHAR(255) NOT NULL UNIQUE,
                        password TEXT NOT NULL,
+                       email VARCHAR(255) UNIQUE,
+                       -- Увеличивается при смене пароля и удалении аккаунта: выданные ранее токены перестают действовать
+                       token_version INT NOT NULL DEFAULT 0,
+                       -- Время окончательного удаления аккаунта; NULL, если удаление не запрошено
+                       delete_after TIMESTAMP WITH TIME ZONE,
+                       -- Секрет TOTP в base32; пока totp_enabled = FALSE, подключение не подтверждено
+                       totp_secret TEXT,
+                       totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+                       -- Последний принятый интервал TOTP: один и тот же код нельзя использовать дважды
+                       totp_last_step BIGINT NOT NULL DEFAULT 0,
+                       role user_role NOT NULL DEFAULT 'user',
+                       -- Профиль и настройки: отображаемое имя, язык (ru/en), часовой пояс,
+                       -- стиль текста новых заметок и порядок списка заметок (updated, created, title)
+                       display_name VARCHAR(100) NOT NULL DEFAULT '',
+                       locale VARCHAR(8) NOT NULL DEFAULT 'ru',
+                       timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+                       default_style text_style NOT NULL DEFAULT 'normal',
+                       note_sort VARCHAR(16) NOT NULL DEFAULT 'updated',
+                       -- Время блокировки администратором; заблокированный пользователь не может войти
+                       disabled_at TIMESTAMP WITH TIME ZONE,
                        created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Токены сброса пароля. Хранится только SHA-256 токена
+CREATE TABLE password_reset_tokens (
+                                       id BIGSERIAL PRIMARY KEY,
+                                       user_id BIGINT NOT NULL,
+                                       token_hash CHAR(64) NOT NULL UNIQUE,
+                                       expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+                                       used_at TIMESTAMP WITH TIME ZONE,
+                                       created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                                       CONSTRAINT fk_password_reset_user FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Одноразовые коды восстановления для входа без приложения-аутентификатора. Хранится только SHA-256 кода
+CREATE TABLE recovery_codes (
+                                id BIGSERIAL PRIMARY KEY,
+                                user_id BIGINT NOT NULL,
+                                code_hash CHAR(64) NOT NULL,
+                                used_at TIMESTAMP WITH TIME ZONE,
+                                CONSTRAINT fk_recovery_code_user FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+                                UNIQUE(user_id, code_hash)
+);
+
+-- Персональные API-токены для скриптов и интеграций. Хранится только SHA-256 токена
+CREATE TABLE api_tokens (
+                            id BIGSERIAL PRIMARY KEY,
+                            user_id BIGINT NOT NULL,
+                            name VARCHAR(255) NOT NULL,
+                            token_hash CHAR(64) NOT NULL UNIQUE,
+                            scopes TEXT[] NOT NULL,
+                            expires_at TIMESTAMP WITH TIME ZONE,
+                            last_used_at TIMESTAMP WITH TIME ZONE,
+                            created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                            CONSTRAINT fk_api_token_user FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Сеансы входа: каждый выданный JWT привязан к сеансу по jti (token_id).
+-- Сеанс действует, пока не отозван, не истёк и его token_version совпадает с версией пользователя
+CREATE TABLE sessions (
+                          id BIGSERIAL PRIMARY KEY,
+                          user_id BIGINT NOT NULL,
+                          token_id VARCHAR(64) NOT NULL UNIQUE,
+                          token_version INT NOT NULL,
+                          user_agent TEXT NOT NULL DEFAULT '',
+                          ip VARCHAR(64) NOT NULL DEFAULT '',
+                          created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                          last_seen_at TIMESTAMP WITH TIME ZONE NOT NULL,
+                          expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+                          revoked_at TIMESTAMP WITH TIME ZONE,
+                          -- Когда пользователь без пароля последний раз подтвердил вход у провайдера в этом сеансе
+                          reauthenticated_at TIMESTAMP WITH TIME ZONE,
+                          CONSTRAINT fk_session_user FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Внешние учётные записи (OpenID Connect), привязанные к пользователям
+CREATE TABLE user_identities (
+                                 id BIGSERIAL PRIMARY KEY,
+                                 user_id BIGINT NOT NULL,
+                                 provider VARCHAR(100) NOT NULL,
+                                 subject VARCHAR(255) NOT NULL,
+                                 email VARCHAR(255),
+                                 created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                                 last_login_at TIMESTAMP WITH TIME ZONE,
+                                 CONSTRAINT fk_user_identity_user FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+                                 UNIQUE(provider, subject)
+);
+
+-- Незавершённые входы через OpenID Connect: state (хранится SHA-256), nonce и PKCE code_verifier.
+-- user_id заполнен, если пользователь привязывает внешнюю учётную запись к своему аккаунту
+CREATE TABLE oidc_states (
+                             state_hash CHAR(64) PRIMARY KEY,
+                             provider VARCHAR(100) NOT NULL,
+                             nonce TEXT NOT NULL,
+                             code_verifier TEXT NOT NULL,
+                             user_id BIGINT,
+                             -- Заполнен при повторном входе для подтверждения операций с аккаунтом
+                             session_id BIGINT,
+                             expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+                             CONSTRAINT fk_oidc_state_user FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+                             CONSTRAINT fk_oidc_state_session FOREIGN KEY(session_id) REFERENCES sessions(id) ON DELETE CASCADE
+);
+
 
 CREATE TABLE notes (
                        id BIGSERIAL PRIMARY KEY,
                        title VARCHAR(255) NOT NULL,
                        content TEXT,
                        style text_style NOT NULL DEFAULT 'normal',
+                       rich_content JSONB,
+                       recurrence TEXT NOT NULL DEFAULT '',
+                       next_occurrence_at TIMESTAMP WITH TIME ZONE,
+                       pinned BOOLEAN NOT NULL DEFAULT FALSE,
+                       archived BOOLEAN NOT NULL DEFAULT FALSE,
+                       color VARCHAR(20) NOT NULL DEFAULT '',
                        user_id BIGINT NOT NULL,
                        created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
                        updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
@@ -44,10 +166,20 @@ CREATE TABLE checklist_items (
                                  text TEXT NOT NULL,
                                  completed BOOLEAN NOT NULL DEFAULT FALSE,
                                  style text_style NOT NULL DEFAULT 'normal',
+                                 rich_text JSONB,
                                  note_id BIGINT NOT NULL,
+                                 parent_id BIGINT,
+                                 position INT NOT NULL DEFAULT 0,
+                                 due_at TIMESTAMP WITH TIME ZONE,
+                                 remind_at TIMESTAMP WITH TIME ZONE,
+                                 reminded_at TIMESTAMP WITH TIME ZONE,
+                                 assignee_id BIGINT,
+                                 recurrence TEXT NOT NULL DEFAULT '',
                                  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
                                  updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-                                 CONSTRAINT fk_note FOREIGN KEY(note_id) REFERENCES notes(id) ON DELETE CASCADE
+                                 CONSTRAINT fk_note FOREIGN KEY(note_id) REFERENCES notes(id) ON DELETE CASCADE,
+                                 CONSTRAINT fk_checklist_parent FOREIGN KEY(parent_id) REFERENCES checklist_items(id) ON DELETE CASCADE,
+                                 CONSTRAINT fk_checklist_assignee FOREIGN KEY(assignee_id) REFERENCES users(id) ON DELETE SET NULL
 );
 
 CREATE TABLE note_tables (
@@ -83,4 +215,78 @@ CREATE TABLE table_cells (
                              CONSTRAINT fk_cell_row FOREIGN KEY(row_id) REFERENCES table_rows(id) ON DELETE CASCADE,
                              CONSTRAINT fk_cell_column FOREIGN KEY(column_id) REFERENCES table_columns(id) ON DELETE CASCADE,
                              UNIQUE (row_id, column_id)
-);
\ No newline at end of file
+);
+
+CREATE TABLE attachments (
+                             id BIGSERIAL PRIMARY KEY,
+                             note_id BIGINT NOT NULL,
+                             user_id BIGINT NOT NULL,
+                             filename VARCHAR(255) NOT NULL,
+                             content_type VARCHAR(255) NOT NULL,
+                             size BIGINT NOT NULL,
+                             storage_key TEXT NOT NULL UNIQUE,
+                             created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                             CONSTRAINT fk_attachment_note FOREIGN KEY(note_id) REFERENCES notes(id) ON DELETE CASCADE,
+                             CONSTRAINT fk_attachment_user FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Картинки, извлечённые из содержимого заметок. Принадлежат пользователю, а не заметке,
+-- потому что ссылки на них переносятся в копии заметок
+CREATE TABLE images (
+                        id BIGSERIAL PRIMARY KEY,
+                        user_id BIGINT NOT NULL,
+                        content_type VARCHAR(100) NOT NULL,
+                        width INT NOT NULL,
+                        height INT NOT NULL,
+                        size BIGINT NOT NULL,
+                        storage_key TEXT NOT NULL UNIQUE,
+                        created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                        CONSTRAINT fk_image_user FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Вики-ссылки между заметками: [[Заголовок]] или [[id:123]] в content.
+-- target_note_id = NULL означает висячую ссылку: такой заметки нет или она удалена
+CREATE TABLE note_links (
+                            id BIGSERIAL PRIMARY KEY,
+                            source_note_id BIGINT NOT NULL,
+                            target_note_id BIGINT,
+                            ref TEXT NOT NULL,
+                            CONSTRAINT fk_note_link_source FOREIGN KEY(source_note_id) REFERENCES notes(id) ON DELETE CASCADE,
+                            CONSTRAINT fk_note_link_target FOREIGN KEY(target_note_id) REFERENCES notes(id) ON DELETE SET NULL,
+                            UNIQUE (source_note_id, ref)
+);
+
+-- Шаблоны заметок. Чек-лист (дерево пунктов) и схемы таблиц хранятся в JSON
+CREATE TABLE note_templates (
+                                id BIGSERIAL PRIMARY KEY,
+                                user_id BIGINT NOT NULL,
+                                name VARCHAR(255) NOT NULL,
+                                title VARCHAR(255) NOT NULL,
+                                content TEXT NOT NULL DEFAULT '',
+                                style text_style NOT NULL DEFAULT 'normal',
+                                checklist JSONB NOT NULL DEFAULT '[]',
+                                tables JSONB NOT NULL DEFAULT '[]',
+                                created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                                CONSTRAINT fk_note_template_user FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Индексы для загрузки содержимого заметки без N+1 запросов
+CREATE INDEX idx_checklist_items_note_id ON checklist_items(note_id);
+CREATE INDEX idx_checklist_items_parent_id ON checklist_items(parent_id);
+CREATE INDEX idx_checklist_items_due_at ON checklist_items(due_at) WHERE completed = FALSE;
+CREATE INDEX idx_notes_next_occurrence_at ON notes(next_occurrence_at) WHERE recurrence <> '';
+CREATE INDEX idx_checklist_items_pending_reminders ON checklist_items(remind_at) WHERE completed = FALSE AND reminded_at IS NULL;
+CREATE INDEX idx_note_tables_note_id ON note_tables(note_id);
+CREATE INDEX idx_table_rows_table_id ON table_rows(table_id, position);
+CREATE INDEX idx_attachments_note_id ON attachments(note_id);
+CREATE INDEX idx_attachments_user_id ON attachments(user_id);
+CREATE INDEX idx_note_links_target_note_id ON note_links(target_note_id);
+CREATE INDEX idx_notes_user_id_title ON notes(user_id, lower(title));
+CREATE INDEX idx_note_templates_user_id ON note_templates(user_id);
+CREATE INDEX idx_notes_user_id_archived ON notes(user_id, archived, pinned DESC, updated_at DESC);
+CREATE INDEX idx_users_delete_after ON users(delete_after) WHERE delete_after IS NOT NULL;
+CREATE INDEX idx_password_reset_tokens_user_id ON password_reset_tokens(user_id);
+CREATE INDEX idx_api_tokens_user_id ON api_tokens(user_id);
+CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
+CREATE INDEX idx_oidc_states_expires_at ON oidc_states(expires_at);
+CREATE INDEX idx_sessions_user_id ON sessions(user_id);
@@ -0,0 +1,233 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"notes-api/internal/model"
+	"notes-api/internal/service"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type AdminHandler struct {
+	service service.AdminService
+}
+
+func NewAdminHandler(s service.AdminService) *AdminHandler {
+	return &AdminHandler{service: s}
+}
+
+// RegisterRoutes регистрирует маршруты администратора на роутере /admin.
+// Роутер должен быть закрыт SessionMiddleware и RequireRole(model.RoleAdmin)
+func (h *AdminHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/users", h.ListUsers).Methods("GET")
+	r.HandleFunc("/users/{id:[0-9]+}", h.GetUser).Methods("GET")
+	r.HandleFunc("/users/{id:[0-9]+}/disabled", h.SetDisabled).Methods("PUT")
+	r.HandleFunc("/users/{id:[0-9]+}/role", h.SetRole).Methods("PUT")
+	r.HandleFunc("/users/{id:[0-9]+}/password-reset", h.ResetPassword).Methods("POST")
+	r.HandleFunc("/users/{id:[0-9]+}/logout", h.ForceLogout).Methods("POST")
+	r.HandleFunc("/stats", h.Stats).Methods("GET")
+}
+
+// ListUsers godoc
+// @Summary      List users
+// @Description  Получить пользователей по порядку регистрации с числом заметок и объёмом файлов
+// @Tags         admin
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        q       query  string  false  "Поиск по имени или email"
+// @Param        limit   query  int     false  "Сколько пользователей вернуть (по умолчанию 50, максимум 500)"
+// @Param        offset  query  int     false  "Сколько пользователей пропустить"
+// @Success      200  {array}   model.AdminUser
+// @Failure      401,403,500 {object} map[string]string
+// @Router       /admin/users [get]
+func (h *AdminHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	offset, _ := strconv.Atoi(q.Get("offset"))
+
+	users, err := h.service.ListUsers(q.Get("q"), limit, offset)
+	if err != nil {
+		respondAdminError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, users)
+}
+
+// GetUser godoc
+// @Summary      Get a user
+// @Description  Получить пользователя по ID
+// @Tags         admin
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        id   path  int  true  "User ID"
+// @Success      200  {object}  model.AdminUser
+// @Failure      400,401,403,404 {object} map[string]string
+// @Router       /admin/users/{id} [get]
+func (h *AdminHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := h.service.GetUser(id)
+	if err != nil {
+		respondAdminError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, user)
+}
+
+// SetDisabled godoc
+// @Summary      Disable or enable a user
+// @Description  Заблокировать или разблокировать пользователя. Заблокированный пользователь не может войти,
+// @Description  его JWT и API-токены перестают действовать
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        id    path  int                           true  "User ID"
+// @Param        data  body  model.SetUserDisabledRequest  true  "Заблокирован ли пользователь"
+// @Success      200  {object}  model.AdminUser
+// @Failure      400,401,403,404,409 {object} map[string]string
+// @Router       /admin/users/{id}/disabled [put]
+func (h *AdminHandler) SetDisabled(w http.ResponseWriter, r *http.Request) {
+	id, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+	var req model.SetUserDisabledRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "Неверный формат запроса")
+		return
+	}
+
+	user, err := h.service.SetDisabled(r.Context().Value(userIDKey).(int64), id, req.Disabled)
+	if err != nil {
+		respondAdminError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, user)
+}
+
+// SetRole godoc
+// @Summary      Change a user's role
+// @Description  Назначить роль user или admin. Выданные пользователю токены отзываются
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        id    path  int                       true  "User ID"
+// @Param        data  body  model.SetUserRoleRequest  true  "Новая роль"
+// @Success      200  {object}  model.AdminUser
+// @Failure      400,401,403,404,409 {object} map[string]string
+// @Router       /admin/users/{id}/role [put]
+func (h *AdminHandler) SetRole(w http.ResponseWriter, r *http.Request) {
+	id, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+	var req model.SetUserRoleRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "Неверный формат запроса")
+		return
+	}
+
+	user, err := h.service.SetRole(r.Context().Value(userIDKey).(int64), id, req.Role)
+	if err != nil {
+		respondAdminError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, user)
+}
+
+// ResetPassword godoc
+// @Summary      Reset a user's password
+// @Description  Сбросить пароль: прежний пароль перестаёт действовать, все сеансы завершаются.
+// @Description  Ссылка для /auth/password/reset отправляется пользователю письмом. Одноразовый токен возвращается
+// @Description  администратору, только если у пользователя нет email или письмо не удалось отправить
+// @Tags         admin
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        id   path  int  true  "User ID"
+// @Success      200  {object}  model.AdminPasswordResetResponse
+// @Failure      400,401,403,404 {object} map[string]string
+// @Router       /admin/users/{id}/password-reset [post]
+func (h *AdminHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	id, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+
+	resp, err := h.service.ResetPassword(r.Context().Value(userIDKey).(int64), id)
+	if err != nil {
+		respondAdminError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// ForceLogout godoc
+// @Summary      Force logout
+// @Description  Завершить все сеансы пользователя: выданные JWT перестают действовать. API-токены не отзываются
+// @Tags         admin
+// @Security     ApiKeyAuth
+// @Param        id   path  int  true  "User ID"
+// @Success      204
+// @Failure      400,401,403,404 {object} map[string]string
+// @Router       /admin/users/{id}/logout [post]
+func (h *AdminHandler) ForceLogout(w http.ResponseWriter, r *http.Request) {
+	id, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.ForceLogout(r.Context().Value(userIDKey).(int64), id); err != nil {
+		respondAdminError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Stats godoc
+// @Summary      Usage statistics
+// @Description  Получить число пользователей, заметок, файлов и API-токенов
+// @Tags         admin
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Success      200  {object}  model.UsageStats
+// @Failure      401,403,500 {object} map[string]string
+// @Router       /admin/stats [get]
+func (h *AdminHandler) Stats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.service.Stats()
+	if err != nil {
+		respondAdminError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, stats)
+}
+
+func adminUserID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Некорректный ID пользователя")
+		return 0, false
+	}
+	return id, true
+}
+
+func respondAdminError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrUserNotFound):
+		respondError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, service.ErrInvalidRole):
+		respondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, service.ErrCannotModifySelf):
+		respondError(w, http.StatusConflict, err.Error())
+	default:
+		log.Printf("Ошибка операции администратора: %v", err)
+		respondError(w, http.StatusInternalServerError, "Внутренняя ошибка сервера")
+	}
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"notes-api/internal/model"
+	"notes-api/internal/service"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type APITokenHandler struct {
+	service service.APITokenService
+}
+
+func NewAPITokenHandler(s service.APITokenService) *APITokenHandler {
+	return &APITokenHandler{service: s}
+}
+
+// RegisterRoutes регистрирует маршруты API-токенов на роутере /auth/tokens.
+// Роутер должен быть закрыт SessionMiddleware: токены выпускаются только после входа пользователя
+func (h *APITokenHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("", h.List).Methods("GET")
+	r.HandleFunc("", h.Create).Methods("POST")
+	r.HandleFunc("/{id:[0-9]+}", h.Revoke).Methods("DELETE")
+}
+
+// Create godoc
+// @Summary      Create an API token
+// @Description  Выпустить именованный API-токен для скриптов с областями действия read, notes:write, tables:write.
+// @Description  Значение токена возвращается только один раз; передавайте его как "Bearer nat_...".
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        data body model.CreateAPITokenRequest true "Название, области действия и срок"
+// @Success      201  {object}  model.CreateAPITokenResponse
+// @Failure      400,401,403 {object} map[string]string
+// @Router       /auth/tokens [post]
+func (h *APITokenHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "Не удалось получить ID пользователя из токена")
+		return
+	}
+
+	var req model.CreateAPITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Неверный формат запроса")
+		return
+	}
+
+	resp, err := h.service.Create(userID, &req)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusCreated, resp)
+}
+
+// List godoc
+// @Summary      List API tokens
+// @Description  Получить API-токены пользователя без их значений, с временем последнего использования
+// @Tags         auth
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Success      200  {array}   model.APIToken
+// @Failure      401,403,500 {object} map[string]string
+// @Router       /auth/tokens [get]
+func (h *APITokenHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "Не удалось получить ID пользователя из токена")
+		return
+	}
+
+	tokens, err := h.service.List(userID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Не удалось получить токены")
+		return
+	}
+	respondJSON(w, http.StatusOK, tokens)
+}
+
+// Revoke godoc
+// @Summary      Revoke an API token
+// @Tags         auth
+// @Security     ApiKeyAuth
+// @Param        id   path  int  true  "Token ID"
+// @Success      204
+// @Failure      400,401,403,404 {object} map[string]string
+// @Router       /auth/tokens/{id} [delete]
+func (h *APITokenHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "Не удалось получить ID пользователя из токена")
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Некорректный ID токена")
+		return
+	}
+
+	if err := h.service.Revoke(id, userID); err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
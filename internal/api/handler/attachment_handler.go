@@ -0,0 +1,213 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"notes-api/internal/repository"
+	"notes-api/internal/service"
+	"notes-api/internal/storage"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// multipartOverhead - запас на заголовки multipart сверх размера самого файла
+const multipartOverhead = 1 << 20
+
+// inlineContentTypes - типы, которые браузер может показать сам. Остальные отдаются как attachment
+var inlineContentTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+type AttachmentHandler struct {
+	service service.AttachmentService
+	maxSize int64
+}
+
+func NewAttachmentHandler(s service.AttachmentService, maxSize int64) *AttachmentHandler {
+	return &AttachmentHandler{service: s, maxSize: maxSize}
+}
+
+// RegisterRoutes регистрирует маршруты вложений на роутере заметок
+func (h *AttachmentHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/{note_id:[0-9]+}/attachments", h.List).Methods("GET")
+	r.HandleFunc("/{note_id:[0-9]+}/attachments", h.Upload).Methods("POST")
+	r.HandleFunc("/{note_id:[0-9]+}/attachments/{attachment_id:[0-9]+}", h.Download).Methods("GET", "HEAD")
+	r.HandleFunc("/{note_id:[0-9]+}/attachments/{attachment_id:[0-9]+}", h.Delete).Methods("DELETE")
+}
+
+// Upload godoc
+// @Summary      Upload an attachment
+// @Description  Прикрепляет файл к заметке (multipart, поле file). Тип файла определяется по содержимому.
+// @Description  Размер файла и суммарный объём вложений пользователя ограничены
+// @Tags         attachments
+// @Accept       multipart/form-data
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        note_id  path      int   true  "Note ID"
+// @Param        file     formData  file  true  "Файл"
+// @Success      201      {object}  model.Attachment
+// @Failure      400,401,403,404,413,500 {object} map[string]string
+// @Router       /notes/{note_id}/attachments [post]
+func (h *AttachmentHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "Не удалось получить ID пользователя из токена")
+		return
+	}
+
+	noteID, _ := strconv.ParseInt(mux.Vars(r)["note_id"], 10, 64)
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxSize+multipartOverhead)
+	// Файлы больше 10 МБ multipart сохраняет во временный файл на диске
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondError(w, http.StatusRequestEntityTooLarge, service.ErrAttachmentTooLarge.Error())
+			return
+		}
+		respondError(w, http.StatusBadRequest, "Неверный формат запроса")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Файл не передан")
+		return
+	}
+	defer file.Close()
+
+	attachment, err := h.service.Upload(noteID, userID, header.Filename, header.Size, file)
+	if err != nil {
+		respondAttachmentError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, attachment)
+}
+
+// List godoc
+// @Summary      List attachments
+// @Description  Возвращает вложения заметки в порядке загрузки
+// @Tags         attachments
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        note_id  path  int  true  "Note ID"
+// @Success      200  {array}   model.Attachment
+// @Failure      401,403,404,500 {object} map[string]string
+// @Router       /notes/{note_id}/attachments [get]
+func (h *AttachmentHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "Не удалось получить ID пользователя из токена")
+		return
+	}
+
+	noteID, _ := strconv.ParseInt(mux.Vars(r)["note_id"], 10, 64)
+
+	attachments, err := h.service.List(noteID, userID)
+	if err != nil {
+		respondAttachmentError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, attachments)
+}
+
+// Download godoc
+// @Summary      Download an attachment
+// @Description  Отдает содержимое вложения. Поддерживаются запросы диапазонов (Range) и условные запросы
+// @Tags         attachments
+// @Produce      octet-stream
+// @Security     ApiKeyAuth
+// @Param        note_id        path    int     true   "Note ID"
+// @Param        attachment_id  path    int     true   "Attachment ID"
+// @Param        Range          header  string  false  "Диапазон байт, например bytes=0-1023"
+// @Success      200  {file}  file
+// @Success      206  {file}  file
+// @Failure      401,403,404,416,500 {object} map[string]string
+// @Router       /notes/{note_id}/attachments/{attachment_id} [get]
+func (h *AttachmentHandler) Download(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "Не удалось получить ID пользователя из токена")
+		return
+	}
+
+	vars := mux.Vars(r)
+	noteID, _ := strconv.ParseInt(vars["note_id"], 10, 64)
+	attachmentID, _ := strconv.ParseInt(vars["attachment_id"], 10, 64)
+
+	attachment, blob, err := h.service.Open(noteID, attachmentID, userID)
+	if err != nil {
+		respondAttachmentError(w, err)
+		return
+	}
+	defer blob.Close()
+
+	disposition := "attachment"
+	if inlineContentTypes[attachment.ContentType] {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", fmt.Sprintf(`"%d-%d"`, attachment.ID, attachment.Size))
+
+	// ServeContent сам обрабатывает Range, If-Range и If-Modified-Since
+	http.ServeContent(w, r, attachment.Filename, attachment.CreatedAt, blob)
+}
+
+// Delete godoc
+// @Summary      Delete an attachment
+// @Description  Удаляет вложение и его содержимое
+// @Tags         attachments
+// @Security     ApiKeyAuth
+// @Param        note_id        path  int  true  "Note ID"
+// @Param        attachment_id  path  int  true  "Attachment ID"
+// @Success      204
+// @Failure      401,403,404,500 {object} map[string]string
+// @Router       /notes/{note_id}/attachments/{attachment_id} [delete]
+func (h *AttachmentHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "Не удалось получить ID пользователя из токена")
+		return
+	}
+
+	vars := mux.Vars(r)
+	noteID, _ := strconv.ParseInt(vars["note_id"], 10, 64)
+	attachmentID, _ := strconv.ParseInt(vars["attachment_id"], 10, 64)
+
+	if err := h.service.Delete(noteID, attachmentID, userID); err != nil {
+		respondAttachmentError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// respondAttachmentError отвечает 404, если нет заметки, вложения или его содержимого, 403 для чужой
+// заметки и 413 при превышении размера или квоты. Остальные ошибки (БД, хранилище) логируются,
+// клиенту отвечается 500 без подробностей
+func respondAttachmentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrAttachmentTooLarge), errors.Is(err, service.ErrStorageQuotaExceeded):
+		respondError(w, http.StatusRequestEntityTooLarge, err.Error())
+	case errors.Is(err, service.ErrNoteNotFound), errors.Is(err, repository.ErrAttachmentNotFound):
+		respondError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, storage.ErrNotFound):
+		respondError(w, http.StatusNotFound, "Содержимое файла не найдено")
+	case errors.Is(err, service.ErrNoteAccessDenied):
+		respondError(w, http.StatusForbidden, err.Error())
+	default:
+		log.Printf("Ошибка операции с вложением: %v", err)
+		respondError(w, http.StatusInternalServerError, "Внутренняя ошибка сервера")
+	}
+}
@@ -3,31 +3,53 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"notes-api/internal/model"
+	"notes-api/internal/repository"
 	"notes-api/internal/service"
-	"notes-api/internal/util"
+	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 )
 
 type contextKey string
 
-const userIDKey contextKey = "userID"
+const (
+	userIDKey    contextKey = "userID"
+	roleKey      contextKey = "role"
+	sessionIDKey contextKey = "sessionID"
+)
 
 type AuthHandler struct {
 	authService *service.AuthService
+	// trustProxy - брать адрес клиента из заголовков обратного прокси
+	trustProxy bool
 }
 
-func NewAuthHandler(authService *service.AuthService) *AuthHandler {
-	return &AuthHandler{authService: authService}
+func NewAuthHandler(authService *service.AuthService, trustProxy bool) *AuthHandler {
+	return &AuthHandler{authService: authService, trustProxy: trustProxy}
 }
 
 func (h *AuthHandler) RegisterRoutes(r *mux.Router) {
 	r.HandleFunc("/register", h.Register).Methods("POST")
 	r.HandleFunc("/login", h.Login).Methods("POST")
+	r.HandleFunc("/login/2fa", h.LoginTwoFactor).Methods("POST")
+	r.HandleFunc("/password/forgot", h.ForgotPassword).Methods("POST")
+	r.HandleFunc("/password/reset", h.ResetPassword).Methods("POST")
+	r.Handle("/password", h.SessionMiddleware(http.HandlerFunc(h.ChangePassword))).Methods("POST")
+	r.Handle("/account", h.SessionMiddleware(http.HandlerFunc(h.DeleteAccount))).Methods("DELETE")
+	r.Handle("/2fa/setup", h.SessionMiddleware(http.HandlerFunc(h.SetupTOTP))).Methods("POST")
+	r.Handle("/2fa/confirm", h.SessionMiddleware(http.HandlerFunc(h.ConfirmTOTP))).Methods("POST")
+	r.Handle("/2fa/disable", h.SessionMiddleware(http.HandlerFunc(h.DisableTOTP))).Methods("POST")
+	r.Handle("/2fa/recovery-codes", h.SessionMiddleware(http.HandlerFunc(h.RegenerateRecoveryCodes))).Methods("POST")
+	r.Handle("/sessions", h.SessionMiddleware(http.HandlerFunc(h.Sessions))).Methods("GET")
+	r.Handle("/sessions/{id:[0-9]+}", h.SessionMiddleware(http.HandlerFunc(h.RevokeSession))).Methods("DELETE")
 }
 
 func decodeJSON(r *http.Request, v interface{}) error {
@@ -57,31 +79,27 @@ func respondJSON(w http.ResponseWriter, code int, data interface{}) {
 
 // Register godoc
 // @Summary Регистрация пользователя
-// @Description Создает нового пользователя
+// @Description Создает нового пользователя. Имя: 3-50 символов, латинские буквы, цифры, точка, дефис и подчёркивание.
+// @Description Пароль проверяется по политике (длина, совпадение с именем, список утёкших паролей).
+// @Description Ошибки возвращаются по полям в fields
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param user body model.User true "Данные пользователя"
 // @Success 201 {object} model.User
-// @Failure 400 {object} map[string]string
+// @Failure 400 {object} model.ValidationErrorResponse
+// @Failure 409 {object} model.ValidationErrorResponse "Имя пользователя или email заняты"
 // @Failure 500 {object} map[string]string
 // @Router /register [post]
 func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	var user model.User
 	if err := decodeJSON(r, &user); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request format")
-		return
-	}
-
-	// Валидация пароля
-	if user.Password == "" {
-		respondError(w, http.StatusBadRequest, "Password cannot be empty")
+		respondError(w, http.StatusBadRequest, "Неверный формат запроса")
 		return
 	}
 
-	log.Printf("Registering user: %s", user.Username) // Логируем только имя
 	if err := h.authService.Register(&user); err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondAccountError(w, err)
 		return
 	}
 
@@ -94,7 +112,7 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 
 // Login godoc
 // @Summary Аутентификация пользователя
-// @Description Вход пользователя в систему
+// @Description Вход пользователя в систему. Если включена двухфакторная аутентификация, вместо token возвращается challenge_token для /auth/login/2fa
 // @Tags auth
 // @Accept json
 // @Produce json
@@ -102,6 +120,8 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 // @Success 200 {object} model.LoginResponse
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string "Аккаунт заблокирован"
+// @Failure 429 {object} map[string]string "Слишком много неудачных попыток, см. заголовок Retry-After"
 // @Router /login [post]
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	var req model.LoginRequest
@@ -110,16 +130,417 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := h.authService.Login(&req)
+	resp, err := h.authService.Login(&req, h.clientInfo(r))
+	if err != nil {
+		respondLoginError(w, err, "Неверные учетные данные")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// LoginTwoFactor godoc
+// @Summary Второй шаг входа
+// @Description Обменивает challenge_token из /auth/login и код из приложения-аутентификатора (или код восстановления) на JWT
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body model.TwoFactorLoginRequest true "Challenge-токен и код"
+// @Success 200 {object} model.LoginResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 429 {object} map[string]string "Слишком много неудачных попыток, см. заголовок Retry-After"
+// @Router /auth/login/2fa [post]
+func (h *AuthHandler) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req model.TwoFactorLoginRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "Неверный формат запроса")
+		return
+	}
+
+	token, err := h.authService.LoginTwoFactor(&req, h.clientInfo(r))
 	if err != nil {
-		respondError(w, http.StatusUnauthorized, "Неверные учетные данные")
+		respondLoginError(w, err, "Неверный код или истёк срок входа")
 		return
 	}
 
 	respondJSON(w, http.StatusOK, model.LoginResponse{Token: token})
 }
 
-func AuthMiddleware(next http.Handler) http.Handler {
+// SetupTOTP godoc
+// @Summary Подключение двухфакторной аутентификации
+// @Description Генерирует секрет TOTP и otpauth:// ссылку для QR-кода. 2FA включается после подтверждения кодом в /auth/2fa/confirm
+// @Tags auth
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} model.TOTPSetupResponse
+// @Failure 401 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /auth/2fa/setup [post]
+func (h *AuthHandler) SetupTOTP(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(userIDKey).(int64)
+
+	resp, err := h.authService.SetupTOTP(userID)
+	if err != nil {
+		respondAccountError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// ConfirmTOTP godoc
+// @Summary Подтверждение двухфакторной аутентификации
+// @Description Включает 2FA, если код из приложения верный, и возвращает одноразовые коды восстановления. Коды показываются один раз
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body model.TOTPCodeRequest true "Код из приложения"
+// @Success 200 {object} model.RecoveryCodesResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /auth/2fa/confirm [post]
+func (h *AuthHandler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(userIDKey).(int64)
+
+	var req model.TOTPCodeRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "Неверный формат запроса")
+		return
+	}
+
+	codes, err := h.authService.ConfirmTOTP(userID, req.Code)
+	if err != nil {
+		respondAccountError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, model.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTOTP godoc
+// @Summary Отключение двухфакторной аутентификации
+// @Description Отключает 2FA и удаляет коды восстановления. Нужны пароль (если он задан) и код из приложения или код восстановления
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body model.DisableTOTPRequest true "Пароль и код"
+// @Success 204
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Router /auth/2fa/disable [post]
+func (h *AuthHandler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(userIDKey).(int64)
+
+	var req model.DisableTOTPRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "Неверный формат запроса")
+		return
+	}
+
+	if err := h.authService.DisableTOTP(userID, &req, h.clientInfo(r)); err != nil {
+		respondAccountError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary Новые коды восстановления
+// @Description Заменяет коды восстановления новыми; старые перестают действовать. Коды показываются один раз
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body model.TOTPCodeRequest true "Код из приложения или код восстановления"
+// @Success 200 {object} model.RecoveryCodesResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Router /auth/2fa/recovery-codes [post]
+func (h *AuthHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(userIDKey).(int64)
+
+	var req model.TOTPCodeRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "Неверный формат запроса")
+		return
+	}
+
+	codes, err := h.authService.RegenerateRecoveryCodes(userID, req.Code, h.clientInfo(r))
+	if err != nil {
+		respondAccountError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, model.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// JWKS godoc
+// @Summary Открытые ключи подписи токенов
+// @Description JWKS для проверки токенов доступа другими сервисами: текущий ключ подписи и предыдущие, токены которых ещё действуют. Адрес без префикса /api/v1
+// @Tags auth
+// @Produce json
+// @Success 200 {object} util.JWKS
+// @Router /.well-known/jwks.json [get]
+func (h *AuthHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondJSON(w, http.StatusOK, h.authService.JWKS())
+}
+
+// ChangePassword godoc
+// @Summary Смена пароля
+// @Description Меняет пароль после проверки текущего. Все выданные ранее токены отзываются, в ответе новый токен.
+// @Description Аккаунт без пароля подтверждает операцию кодом 2FA (code) или повторным входом через POST /auth/oidc/{provider}/reauth
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body model.ChangePasswordRequest true "Текущий и новый пароль"
+// @Success 200 {object} model.LoginResponse
+// @Failure 400 {object} model.ValidationErrorResponse
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Router /auth/password [post]
+func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(userIDKey).(int64)
+	sessionID, _ := r.Context().Value(sessionIDKey).(int64)
+
+	var req model.ChangePasswordRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "Неверный формат запроса")
+		return
+	}
+
+	token, err := h.authService.ChangePassword(userID, sessionID, &req, h.clientInfo(r))
+	if err != nil {
+		respondAccountError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, model.LoginResponse{Token: token})
+}
+
+// ForgotPassword godoc
+// @Summary Запрос сброса пароля
+// @Description Отправляет письмо со ссылкой для сброса пароля на email пользователя. Ответ не зависит от того, найден ли пользователь
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body model.ForgotPasswordRequest true "Имя пользователя или email"
+// @Success 202
+// @Failure 400 {object} map[string]string
+// @Router /auth/password/forgot [post]
+func (h *AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req model.ForgotPasswordRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "Неверный формат запроса")
+		return
+	}
+
+	if err := h.authService.ForgotPassword(req.Login); err != nil {
+		// Ошибка только логируется, чтобы ответ не раскрывал существование аккаунта
+		log.Printf("Ошибка запроса сброса пароля: %v", err)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword godoc
+// @Summary Сброс пароля
+// @Description Устанавливает новый пароль по одноразовому токену из письма. Все выданные ранее токены отзываются
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body model.ResetPasswordRequest true "Токен из письма и новый пароль"
+// @Success 204
+// @Failure 400 {object} model.ValidationErrorResponse
+// @Router /auth/password/reset [post]
+func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req model.ResetPasswordRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "Неверный формат запроса")
+		return
+	}
+
+	if err := h.authService.ResetPassword(&req); err != nil {
+		respondAccountError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteAccount godoc
+// @Summary Удаление аккаунта
+// @Description Помечает аккаунт на удаление вместе со всеми заметками. Все токены отзываются; вход до delete_after отменяет удаление.
+// @Description Аккаунт без пароля подтверждает удаление кодом 2FA (code) или повторным входом через POST /auth/oidc/{provider}/reauth
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body model.DeleteAccountRequest true "Пароль или код 2FA для подтверждения"
+// @Success 202 {object} model.DeleteAccountResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Router /auth/account [delete]
+func (h *AuthHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(userIDKey).(int64)
+	sessionID, _ := r.Context().Value(sessionIDKey).(int64)
+
+	var req model.DeleteAccountRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "Неверный формат запроса")
+		return
+	}
+
+	deleteAfter, err := h.authService.DeleteAccount(userID, sessionID, &req, h.clientInfo(r))
+	if err != nil {
+		respondAccountError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusAccepted, model.DeleteAccountResponse{DeleteAfter: deleteAfter})
+}
+
+// Sessions godoc
+// @Summary Активные сеансы
+// @Description Список устройств, на которых выполнен вход: User-Agent, IP, время входа и последней активности. Текущий сеанс отмечен current
+// @Tags auth
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {array} model.Session
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Router /auth/sessions [get]
+func (h *AuthHandler) Sessions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(userIDKey).(int64)
+	sessionID, _ := r.Context().Value(sessionIDKey).(int64)
+
+	sessions, err := h.authService.Sessions(userID, sessionID)
+	if err != nil {
+		respondAccountError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, sessions)
+}
+
+// RevokeSession godoc
+// @Summary Завершение сеанса
+// @Description Завершает сеанс: его токен сразу перестаёт приниматься. Можно завершить и текущий сеанс (выход)
+// @Tags auth
+// @Security ApiKeyAuth
+// @Param id path int true "ID сеанса"
+// @Success 204
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/sessions/{id} [delete]
+func (h *AuthHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(userIDKey).(int64)
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Некорректный ID сеанса")
+		return
+	}
+
+	if err := h.authService.RevokeSession(userID, id); err != nil {
+		respondAccountError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// respondLoginError отвечает 429 с Retry-After, если вход временно заблокирован, 403 для аккаунта,
+// заблокированного администратором (пароль при этом уже проверен), иначе 401.
+// Причина отказа клиенту не сообщается, чтобы не выдавать, существует ли пользователь
+func respondLoginError(w http.ResponseWriter, err error, message string) {
+	var throttled *service.LoginThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		respondError(w, http.StatusTooManyRequests, "Слишком много попыток входа, повторите позже")
+	case errors.Is(err, service.ErrAccountDisabled):
+		respondError(w, http.StatusForbidden, "Аккаунт заблокирован администратором")
+	default:
+		respondError(w, http.StatusUnauthorized, message)
+	}
+}
+
+// clientInfo собирает адрес и User-Agent клиента для сеанса и учёта попыток входа
+func (h *AuthHandler) clientInfo(r *http.Request) service.ClientInfo {
+	return service.ClientInfo{IP: h.clientIP(r), UserAgent: r.UserAgent()}
+}
+
+// clientIP возвращает адрес клиента для учёта попыток входа. За доверенным прокси берётся
+// последний адрес из X-Forwarded-For: его добавил сам прокси, а начало списка задаёт клиент
+func (h *AuthHandler) clientIP(r *http.Request) string {
+	if h.trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			parts := strings.Split(forwarded, ",")
+			if ip := strings.TrimSpace(parts[len(parts)-1]); ip != "" {
+				return ip
+			}
+		}
+		if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func respondAccountError(w http.ResponseWriter, err error) {
+	var invalid *service.ValidationError
+	var throttled *service.LoginThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		respondError(w, http.StatusTooManyRequests, "Слишком много неудачных попыток, повторите позже")
+	case errors.As(err, &invalid):
+		respondJSON(w, http.StatusBadRequest, model.ValidationErrorResponse{Error: "Некорректные данные", Fields: invalid.Fields})
+	case errors.Is(err, repository.ErrUsernameTaken):
+		respondJSON(w, http.StatusConflict, model.ValidationErrorResponse{Error: err.Error(), Fields: map[string]string{"username": err.Error()}})
+	case errors.Is(err, repository.ErrEmailTaken):
+		respondJSON(w, http.StatusConflict, model.ValidationErrorResponse{Error: err.Error(), Fields: map[string]string{"email": err.Error()}})
+	case errors.Is(err, service.ErrInvalidPassword), errors.Is(err, service.ErrInvalidTOTPCode),
+		errors.Is(err, service.ErrReauthenticationRequired):
+		respondError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, service.ErrTOTPAlreadyEnabled), errors.Is(err, service.ErrTOTPNotEnabled),
+		errors.Is(err, service.ErrTOTPNotConfigured):
+		respondError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, repository.ErrInvalidResetToken):
+		respondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, repository.ErrSessionNotFound):
+		respondError(w, http.StatusNotFound, err.Error())
+	default:
+		log.Printf("Ошибка операции с аккаунтом: %v", err)
+		respondError(w, http.StatusInternalServerError, "Внутренняя ошибка сервера")
+	}
+}
+
+// Middleware проверяет JWT или API-токен и кладёт id пользователя в контекст запроса.
+// Для API-токенов проверяется область действия: чтение, изменение заметок или таблиц
+func (h *AuthHandler) Middleware(next http.Handler) http.Handler {
+	return h.authenticate(next, true)
+}
+
+// SessionMiddleware пропускает только вход пользователя по JWT. Им закрыты операции
+// с аккаунтом: API-токен не должен позволять менять пароль или выпускать новые токены
+func (h *AuthHandler) SessionMiddleware(next http.Handler) http.Handler {
+	return h.authenticate(next, false)
+}
+
+func (h *AuthHandler) authenticate(next http.Handler, allowAPITokens bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
@@ -127,14 +548,59 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		userID, err := util.ParseJWT(authHeader)
+		identity, err := h.authService.Authenticate(authHeader)
 		if err != nil {
 			respondError(w, http.StatusUnauthorized, "Неверный или просроченный токен")
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), userIDKey, userID)
+		if identity.APIToken != nil {
+			if !allowAPITokens {
+				respondError(w, http.StatusForbidden, "Эта операция недоступна по API-токену")
+				return
+			}
+			if scope := requiredScope(r); !identity.HasScope(scope) {
+				respondError(w, http.StatusForbidden, "У токена нет области действия "+scope)
+				return
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), userIDKey, identity.UserID)
+		ctx = context.WithValue(ctx, roleKey, identity.Role)
+		ctx = context.WithValue(ctx, sessionIDKey, identity.SessionID)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// RequireRole пропускает только пользователей с ролью role. Роль берётся из JWT, поэтому
+// middleware ставится после SessionMiddleware
+func (h *AuthHandler) RequireRole(role model.Role) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if current, _ := r.Context().Value(roleKey).(model.Role); current != role {
+				respondError(w, http.StatusForbidden, "Недостаточно прав")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requiredScope определяет область действия, нужную API-токену для запроса:
+// чтение для GET и HEAD, tables:write для изменения таблиц, notes:write для остального
+func requiredScope(r *http.Request) string {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return model.ScopeRead
+	}
+	path := r.URL.Path
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			path = tpl
+		}
+	}
+	if strings.Contains(path, "/tables") {
+		return model.ScopeTablesWrite
+	}
+	return model.ScopeNotesWrite
+}
@@ -6,6 +6,7 @@ import (
 	"notes-api/internal/model"
 	"notes-api/internal/service"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -21,11 +22,86 @@ func NewChecklistItemHandler(s service.ChecklistItemService) *ChecklistItemHandl
 func (h *ChecklistItemHandler) RegisterRoutes(r *mux.Router) {
 	// Эндпоинты будут вложенными в заметки для логичности
 	s := r.PathPrefix("/{note_id:[0-9]+}/checklist").Subrouter()
+	s.HandleFunc("", h.List).Methods("GET")
 	s.HandleFunc("", h.Create).Methods("POST")
+	s.HandleFunc("/order", h.Reorder).Methods("PUT")
+	s.HandleFunc("/completed", h.SetAllCompleted).Methods("PUT")
+	s.HandleFunc("/completed", h.DeleteCompleted).Methods("DELETE")
+	s.HandleFunc("/move", h.MoveItems).Methods("POST")
+	s.HandleFunc("/style", h.SetStyle).Methods("PUT")
+	s.HandleFunc("/{item_id:[0-9]+}/indent", h.Indent).Methods("POST")
+	s.HandleFunc("/{item_id:[0-9]+}/outdent", h.Outdent).Methods("POST")
 	s.HandleFunc("/{item_id}", h.Update).Methods("PUT")
 	s.HandleFunc("/{item_id}", h.Delete).Methods("DELETE")
 }
 
+// List godoc
+// @Summary      List checklist items
+// @Description  Получить чек-лист заметки в виде дерева. Вложенные пункты возвращаются в поле children
+// @Tags         checklist
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        note_id path int true "Note ID"
+// @Success      200   {array}  model.ChecklistItem
+// @Failure      400,401,403,500 {object} map[string]string
+// @Router       /notes/{note_id}/checklist [get]
+func (h *ChecklistItemHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "Не удалось получить ID пользователя")
+		return
+	}
+
+	vars := mux.Vars(r)
+	noteID, err := strconv.ParseInt(vars["note_id"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Некорректный ID заметки")
+		return
+	}
+
+	items, err := h.service.List(noteID, userID)
+	if err != nil {
+		respondError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, items)
+}
+
+// Due godoc
+// @Summary      List due checklist items
+// @Description  Получить невыполненные пункты чек-листов всех заметок пользователя: просроченные и со сроком в ближайшем периоде
+// @Tags         checklist
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        within query string false "Период для приближающихся сроков (например, 24h)" default(168h)
+// @Success      200   {object}  model.DueChecklistItems
+// @Failure      400,401,500 {object} map[string]string
+// @Router       /checklist/due [get]
+func (h *ChecklistItemHandler) Due(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "Не удалось получить ID пользователя")
+		return
+	}
+
+	within := 7 * 24 * time.Hour
+	if v := r.URL.Query().Get("within"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			respondError(w, http.StatusBadRequest, "Некорректный период within")
+			return
+		}
+		within = d
+	}
+
+	items, err := h.service.Due(userID, within)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, items)
+}
+
 // Create godoc
 // @Summary      Create a checklist item
 // @Description  Создать новый элемент чек-листа для заметки
@@ -34,7 +110,7 @@ func (h *ChecklistItemHandler) RegisterRoutes(r *mux.Router) {
 // @Produce      json
 // @Security     ApiKeyAuth
 // @Param        note_id path int true "Note ID"
-// @Param        item body model.ChecklistItem true "Checklist Item Data (только 'text')"
+// @Param        item body model.ChecklistItem true "Checklist Item Data (только 'text', 'style' и 'parent_id')"
 // @Success      201   {object}  model.ChecklistItem
 // @Failure      400,401,404,500 {object} map[string]string
 // @Router       /notes/{note_id}/checklist [post]
@@ -68,14 +144,16 @@ func (h *ChecklistItemHandler) Create(w http.ResponseWriter, r *http.Request) {
 
 // Update godoc
 // @Summary      Update a checklist item
-// @Description  Обновить элемент чек-листа (текст или статус выполнения)
+// @Description  Обновить элемент чек-листа (текст или статус выполнения).
+// @Description  С параметром cascade=true статус выполнения применяется и ко всем вложенным пунктам
 // @Tags         checklist
 // @Accept       json
 // @Produce      json
 // @Security     ApiKeyAuth
 // @Param        note_id path int true "Note ID"
 // @Param        item_id path int true "Checklist Item ID"
-// @Param        item body model.ChecklistItem true "Checklist Item Data (только 'text' и 'completed')"
+// @Param        item body model.UpdateChecklistItemRequest true "Checklist Item Data. Не переданные recurrence, due_at, remind_at и assignee_id не меняются"
+// @Param        cascade query bool false "Применить статус выполнения к вложенным пунктам"
 // @Success      200   {object}  model.ChecklistItem
 // @Failure      400,401,404,500 {object} map[string]string
 // @Router       /notes/{note_id}/checklist/{item_id} [put]
@@ -93,17 +171,19 @@ func (h *ChecklistItemHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var itemData model.ChecklistItem
-	if err := json.NewDecoder(r.Body).Decode(&itemData); err != nil {
+	var req model.UpdateChecklistItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		respondError(w, http.StatusBadRequest, "Неверный формат запроса")
 		return
 	}
 
-	if err := h.service.Update(&itemData, itemID, userID); err != nil {
+	cascade, _ := strconv.ParseBool(r.URL.Query().Get("cascade"))
+
+	if err := h.service.Update(&req, itemID, userID, cascade); err != nil {
 		respondError(w, http.StatusForbidden, err.Error())
 		return
 	}
-	respondJSON(w, http.StatusOK, itemData)
+	respondJSON(w, http.StatusOK, req.ChecklistItem)
 }
 
 // Delete godoc
@@ -138,3 +218,229 @@ func (h *ChecklistItemHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// Reorder godoc
+// @Summary      Reorder checklist items
+// @Description  Задать порядок пунктов одного уровня вложенности. Нужно перечислить все пункты уровня
+// @Tags         checklist
+// @Accept       json
+// @Security     ApiKeyAuth
+// @Param        note_id path int true "Note ID"
+// @Param        order body model.ReorderChecklistRequest true "Новый порядок пунктов"
+// @Success      204
+// @Failure      400,401,403,500 {object} map[string]string
+// @Router       /notes/{note_id}/checklist/order [put]
+func (h *ChecklistItemHandler) Reorder(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "Не удалось получить ID пользователя")
+		return
+	}
+
+	vars := mux.Vars(r)
+	noteID, err := strconv.ParseInt(vars["note_id"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Некорректный ID заметки")
+		return
+	}
+
+	var req model.ReorderChecklistRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Неверный формат запроса")
+		return
+	}
+
+	if err := h.service.Reorder(&req, noteID, userID); err != nil {
+		respondError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Indent godoc
+// @Summary      Indent a checklist item
+// @Description  Сделать пункт вложенным в предыдущий пункт того же уровня
+// @Tags         checklist
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        note_id path int true "Note ID"
+// @Param        item_id path int true "Checklist Item ID"
+// @Success      200   {object}  model.ChecklistItem
+// @Failure      400,401,403,500 {object} map[string]string
+// @Router       /notes/{note_id}/checklist/{item_id}/indent [post]
+func (h *ChecklistItemHandler) Indent(w http.ResponseWriter, r *http.Request) {
+	h.move(w, r, h.service.Indent)
+}
+
+// Outdent godoc
+// @Summary      Outdent a checklist item
+// @Description  Перенести пункт на уровень выше, сразу после его родителя
+// @Tags         checklist
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        note_id path int true "Note ID"
+// @Param        item_id path int true "Checklist Item ID"
+// @Success      200   {object}  model.ChecklistItem
+// @Failure      400,401,403,500 {object} map[string]string
+// @Router       /notes/{note_id}/checklist/{item_id}/outdent [post]
+func (h *ChecklistItemHandler) Outdent(w http.ResponseWriter, r *http.Request) {
+	h.move(w, r, h.service.Outdent)
+}
+
+func (h *ChecklistItemHandler) move(w http.ResponseWriter, r *http.Request, op func(itemID, userID int64) (*model.ChecklistItem, error)) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "Не удалось получить ID пользователя")
+		return
+	}
+
+	vars := mux.Vars(r)
+	itemID, err := strconv.ParseInt(vars["item_id"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Некорректный ID элемента")
+		return
+	}
+
+	item, err := op(itemID, userID)
+	if err != nil {
+		respondError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, item)
+}
+
+// SetAllCompleted godoc
+// @Summary      Complete or uncomplete all checklist items
+// @Description  Отметить все пункты чек-листа заметки выполненными или невыполненными
+// @Tags         checklist
+// @Accept       json
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        note_id path int true "Note ID"
+// @Param        data body model.SetChecklistCompletedRequest true "Статус выполнения"
+// @Success      200   {object}  model.BulkChecklistResult
+// @Failure      400,401,403,500 {object} map[string]string
+// @Router       /notes/{note_id}/checklist/completed [put]
+func (h *ChecklistItemHandler) SetAllCompleted(w http.ResponseWriter, r *http.Request) {
+	userID, noteID, ok := h.noteParams(w, r)
+	if !ok {
+		return
+	}
+
+	var req model.SetChecklistCompletedRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Неверный формат запроса")
+		return
+	}
+
+	affected, err := h.service.SetAllCompleted(noteID, userID, req.Completed)
+	if err != nil {
+		respondError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, model.BulkChecklistResult{Affected: affected})
+}
+
+// DeleteCompleted godoc
+// @Summary      Delete completed checklist items
+// @Description  Удалить все выполненные пункты чек-листа заметки вместе с вложенными
+// @Tags         checklist
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        note_id path int true "Note ID"
+// @Success      200   {object}  model.BulkChecklistResult
+// @Failure      400,401,403,500 {object} map[string]string
+// @Router       /notes/{note_id}/checklist/completed [delete]
+func (h *ChecklistItemHandler) DeleteCompleted(w http.ResponseWriter, r *http.Request) {
+	userID, noteID, ok := h.noteParams(w, r)
+	if !ok {
+		return
+	}
+
+	affected, err := h.service.DeleteCompleted(noteID, userID)
+	if err != nil {
+		respondError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, model.BulkChecklistResult{Affected: affected})
+}
+
+// MoveItems godoc
+// @Summary      Move checklist items to another note
+// @Description  Перенести выбранные пункты вместе с вложенными в другую заметку пользователя
+// @Tags         checklist
+// @Accept       json
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        note_id path int true "Note ID"
+// @Param        data body model.MoveChecklistItemsRequest true "Пункты и целевая заметка"
+// @Success      200   {object}  model.BulkChecklistResult
+// @Failure      400,401,403,500 {object} map[string]string
+// @Router       /notes/{note_id}/checklist/move [post]
+func (h *ChecklistItemHandler) MoveItems(w http.ResponseWriter, r *http.Request) {
+	userID, noteID, ok := h.noteParams(w, r)
+	if !ok {
+		return
+	}
+
+	var req model.MoveChecklistItemsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Неверный формат запроса")
+		return
+	}
+
+	affected, err := h.service.MoveItems(&req, noteID, userID)
+	if err != nil {
+		respondError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, model.BulkChecklistResult{Affected: affected})
+}
+
+// SetStyle godoc
+// @Summary      Apply a text style to checklist items
+// @Description  Применить стиль текста к выбранным пунктам чек-листа
+// @Tags         checklist
+// @Accept       json
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        note_id path int true "Note ID"
+// @Param        data body model.StyleChecklistItemsRequest true "Пункты и стиль"
+// @Success      200   {object}  model.BulkChecklistResult
+// @Failure      400,401,403,500 {object} map[string]string
+// @Router       /notes/{note_id}/checklist/style [put]
+func (h *ChecklistItemHandler) SetStyle(w http.ResponseWriter, r *http.Request) {
+	userID, noteID, ok := h.noteParams(w, r)
+	if !ok {
+		return
+	}
+
+	var req model.StyleChecklistItemsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Неверный формат запроса")
+		return
+	}
+
+	affected, err := h.service.SetStyle(&req, noteID, userID)
+	if err != nil {
+		respondError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, model.BulkChecklistResult{Affected: affected})
+}
+
+// noteParams извлекает ID пользователя и заметки, при ошибке отвечает клиенту сам
+func (h *ChecklistItemHandler) noteParams(w http.ResponseWriter, r *http.Request) (int64, int64, bool) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "Не удалось получить ID пользователя")
+		return 0, 0, false
+	}
+
+	noteID, err := strconv.ParseInt(mux.Vars(r)["note_id"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Некорректный ID заметки")
+		return 0, 0, false
+	}
+	return userID, noteID, true
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"notes-api/internal/service"
+	"notes-api/internal/storage"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type ImageHandler struct {
+	service service.ImageService
+}
+
+func NewImageHandler(s service.ImageService) *ImageHandler {
+	return &ImageHandler{service: s}
+}
+
+// GetImage godoc
+// @Summary      Get an inline image
+// @Description  Отдает картинку, извлечённую из содержимого заметки, или её миниатюру.
+// @Description  Миниатюры JPEG отдаются в JPEG, остальные - в PNG. Доступны размеры 128, 512 и 1024 по большей стороне
+// @Tags         images
+// @Produce      png
+// @Produce      jpeg
+// @Produce      gif
+// @Security     ApiKeyAuth
+// @Param        id    path   int  true   "Image ID"
+// @Param        size  query  int  false  "Размер миниатюры" Enums(128, 512, 1024)
+// @Success      200  {file}  file
+// @Failure      400,401,404 {object} map[string]string
+// @Router       /images/{id} [get]
+func (h *ImageHandler) GetImage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "Не удалось получить ID пользователя из токена")
+		return
+	}
+
+	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	size := 0
+	if v := r.URL.Query().Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "Некорректный размер")
+			return
+		}
+		size = n
+	}
+
+	img, contentType, blob, err := h.service.Open(id, userID, size)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUnsupportedThumbnailSize):
+			respondError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, storage.ErrNotFound):
+			respondError(w, http.StatusNotFound, "Содержимое картинки не найдено")
+		default:
+			respondError(w, http.StatusNotFound, err.Error())
+		}
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// Картинки не изменяются, поэтому их можно кэшировать надолго
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	http.ServeContent(w, r, "", img.CreatedAt, blob)
+}
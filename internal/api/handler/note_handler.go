@@ -2,7 +2,9 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"notes-api/internal/model"
 	"notes-api/internal/service"
@@ -21,12 +23,16 @@ func NewNoteHandler(s service.NoteService) *NoteHandler {
 
 // GetNotes godoc
 // @Summary      Get all notes
-// @Description  Получить список всех заметок
+// @Description  Получить список заметок: сначала закреплённые, затем в порядке sort (по умолчанию - из настроек
+// @Description  пользователя, изначально по дате изменения). Архивные заметки возвращаются только с include_archived=true
 // @Tags         notes
 // @Accept       json
 // @Produce      json
 // @Security     ApiKeyAuth
+// @Param        include_archived  query  bool    false  "Включить архивные заметки"
+// @Param        sort              query  string  false  "Порядок: updated, created или title. По умолчанию - из настроек пользователя"
 // @Success      200  {array}   model.Note
+// @Failure      400  {object}  map[string]string
 // @Failure      500  {object}  map[string]string
 // @Router       /notes [get]
 func (h *NoteHandler) GetNotes(w http.ResponseWriter, r *http.Request) {
@@ -36,7 +42,13 @@ func (h *NoteHandler) GetNotes(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	notes, err := h.service.GetAllNotes(userID)
+	includeArchived, _ := strconv.ParseBool(r.URL.Query().Get("include_archived"))
+	sort := model.NoteSort(r.URL.Query().Get("sort"))
+	notes, err := h.service.GetAllNotes(userID, includeArchived, sort)
+	if errors.Is(err, service.ErrInvalidNoteSort) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Не удалось получить заметки", http.StatusInternalServerError)
 		return
@@ -58,6 +70,7 @@ func (h *NoteHandler) GetNotes(w http.ResponseWriter, r *http.Request) {
 // @Param        note  body      model.Note  true  "Note Data"
 // @Success      201   {object}  model.Note
 // @Failure      400   {object}  map[string]string
+// @Failure      413   {object}  map[string]string
 // @Failure      500   {object}  map[string]string
 // @Router       /notes [post]
 func (h *NoteHandler) CreateNote(w http.ResponseWriter, r *http.Request) {
@@ -75,7 +88,7 @@ func (h *NoteHandler) CreateNote(w http.ResponseWriter, r *http.Request) {
 	}
 	note.UserID = userID
 	if err := h.service.CreateNote(&note); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), noteWriteErrorStatus(err, http.StatusInternalServerError))
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -126,15 +139,16 @@ func (h *NoteHandler) GetNote(w http.ResponseWriter, r *http.Request) {
 
 // UpdateNote godoc
 // @Summary      Update an existing note
-// @Description  Обновить существующую заметку
+// @Description  Обновить существующую заметку. Поля pinned, archived и color меняются отдельными запросами
 // @Tags         notes
 // @Accept       json
 // @Produce      json
 // @Security     ApiKeyAuth
 // @Param        id    path      int  true  "Note ID"
-// @Param        note  body      model.Note  true  "Updated note data"
+// @Param        note  body      model.UpdateNoteRequest  true  "Updated note data. Без recurrence правило повторения не меняется"
 // @Success      200   {object}  model.Note
 // @Failure      400   {object}  map[string]string
+// @Failure      413   {object}  map[string]string
 // @Failure      500   {object}  map[string]string
 // @Router       /notes/{id} [put]
 func (h *NoteHandler) UpdateNote(w http.ResponseWriter, r *http.Request) {
@@ -151,20 +165,20 @@ func (h *NoteHandler) UpdateNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var note model.Note
-	err = json.NewDecoder(r.Body).Decode(&note)
+	var req model.UpdateNoteRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		http.Error(w, "Неверный формат запроса", http.StatusBadRequest)
 		return
 	}
-	note.ID = id
+	req.ID = id
 
-	if err := h.service.UpdateNote(&note, userID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.service.UpdateNote(&req, userID); err != nil {
+		http.Error(w, err.Error(), noteWriteErrorStatus(err, http.StatusInternalServerError))
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(note)
+	err = json.NewEncoder(w).Encode(req.Note)
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -202,3 +216,364 @@ func (h *NoteHandler) DeleteNote(w http.ResponseWriter, r *http.Request) {
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// noteWriteErrorStatus - код ответа на ошибку сохранения текста заметки: картинки из текста
+// могут не поместиться в квоту пользователя
+func noteWriteErrorStatus(err error, fallback int) int {
+	if errors.Is(err, service.ErrStorageQuotaExceeded) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return fallback
+}
+
+// noteContentTypes - Content-Type ответа для каждого формата содержимого
+var noteContentTypes = map[model.ContentFormat]string{
+	model.ContentFormatJSON:     "application/json",
+	model.ContentFormatMarkdown: "text/markdown; charset=utf-8",
+	model.ContentFormatHTML:     "text/html; charset=utf-8",
+}
+
+// maxContentSize - ограничение размера тела запроса SetContent
+const maxContentSize = 2 << 20
+
+// contentFormat читает формат из параметра format, по умолчанию json
+func contentFormat(r *http.Request) (model.ContentFormat, bool) {
+	format := model.ContentFormat(r.URL.Query().Get("format"))
+	if format == "" {
+		format = model.ContentFormatJSON
+	}
+	_, ok := noteContentTypes[format]
+	return format, ok
+}
+
+// GetContent godoc
+// @Summary      Get note content
+// @Description  Получить форматированное содержимое заметки как JSON-документ, Markdown или HTML
+// @Tags         notes
+// @Produce      json
+// @Produce      text/markdown
+// @Produce      html
+// @Security     ApiKeyAuth
+// @Param        id      path      int     true   "Note ID"
+// @Param        format  query     string  false  "Формат: json (по умолчанию), markdown или html"
+// @Success      200     {object}  richtext.Document
+// @Failure      400     {object}  map[string]string
+// @Failure      404     {object}  map[string]string
+// @Router       /notes/{id}/content [get]
+func (h *NoteHandler) GetContent(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		http.Error(w, "Не удалось получить ID пользователя из токена", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Некорректный ID", http.StatusBadRequest)
+		return
+	}
+	format, ok := contentFormat(r)
+	if !ok {
+		http.Error(w, "Поддерживаются форматы json, markdown и html", http.StatusBadRequest)
+		return
+	}
+
+	content, err := h.service.GetContent(id, userID, format)
+	if err != nil {
+		http.Error(w, "Заметка не найдена или у вас нет к ней доступа", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", noteContentTypes[format])
+	if _, err := w.Write([]byte(content)); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// SetContent godoc
+// @Summary      Replace note content
+// @Description  Заменить содержимое заметки документом в формате JSON, Markdown или HTML.
+// @Description  Документ проверяется на сервере; content и style пересчитываются для старых клиентов.
+// @Tags         notes
+// @Accept       json
+// @Accept       text/markdown
+// @Accept       html
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        id      path      int                true   "Note ID"
+// @Param        format  query     string             false  "Формат тела: json (по умолчанию), markdown или html"
+// @Param        body    body      richtext.Document  true   "Содержимое"
+// @Success      200     {object}  model.Note
+// @Failure      400     {object}  map[string]string
+// @Failure      413     {object}  map[string]string
+// @Router       /notes/{id}/content [put]
+func (h *NoteHandler) SetContent(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		http.Error(w, "Не удалось получить ID пользователя из токена", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Некорректный ID", http.StatusBadRequest)
+		return
+	}
+	format, ok := contentFormat(r)
+	if !ok {
+		http.Error(w, "Поддерживаются форматы json, markdown и html", http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxContentSize))
+	if err != nil {
+		http.Error(w, "Содержимое слишком большое", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	note, err := h.service.SetContent(id, userID, format, body)
+	if err != nil {
+		http.Error(w, err.Error(), noteWriteErrorStatus(err, http.StatusBadRequest))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(note); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// RenderNote godoc
+// @Summary      Render note as HTML
+// @Description  Получить заметку в виде HTML: content разбирается как CommonMark с расширениями GFM,
+// @Description  за ним следуют чек-лист и таблицы. HTML очищается по белому списку тегов и атрибутов.
+// @Tags         notes
+// @Produce      html
+// @Security     ApiKeyAuth
+// @Param        id   path      int  true  "Note ID"
+// @Success      200  {string}  string
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Router       /notes/{id}/render [get]
+func (h *NoteHandler) RenderNote(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		http.Error(w, "Не удалось получить ID пользователя из токена", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Некорректный ID", http.StatusBadRequest)
+		return
+	}
+
+	rendered, err := h.service.RenderNote(id, userID)
+	if err != nil {
+		http.Error(w, "Заметка не найдена или у вас нет к ней доступа", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := w.Write([]byte(rendered)); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// GetLinks godoc
+// @Summary      Get note links
+// @Description  Получить вики-ссылки из заметки: [[Заголовок]] и [[id:123]] в content.
+// @Description  Ссылки на несуществующие заметки возвращаются с dangling = true
+// @Tags         notes
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        id   path      int  true  "Note ID"
+// @Success      200  {array}   model.NoteLink
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Router       /notes/{id}/links [get]
+func (h *NoteHandler) GetLinks(w http.ResponseWriter, r *http.Request) {
+	h.writeLinks(w, r, h.service.GetLinks)
+}
+
+// GetBacklinks godoc
+// @Summary      Get note backlinks
+// @Description  Получить заметки, которые ссылаются на эту заметку
+// @Tags         notes
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        id   path      int  true  "Note ID"
+// @Success      200  {array}   model.NoteLink
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Router       /notes/{id}/backlinks [get]
+func (h *NoteHandler) GetBacklinks(w http.ResponseWriter, r *http.Request) {
+	h.writeLinks(w, r, h.service.GetBacklinks)
+}
+
+func (h *NoteHandler) writeLinks(w http.ResponseWriter, r *http.Request, get func(id int64, userID int64) ([]*model.NoteLink, error)) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		http.Error(w, "Не удалось получить ID пользователя из токена", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Некорректный ID", http.StatusBadRequest)
+		return
+	}
+
+	links, err := get(id, userID)
+	if err != nil {
+		http.Error(w, "Заметка не найдена или у вас нет к ней доступа", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(links); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// DuplicateNote godoc
+// @Summary      Duplicate a note
+// @Description  Создать полную копию заметки: чек-лист, таблицы с колонками, строками и ячейками. Вложения не копируются
+// @Tags         notes
+// @Accept       json
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        id    path      int                         true   "Note ID"
+// @Param        data  body      model.DuplicateNoteRequest  false  "Заголовок копии"
+// @Success      201   {object}  model.Note
+// @Failure      400   {object}  map[string]string
+// @Failure      404   {object}  map[string]string
+// @Router       /notes/{id}/duplicate [post]
+func (h *NoteHandler) DuplicateNote(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		http.Error(w, "Не удалось получить ID пользователя из токена", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Некорректный ID", http.StatusBadRequest)
+		return
+	}
+
+	// Тело запроса необязательно
+	var req model.DuplicateNoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Неверный формат запроса", http.StatusBadRequest)
+		return
+	}
+
+	note, err := h.service.DuplicateNote(id, userID, req.Title)
+	if err != nil {
+		http.Error(w, "Заметка не найдена или у вас нет к ней доступа", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(note); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// SetPinned godoc
+// @Summary      Pin or unpin a note
+// @Description  Закрепить заметку в начале списка или открепить её
+// @Tags         notes
+// @Accept       json
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        id    path      int                         true  "Note ID"
+// @Param        data  body      model.SetNotePinnedRequest  true  "Закрепить или открепить"
+// @Success      200   {object}  model.Note
+// @Failure      400   {object}  map[string]string
+// @Failure      404   {object}  map[string]string
+// @Router       /notes/{id}/pinned [put]
+func (h *NoteHandler) SetPinned(w http.ResponseWriter, r *http.Request) {
+	var req model.SetNotePinnedRequest
+	h.setState(w, r, &req, func(id, userID int64) (*model.Note, error) {
+		return h.service.SetPinned(id, userID, req.Pinned)
+	})
+}
+
+// SetArchived godoc
+// @Summary      Archive or unarchive a note
+// @Description  Перенести заметку в архив или вернуть из него. Архивная заметка открепляется
+// @Tags         notes
+// @Accept       json
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        id    path      int                           true  "Note ID"
+// @Param        data  body      model.SetNoteArchivedRequest  true  "В архив или из архива"
+// @Success      200   {object}  model.Note
+// @Failure      400   {object}  map[string]string
+// @Failure      404   {object}  map[string]string
+// @Router       /notes/{id}/archived [put]
+func (h *NoteHandler) SetArchived(w http.ResponseWriter, r *http.Request) {
+	var req model.SetNoteArchivedRequest
+	h.setState(w, r, &req, func(id, userID int64) (*model.Note, error) {
+		return h.service.SetArchived(id, userID, req.Archived)
+	})
+}
+
+// SetColor godoc
+// @Summary      Set note colour
+// @Description  Изменить цветовую метку заметки: red, orange, yellow, green, teal, blue, purple, pink, gray
+// @Description  или пустая строка для цвета по умолчанию
+// @Tags         notes
+// @Accept       json
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        id    path      int                        true  "Note ID"
+// @Param        data  body      model.SetNoteColorRequest  true  "Цвет"
+// @Success      200   {object}  model.Note
+// @Failure      400   {object}  map[string]string
+// @Failure      404   {object}  map[string]string
+// @Router       /notes/{id}/color [put]
+func (h *NoteHandler) SetColor(w http.ResponseWriter, r *http.Request) {
+	var req model.SetNoteColorRequest
+	h.setState(w, r, &req, func(id, userID int64) (*model.Note, error) {
+		if !req.Color.IsValid() {
+			return nil, errInvalidColor
+		}
+		return h.service.SetColor(id, userID, req.Color)
+	})
+}
+
+var errInvalidColor = errors.New("неизвестный цвет заметки")
+
+// setState разбирает тело запроса в req и меняет состояние заметки через set
+func (h *NoteHandler) setState(w http.ResponseWriter, r *http.Request, req interface{}, set func(id, userID int64) (*model.Note, error)) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		http.Error(w, "Не удалось получить ID пользователя из токена", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Некорректный ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, "Неверный формат запроса", http.StatusBadRequest)
+		return
+	}
+
+	note, err := set(id, userID)
+	if err != nil {
+		if errors.Is(err, errInvalidColor) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Заметка не найдена или у вас нет к ней доступа", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(note); err != nil {
+		fmt.Println(err)
+	}
+}
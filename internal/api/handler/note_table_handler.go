@@ -1,15 +1,22 @@
 package handler
 
 import (
+	"bytes"
 	"encoding/json"
+	"mime"
 	"net/http"
 	"notes-api/internal/model"
 	"notes-api/internal/service"
+	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 )
 
+// Максимальный размер загружаемого файла при импорте таблицы
+const maxTableImportSize = 10 << 20
+
 type NoteTableHandler struct {
 	service service.NoteTableService
 }
@@ -20,7 +27,12 @@ func NewNoteTableHandler(s service.NoteTableService) *NoteTableHandler {
 
 func (h *NoteTableHandler) RegisterRoutes(r *mux.Router) {
 	r.HandleFunc("/{note_id:[0-9]+}/tables", h.CreateTable).Methods("POST")
+	r.HandleFunc("/{note_id:[0-9]+}/tables/import", h.ImportTable).Methods("POST")
 	r.HandleFunc("/{note_id:[0-9]+}/tables/{table_id:[0-9]+}/rows", h.AddRow).Methods("POST")
+	r.HandleFunc("/{note_id:[0-9]+}/tables/{table_id:[0-9]+}/rows/batch", h.AddRows).Methods("POST")
+	r.HandleFunc("/{note_id:[0-9]+}/tables/{table_id:[0-9]+}/cells", h.UpdateCells).Methods("PATCH")
+	r.HandleFunc("/{note_id:[0-9]+}/tables/{table_id:[0-9]+}/export", h.ExportTable).Methods("GET")
+	r.HandleFunc("/{note_id:[0-9]+}/tables/{table_id:[0-9]+}/move", h.MoveTable).Methods("POST")
 }
 
 // CreateTable godoc
@@ -97,3 +109,234 @@ func (h *NoteTableHandler) AddRow(w http.ResponseWriter, r *http.Request) {
 
 	respondJSON(w, http.StatusCreated, row)
 }
+
+// AddRows godoc
+// @Summary      Add rows to a table in bulk
+// @Description  Добавляет несколько строк в таблицу за одну транзакцию. Все строки проверяются на количество ячеек до вставки
+// @Tags         tables
+// @Accept       json
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        note_id path int true "Note ID"
+// @Param        table_id path int true "Table ID"
+// @Param        rows_data body model.AddTableRowsRequest true "Rows with cell values in column order"
+// @Success      201   {array}  model.TableRow
+// @Failure      400,401,403,404,500 {object} map[string]string
+// @Router       /notes/{note_id}/tables/{table_id}/rows/batch [post]
+func (h *NoteTableHandler) AddRows(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "Не удалось получить ID пользователя из токена")
+		return
+	}
+
+	vars := mux.Vars(r)
+	noteID, _ := strconv.ParseInt(vars["note_id"], 10, 64)
+	tableID, _ := strconv.ParseInt(vars["table_id"], 10, 64)
+
+	var req model.AddTableRowsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Неверный формат запроса")
+		return
+	}
+
+	rows, err := h.service.AddRows(&req, noteID, tableID, userID)
+	if err != nil {
+		respondError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, rows)
+}
+
+// UpdateCells godoc
+// @Summary      Update table cells in bulk
+// @Description  Изменяет содержимое нескольких ячеек таблицы за одну транзакцию. Возвращает затронутые строки только с изменёнными ячейками
+// @Tags         tables
+// @Accept       json
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        note_id path int true "Note ID"
+// @Param        table_id path int true "Table ID"
+// @Param        cells_data body model.UpdateTableCellsRequest true "Cells to update"
+// @Success      200   {array}  model.TableRow
+// @Failure      400,401,403,404,500 {object} map[string]string
+// @Router       /notes/{note_id}/tables/{table_id}/cells [patch]
+func (h *NoteTableHandler) UpdateCells(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "Не удалось получить ID пользователя из токена")
+		return
+	}
+
+	vars := mux.Vars(r)
+	noteID, _ := strconv.ParseInt(vars["note_id"], 10, 64)
+	tableID, _ := strconv.ParseInt(vars["table_id"], 10, 64)
+
+	var req model.UpdateTableCellsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Неверный формат запроса")
+		return
+	}
+
+	rows, err := h.service.UpdateCells(&req, noteID, tableID, userID)
+	if err != nil {
+		respondError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, rows)
+}
+
+// ExportTable godoc
+// @Summary      Export a table
+// @Description  Выгружает таблицу в CSV или XLSX. Первая строка файла содержит названия колонок
+// @Tags         tables
+// @Produce      octet-stream
+// @Security     ApiKeyAuth
+// @Param        note_id path int true "Note ID"
+// @Param        table_id path int true "Table ID"
+// @Param        format query string false "Формат файла" Enums(csv, xlsx) default(csv)
+// @Success      200   {file}  file
+// @Failure      400,401,403,404,500 {object} map[string]string
+// @Router       /notes/{note_id}/tables/{table_id}/export [get]
+func (h *NoteTableHandler) ExportTable(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "Не удалось получить ID пользователя из токена")
+		return
+	}
+
+	vars := mux.Vars(r)
+	noteID, _ := strconv.ParseInt(vars["note_id"], 10, 64)
+	tableID, _ := strconv.ParseInt(vars["table_id"], 10, 64)
+
+	format := model.TableFormatCSV
+	if f := r.URL.Query().Get("format"); f != "" {
+		format = model.TableFormat(strings.ToLower(f))
+	}
+	contentType, ok := tableContentTypes[format]
+	if !ok {
+		respondError(w, http.StatusBadRequest, "Поддерживаются только форматы csv и xlsx")
+		return
+	}
+
+	var buf bytes.Buffer
+	table, err := h.service.ExportTable(noteID, tableID, userID, format, &buf)
+	if err != nil {
+		respondError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	filename := table.Title + "." + string(format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(http.StatusOK)
+	buf.WriteTo(w)
+}
+
+// ImportTable godoc
+// @Summary      Import a table from a file
+// @Description  Создает таблицу из CSV или XLSX файла: первая строка становится колонками, остальные - строками.
+// @Description  Для CSV автоматически определяются разделитель (, ; или табуляция) и кодировка (UTF-8 или Windows-1251)
+// @Tags         tables
+// @Accept       multipart/form-data
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        note_id path int true "Note ID"
+// @Param        file formData file true "CSV или XLSX файл"
+// @Param        title formData string false "Название таблицы (по умолчанию - имя файла)"
+// @Param        format formData string false "Формат файла (по умолчанию - по расширению)" Enums(csv, xlsx)
+// @Success      201   {object}  model.NoteTable
+// @Failure      400,401,403,404,500 {object} map[string]string
+// @Router       /notes/{note_id}/tables/import [post]
+func (h *NoteTableHandler) ImportTable(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "Не удалось получить ID пользователя из токена")
+		return
+	}
+
+	vars := mux.Vars(r)
+	noteID, _ := strconv.ParseInt(vars["note_id"], 10, 64)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxTableImportSize)
+	if err := r.ParseMultipartForm(maxTableImportSize); err != nil {
+		respondError(w, http.StatusBadRequest, "Неверный формат запроса или файл слишком большой")
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Файл не передан")
+		return
+	}
+	defer file.Close()
+
+	ext := filepath.Ext(header.Filename)
+	format := model.TableFormat(strings.ToLower(strings.TrimPrefix(ext, ".")))
+	if f := r.FormValue("format"); f != "" {
+		format = model.TableFormat(strings.ToLower(f))
+	}
+	if _, ok := tableContentTypes[format]; !ok {
+		respondError(w, http.StatusBadRequest, "Поддерживаются только форматы csv и xlsx")
+		return
+	}
+
+	title := strings.TrimSpace(r.FormValue("title"))
+	if title == "" {
+		title = strings.TrimSuffix(filepath.Base(header.Filename), ext)
+	}
+
+	table, err := h.service.ImportTable(noteID, userID, title, format, file)
+	if err != nil {
+		respondError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, table)
+}
+
+var tableContentTypes = map[model.TableFormat]string{
+	model.TableFormatCSV:  "text/csv; charset=utf-8",
+	model.TableFormatXLSX: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// MoveTable godoc
+// @Summary      Move a table to another note
+// @Description  Переносит таблицу вместе с колонками, строками и ячейками в другую заметку пользователя
+// @Tags         tables
+// @Accept       json
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        note_id path int true "Note ID"
+// @Param        table_id path int true "Table ID"
+// @Param        data body model.MoveNoteTableRequest true "Целевая заметка"
+// @Success      200   {object}  model.NoteTable
+// @Failure      400,401,403 {object} map[string]string
+// @Router       /notes/{note_id}/tables/{table_id}/move [post]
+func (h *NoteTableHandler) MoveTable(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "Не удалось получить ID пользователя из токена")
+		return
+	}
+
+	vars := mux.Vars(r)
+	noteID, _ := strconv.ParseInt(vars["note_id"], 10, 64)
+	tableID, _ := strconv.ParseInt(vars["table_id"], 10, 64)
+
+	var req model.MoveNoteTableRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Неверный формат запроса")
+		return
+	}
+
+	table, err := h.service.MoveTable(&req, noteID, tableID, userID)
+	if err != nil {
+		respondError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, table)
+}
@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"notes-api/internal/model"
+	"notes-api/internal/service"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type NoteTemplateHandler struct {
+	service service.NoteTemplateService
+}
+
+func NewNoteTemplateHandler(s service.NoteTemplateService) *NoteTemplateHandler {
+	return &NoteTemplateHandler{service: s}
+}
+
+// RegisterRoutes регистрирует маршруты шаблонов на роутере /templates
+func (h *NoteTemplateHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("", h.List).Methods("GET")
+	r.HandleFunc("", h.Create).Methods("POST")
+	r.HandleFunc("/{id:[0-9]+}", h.Get).Methods("GET")
+	r.HandleFunc("/{id:[0-9]+}", h.Delete).Methods("DELETE")
+}
+
+// templateParams извлекает ID пользователя и ID шаблона из запроса
+func (h *NoteTemplateHandler) templateParams(w http.ResponseWriter, r *http.Request) (int64, int64, bool) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "Не удалось получить ID пользователя из токена")
+		return 0, 0, false
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Некорректный ID шаблона")
+		return 0, 0, false
+	}
+	return userID, id, true
+}
+
+// Create godoc
+// @Summary      Save a note as a template
+// @Description  Сохранить заметку как шаблон: текст, структуру чек-листа и колонки таблиц.
+// @Description  В тексте можно использовать переменные {{date}}, {{time}}, {{datetime}}, {{user}} и свои
+// @Tags         templates
+// @Accept       json
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        data body model.CreateTemplateRequest true "Заметка и название шаблона"
+// @Success      201  {object}  model.NoteTemplate
+// @Failure      400,401,403 {object} map[string]string
+// @Router       /templates [post]
+func (h *NoteTemplateHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "Не удалось получить ID пользователя из токена")
+		return
+	}
+
+	var req model.CreateTemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Неверный формат запроса")
+		return
+	}
+
+	t, err := h.service.CreateFromNote(&req, userID)
+	if err != nil {
+		respondError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusCreated, t)
+}
+
+// List godoc
+// @Summary      List templates
+// @Description  Получить шаблоны пользователя, отсортированные по названию
+// @Tags         templates
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Success      200  {array}   model.NoteTemplate
+// @Failure      401,500 {object} map[string]string
+// @Router       /templates [get]
+func (h *NoteTemplateHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "Не удалось получить ID пользователя из токена")
+		return
+	}
+
+	templates, err := h.service.List(userID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Не удалось получить шаблоны")
+		return
+	}
+	respondJSON(w, http.StatusOK, templates)
+}
+
+// Get godoc
+// @Summary      Get a template
+// @Tags         templates
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        id   path      int  true  "Template ID"
+// @Success      200  {object}  model.NoteTemplate
+// @Failure      400,401,404 {object} map[string]string
+// @Router       /templates/{id} [get]
+func (h *NoteTemplateHandler) Get(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := h.templateParams(w, r)
+	if !ok {
+		return
+	}
+
+	t, err := h.service.Get(id, userID)
+	if err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, t)
+}
+
+// Delete godoc
+// @Summary      Delete a template
+// @Tags         templates
+// @Security     ApiKeyAuth
+// @Param        id   path  int  true  "Template ID"
+// @Success      204
+// @Failure      400,401,404 {object} map[string]string
+// @Router       /templates/{id} [delete]
+func (h *NoteTemplateHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := h.templateParams(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.Delete(id, userID); err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateNote godoc
+// @Summary      Create a note from a template
+// @Description  Создать заметку по шаблону вместе с чек-листом и таблицами. Переменные {{date}}, {{time}},
+// @Description  {{datetime}} и {{user}} подставляются автоматически, остальные берутся из variables
+// @Tags         templates
+// @Accept       json
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        id   path      int  true  "Template ID"
+// @Param        data body      model.CreateNoteFromTemplateRequest false "Заголовок и значения переменных"
+// @Success      201  {object}  model.Note
+// @Failure      400,401,403 {object} map[string]string
+// @Router       /notes/from-template/{id} [post]
+func (h *NoteTemplateHandler) CreateNote(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := h.templateParams(w, r)
+	if !ok {
+		return
+	}
+
+	// Тело запроса необязательно
+	var req model.CreateNoteFromTemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		respondError(w, http.StatusBadRequest, "Неверный формат запроса")
+		return
+	}
+
+	note, err := h.service.CreateNote(id, userID, &req)
+	if err != nil {
+		respondError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusCreated, note)
+}
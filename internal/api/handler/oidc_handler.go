@@ -0,0 +1,251 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"notes-api/internal/model"
+	"notes-api/internal/repository"
+	"notes-api/internal/service"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Cookie со state привязывает возврат от провайдера к браузеру, который начал вход
+const oidcStateCookie = "oidc_state"
+
+type OIDCHandler struct {
+	service service.OIDCService
+	auth    *AuthHandler
+}
+
+func NewOIDCHandler(s service.OIDCService, auth *AuthHandler) *OIDCHandler {
+	return &OIDCHandler{service: s, auth: auth}
+}
+
+// RegisterRoutes регистрирует маршруты входа через OpenID Connect на роутере /auth
+func (h *OIDCHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/oidc/providers", h.Providers).Methods("GET")
+	r.HandleFunc("/oidc/{provider}/login", h.Login).Methods("GET")
+	r.HandleFunc("/oidc/{provider}/callback", h.Callback).Methods("GET")
+	r.Handle("/oidc/{provider}/link", h.auth.SessionMiddleware(http.HandlerFunc(h.Link))).Methods("POST")
+	r.Handle("/oidc/{provider}/reauth", h.auth.SessionMiddleware(http.HandlerFunc(h.Reauth))).Methods("POST")
+	r.Handle("/identities", h.auth.SessionMiddleware(http.HandlerFunc(h.Identities))).Methods("GET")
+	r.Handle("/identities/{id:[0-9]+}", h.auth.SessionMiddleware(http.HandlerFunc(h.Unlink))).Methods("DELETE")
+}
+
+// Providers godoc
+// @Summary      List OpenID Connect providers
+// @Description  Получить имена настроенных провайдеров входа
+// @Tags         auth
+// @Produce      json
+// @Success      200  {array}   string
+// @Router       /auth/oidc/providers [get]
+func (h *OIDCHandler) Providers(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, h.service.Providers())
+}
+
+// Login godoc
+// @Summary      Sign in with an OpenID Connect provider
+// @Description  Перенаправляет на страницу входа провайдера (authorization code flow с PKCE).
+// @Description  После входа провайдер возвращает пользователя на /auth/oidc/{provider}/callback
+// @Tags         auth
+// @Param        provider  path  string  true  "Provider name"
+// @Success      302
+// @Failure      404,502 {object} map[string]string
+// @Router       /auth/oidc/{provider}/login [get]
+func (h *OIDCHandler) Login(w http.ResponseWriter, r *http.Request) {
+	authURL, state, err := h.service.LoginURL(r.Context(), mux.Vars(r)["provider"])
+	if err != nil {
+		respondOIDCError(w, err, http.StatusBadGateway, "Провайдер входа недоступен")
+		return
+	}
+	setOIDCStateCookie(w, r, state)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Link godoc
+// @Summary      Link an OpenID Connect identity
+// @Description  Начать привязку учётной записи провайдера к текущему пользователю.
+// @Description  Клиент должен перейти по authorization_url; после возврата на callback учётная запись будет привязана
+// @Tags         auth
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        provider  path  string  true  "Provider name"
+// @Success      200  {object}  model.OIDCAuthorizationResponse
+// @Failure      401,403,404,502 {object} map[string]string
+// @Router       /auth/oidc/{provider}/link [post]
+func (h *OIDCHandler) Link(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(userIDKey).(int64)
+
+	authURL, state, err := h.service.LinkURL(r.Context(), mux.Vars(r)["provider"], userID)
+	if err != nil {
+		respondOIDCError(w, err, http.StatusBadGateway, "Провайдер входа недоступен")
+		return
+	}
+	setOIDCStateCookie(w, r, state)
+	respondJSON(w, http.StatusOK, model.OIDCAuthorizationResponse{AuthorizationURL: authURL})
+}
+
+// Reauth godoc
+// @Summary      Confirm the account with an OpenID Connect provider
+// @Description  Начать повторный вход у провайдера, которым пользователь без пароля подтверждает смену пароля,
+// @Description  email и удаление аккаунта. Провайдер запрашивает учётные данные заново; после возврата на callback
+// @Description  текущий сеанс может выполнять эти операции в течение REAUTH_MAX_AGE
+// @Tags         auth
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        provider  path  string  true  "Provider name"
+// @Success      200  {object}  model.OIDCAuthorizationResponse
+// @Failure      401,403,404,502 {object} map[string]string
+// @Router       /auth/oidc/{provider}/reauth [post]
+func (h *OIDCHandler) Reauth(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(userIDKey).(int64)
+	sessionID := r.Context().Value(sessionIDKey).(int64)
+
+	authURL, state, err := h.service.ReauthURL(r.Context(), mux.Vars(r)["provider"], userID, sessionID)
+	if err != nil {
+		respondOIDCError(w, err, http.StatusBadGateway, "Провайдер входа недоступен")
+		return
+	}
+	setOIDCStateCookie(w, r, state)
+	respondJSON(w, http.StatusOK, model.OIDCAuthorizationResponse{AuthorizationURL: authURL})
+}
+
+// Callback godoc
+// @Summary      OpenID Connect callback
+// @Description  Адрес возврата от провайдера. При входе возвращает JWT (или challenge_token, если включена 2FA);
+// @Description  пользователь создаётся при первом входе, если это разрешено для провайдера.
+// @Description  При привязке возвращает привязанную учётную запись, при повторном входе - до какого времени
+// @Description  сеанс может менять пароль, email и удалять аккаунт
+// @Tags         auth
+// @Produce      json
+// @Param        provider  path   string  true   "Provider name"
+// @Param        code      query  string  true   "Authorization code"
+// @Param        state     query  string  true   "State"
+// @Success      200  {object}  model.LoginResponse
+// @Success      201  {object}  model.UserIdentity
+// @Success      200  {object}  model.ReauthenticationResponse
+// @Failure      400,401,403,404,409 {object} map[string]string
+// @Router       /auth/oidc/{provider}/callback [get]
+func (h *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		respondError(w, http.StatusUnauthorized, "Провайдер отклонил вход: "+e)
+		return
+	}
+	code, state := q.Get("code"), q.Get("state")
+	if code == "" || state == "" {
+		respondError(w, http.StatusBadRequest, "Нет code или state")
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		respondError(w, http.StatusBadRequest, "Вход начат в другом браузере или устарел, начните заново")
+		return
+	}
+	clearOIDCStateCookie(w, r)
+
+	result, err := h.service.Callback(r.Context(), mux.Vars(r)["provider"], code, state, h.auth.clientInfo(r))
+	if err != nil {
+		respondOIDCError(w, err, http.StatusUnauthorized, "Не удалось войти через провайдера")
+		return
+	}
+	if result.Linked != nil {
+		respondJSON(w, http.StatusCreated, result.Linked)
+		return
+	}
+	if result.Reauthenticated != nil {
+		respondJSON(w, http.StatusOK, result.Reauthenticated)
+		return
+	}
+	respondJSON(w, http.StatusOK, result.Login)
+}
+
+// Identities godoc
+// @Summary      List linked identities
+// @Description  Получить учётные записи провайдеров, привязанные к пользователю
+// @Tags         auth
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Success      200  {array}   model.UserIdentity
+// @Failure      401,403,500 {object} map[string]string
+// @Router       /auth/identities [get]
+func (h *OIDCHandler) Identities(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(userIDKey).(int64)
+
+	identities, err := h.service.Identities(userID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Не удалось получить учётные записи")
+		return
+	}
+	respondJSON(w, http.StatusOK, identities)
+}
+
+// Unlink godoc
+// @Summary      Unlink an identity
+// @Description  Отвязать учётную запись провайдера. Единственный способ входа отвязать нельзя
+// @Tags         auth
+// @Security     ApiKeyAuth
+// @Param        id   path  int  true  "Identity ID"
+// @Success      204
+// @Failure      400,401,403,404,409 {object} map[string]string
+// @Router       /auth/identities/{id} [delete]
+func (h *OIDCHandler) Unlink(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(userIDKey).(int64)
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Некорректный ID учётной записи")
+		return
+	}
+
+	if err := h.service.Unlink(id, userID); err != nil {
+		respondOIDCError(w, err, http.StatusNotFound, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func setOIDCStateCookie(w http.ResponseWriter, r *http.Request, state string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/v1/auth/oidc",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		// Lax: cookie должна прийти при переходе со страницы провайдера
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearOIDCStateCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/api/v1/auth/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// respondOIDCError отвечает на известные ошибки их текстом, остальные логирует и отвечает fallback.
+// Подробности ошибок провайдера клиенту не показываются
+func respondOIDCError(w http.ResponseWriter, err error, fallback int, message string) {
+	switch {
+	case errors.Is(err, service.ErrUnknownProvider):
+		respondError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, service.ErrIdentityNotLinked), errors.Is(err, service.ErrAccountDisabled),
+		errors.Is(err, service.ErrReauthMismatch):
+		respondError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, repository.ErrIdentityTaken), errors.Is(err, service.ErrLastLoginMethod):
+		respondError(w, http.StatusConflict, err.Error())
+	default:
+		log.Printf("Ошибка входа через OpenID Connect: %v", err)
+		respondError(w, fallback, message)
+	}
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"net/http"
+	"notes-api/internal/model"
+	"notes-api/internal/service"
+)
+
+type ProfileHandler struct {
+	service service.ProfileService
+	auth    *AuthHandler
+}
+
+func NewProfileHandler(s service.ProfileService, auth *AuthHandler) *ProfileHandler {
+	return &ProfileHandler{service: s, auth: auth}
+}
+
+// Get godoc
+// @Summary      Get the current user
+// @Description  Получить профиль и настройки текущего пользователя
+// @Tags         profile
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Success      200  {object}  model.Profile
+// @Failure      401,500 {object} map[string]string
+// @Router       /me [get]
+func (h *ProfileHandler) Get(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "Не удалось получить ID пользователя из токена")
+		return
+	}
+
+	profile, err := h.service.Get(userID)
+	if err != nil {
+		respondAccountError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, profile)
+}
+
+// Update godoc
+// @Summary      Update the current user
+// @Description  Изменить отображаемое имя, email, язык (ru, en), часовой пояс, стиль текста новых заметок
+// @Description  и порядок списка заметок. Меняются только переданные поля; для смены email нужен current_password,
+// @Description  у аккаунта без пароля - code (2FA) или повторный вход через POST /auth/oidc/{provider}/reauth
+// @Tags         profile
+// @Accept       json
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        data  body  model.UpdateProfileRequest  true  "Изменяемые поля"
+// @Success      200  {object}  model.Profile
+// @Failure      400  {object}  model.ValidationErrorResponse
+// @Failure      401,403 {object} map[string]string
+// @Failure      409  {object}  model.ValidationErrorResponse
+// @Failure      429  {object}  map[string]string
+// @Router       /me [patch]
+func (h *ProfileHandler) Update(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDKey).(int64)
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "Не удалось получить ID пользователя из токена")
+		return
+	}
+	sessionID, _ := r.Context().Value(sessionIDKey).(int64)
+
+	var req model.UpdateProfileRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "Неверный формат запроса")
+		return
+	}
+
+	profile, err := h.service.Update(userID, sessionID, req, h.auth.clientInfo(r))
+	if err != nil {
+		respondAccountError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, profile)
+}
@@ -2,11 +2,22 @@ package config
 
 import (
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	Port string
-	DB   DBConfig
+	Port      string
+	DB        DBConfig
+	Reminders ReminderConfig
+	// Как часто проверять, не пора ли создать копию повторяющейся заметки
+	RecurringNotesInterval time.Duration
+	Storage                StorageConfig
+	Attachments            AttachmentConfig
+	Auth                   AuthConfig
+	Mailer                 MailerConfig
 }
 
 type DBConfig struct {
@@ -18,6 +29,138 @@ type DBConfig struct {
 	SSLMode  string
 }
 
+// ReminderConfig - настройки планировщика напоминаний по чек-листам
+type ReminderConfig struct {
+	Enabled  bool
+	Interval time.Duration
+	Notifier NotifierConfig
+}
+
+// NotifierConfig - способ доставки уведомлений: log, webhook или smtp
+type NotifierConfig struct {
+	Type       string
+	WebhookURL string
+	SMTP       SMTPConfig
+}
+
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+	// To - адрес, на который уходят все напоминания вместо адресов пользователей. Только для разработки
+	To string
+}
+
+// StorageConfig - где хранить содержимое файлов: local или s3
+type StorageConfig struct {
+	Type     string
+	LocalDir string
+	S3       S3Config
+}
+
+// S3Config - подключение к S3-совместимому хранилищу
+type S3Config struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	Region    string
+	UseSSL    bool
+}
+
+// AttachmentConfig - ограничения на вложения
+type AttachmentConfig struct {
+	// MaxSize - максимальный размер одного файла в байтах
+	MaxSize int64
+	// UserQuota - суммарный объём вложений и картинок из текста заметок одного пользователя в байтах
+	UserQuota int64
+}
+
+// AuthConfig - настройки восстановления пароля, удаления аккаунтов, двухфакторной аутентификации и защиты входа
+type AuthConfig struct {
+	// PasswordResetTTL - сколько действует токен сброса пароля
+	PasswordResetTTL time.Duration
+	// PasswordResetURL - ссылка для письма, %s заменяется токеном. Если пусто, в письме только токен
+	PasswordResetURL string
+	// AccountDeletionGrace - через сколько удаляется аккаунт после запроса; вход в это время отменяет удаление
+	AccountDeletionGrace time.Duration
+	// AccountPurgeInterval - как часто удалять аккаунты, у которых истёк срок
+	AccountPurgeInterval time.Duration
+	// ReauthMaxAge - сколько после повторного входа через провайдера пользователь без пароля
+	// может менять пароль, email и удалять аккаунт
+	ReauthMaxAge time.Duration
+	// TOTPIssuer - название сервиса в приложении-аутентификаторе
+	TOTPIssuer string
+	// OIDCProviders - провайдеры входа через OpenID Connect
+	OIDCProviders []OIDCProviderConfig
+	JWT           JWTConfig
+	LoginThrottle LoginThrottleConfig
+	// TrustProxyHeaders - брать адрес клиента из X-Forwarded-For/X-Real-IP. Включайте только за
+	// обратным прокси, который сам выставляет эти заголовки, иначе клиент подменит свой адрес
+	TrustProxyHeaders bool
+	PasswordPolicy    PasswordPolicyConfig
+	// AdminUsernames - пользователи, которым при запуске назначается роль администратора.
+	// Назначаются только уже зарегистрированные пользователи
+	AdminUsernames []string
+}
+
+// PasswordPolicyConfig - требования к новым паролям при регистрации, смене и сбросе
+type PasswordPolicyConfig struct {
+	MinLength int
+	// BreachedHashesFile - список SHA-1 хешей утёкших паролей, по одному в строке, как в
+	// выгрузке Have I Been Pwned ("HASH:count"). Список загружается в память целиком,
+	// поэтому рассчитан на самые частые пароли, а не на полную базу
+	BreachedHashesFile string
+}
+
+// LoginThrottleConfig - ограничение неудачных попыток входа. Попытки считаются отдельно для
+// имени пользователя и для IP: после FreeAttempts каждая следующая попытка откладывается на
+// вдвое больший срок (от BaseDelay до MaxDelay), а после LockoutThreshold вход блокируется на LockoutDuration
+type LoginThrottleConfig struct {
+	FreeAttempts     int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// С одного IP могут входить многие пользователи (NAT, офис), поэтому для адресов пороги выше
+	IPFreeAttempts     int
+	IPLockoutThreshold int
+}
+
+// JWTConfig - подпись токенов доступа. Ключи RSA или Ed25519 в PEM; для ротации новый ключ
+// становится ключом подписи, а старый переносится в PreviousKeyFiles, пока не истекут его токены
+type JWTConfig struct {
+	// SigningKeyFile - закрытый ключ подписи. Если не задан, при запуске создаётся временный ключ Ed25519
+	SigningKeyFile string
+	// PreviousKeyFiles - ключи, токены которых ещё принимаются (закрытые или открытые)
+	PreviousKeyFiles []string
+	Issuer           string
+	Audience         string
+	TTL              time.Duration
+}
+
+// OIDCProviderConfig - настройки провайдера OpenID Connect. Задаются переменными
+// OIDC_<ИМЯ>_*, где имя берётся из списка OIDC_PROVIDERS
+type OIDCProviderConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL - адрес /api/v1/auth/oidc/{name}/callback, зарегистрированный у провайдера
+	RedirectURL string
+	Scopes      []string
+	// AutoProvision - создавать пользователя при первом входе
+	AutoProvision bool
+}
+
+// MailerConfig - отправка писем пользователям: log или smtp
+type MailerConfig struct {
+	Type string
+	SMTP SMTPConfig
+}
+
 func (db *DBConfig) GetPostgresDSN() string {
 	return fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
@@ -36,5 +179,137 @@ func LoadConfig() *Config {
 			DBName:   "notes_api_db",
 			SSLMode:  "disable",
 		},
+		RecurringNotesInterval: getEnvDuration("RECURRING_NOTES_INTERVAL", time.Minute),
+		Storage: StorageConfig{
+			Type:     getEnv("STORAGE_TYPE", "local"),
+			LocalDir: getEnv("STORAGE_LOCAL_DIR", "./data/attachments"),
+			S3: S3Config{
+				// По умолчанию - локальный MinIO из docker-compose
+				Endpoint:  getEnv("S3_ENDPOINT", "localhost:9000"),
+				AccessKey: getEnv("S3_ACCESS_KEY", "minioadmin"),
+				SecretKey: getEnv("S3_SECRET_KEY", "minioadmin"),
+				Bucket:    getEnv("S3_BUCKET", "notes-attachments"),
+				Region:    getEnv("S3_REGION", ""),
+				UseSSL:    getEnv("S3_USE_SSL", "false") == "true",
+			},
+		},
+		Attachments: AttachmentConfig{
+			MaxSize:   getEnvInt64("ATTACHMENT_MAX_SIZE", 25<<20),
+			UserQuota: getEnvInt64("USER_STORAGE_QUOTA", 500<<20),
+		},
+		Auth: AuthConfig{
+			PasswordResetTTL:     getEnvDuration("PASSWORD_RESET_TTL", time.Hour),
+			PasswordResetURL:     getEnv("PASSWORD_RESET_URL", ""),
+			AccountDeletionGrace: getEnvDuration("ACCOUNT_DELETION_GRACE", 30*24*time.Hour),
+			AccountPurgeInterval: getEnvDuration("ACCOUNT_PURGE_INTERVAL", time.Hour),
+			ReauthMaxAge:         getEnvDuration("REAUTH_MAX_AGE", 5*time.Minute),
+			TOTPIssuer:           getEnv("TOTP_ISSUER", "Notes API"),
+			OIDCProviders:        loadOIDCProviders(),
+			JWT: JWTConfig{
+				SigningKeyFile:   getEnv("JWT_SIGNING_KEY_FILE", ""),
+				PreviousKeyFiles: strings.FieldsFunc(getEnv("JWT_PREVIOUS_KEY_FILES", ""), func(r rune) bool { return r == ',' }),
+				Issuer:           getEnv("JWT_ISSUER", "notes-api"),
+				Audience:         getEnv("JWT_AUDIENCE", "notes-api"),
+				TTL:              getEnvDuration("JWT_TTL", 24*time.Hour),
+			},
+			LoginThrottle: LoginThrottleConfig{
+				FreeAttempts:       getEnvInt("LOGIN_FREE_ATTEMPTS", 3),
+				BaseDelay:          getEnvDuration("LOGIN_BACKOFF_BASE", time.Second),
+				MaxDelay:           getEnvDuration("LOGIN_BACKOFF_MAX", 5*time.Minute),
+				LockoutThreshold:   getEnvInt("LOGIN_LOCKOUT_THRESHOLD", 10),
+				LockoutDuration:    getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+				IPFreeAttempts:     getEnvInt("LOGIN_IP_FREE_ATTEMPTS", 20),
+				IPLockoutThreshold: getEnvInt("LOGIN_IP_LOCKOUT_THRESHOLD", 100),
+			},
+			TrustProxyHeaders: getEnv("TRUST_PROXY_HEADERS", "false") == "true",
+			PasswordPolicy: PasswordPolicyConfig{
+				MinLength:          getEnvInt("PASSWORD_MIN_LENGTH", 8),
+				BreachedHashesFile: getEnv("PASSWORD_BREACHED_HASHES_FILE", ""),
+			},
+			AdminUsernames: strings.Fields(strings.ReplaceAll(getEnv("ADMIN_USERNAMES", ""), ",", " ")),
+		},
+		Mailer: MailerConfig{
+			Type: getEnv("MAILER_TYPE", "log"),
+			SMTP: SMTPConfig{
+				Host:     getEnv("SMTP_HOST", "localhost"),
+				Port:     getEnv("SMTP_PORT", "1025"),
+				Username: getEnv("SMTP_USERNAME", ""),
+				Password: getEnv("SMTP_PASSWORD", ""),
+				From:     getEnv("SMTP_FROM", "notes-api@localhost"),
+			},
+		},
+		Reminders: ReminderConfig{
+			Enabled:  getEnv("REMINDERS_ENABLED", "true") == "true",
+			Interval: getEnvDuration("REMINDERS_INTERVAL", time.Minute),
+			Notifier: NotifierConfig{
+				Type:       getEnv("NOTIFIER_TYPE", "log"),
+				WebhookURL: getEnv("NOTIFIER_WEBHOOK_URL", ""),
+				SMTP: SMTPConfig{
+					// По умолчанию - локальный Mailpit из docker-compose
+					Host:     getEnv("SMTP_HOST", "localhost"),
+					Port:     getEnv("SMTP_PORT", "1025"),
+					Username: getEnv("SMTP_USERNAME", ""),
+					Password: getEnv("SMTP_PASSWORD", ""),
+					From:     getEnv("SMTP_FROM", "notes-api@localhost"),
+					To:       getEnv("SMTP_TO", ""),
+				},
+			},
+		},
+	}
+}
+
+// loadOIDCProviders читает провайдеров из OIDC_PROVIDERS=corp,google и переменных OIDC_CORP_ISSUER и т.д.
+func loadOIDCProviders() []OIDCProviderConfig {
+	var providers []OIDCProviderConfig
+	for _, name := range strings.Split(getEnv("OIDC_PROVIDERS", ""), ",") {
+		name = strings.TrimSpace(strings.ToLower(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		providers = append(providers, OIDCProviderConfig{
+			Name:          name,
+			Issuer:        getEnv(prefix+"ISSUER", ""),
+			ClientID:      getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret:  getEnv(prefix+"CLIENT_SECRET", ""),
+			RedirectURL:   getEnv(prefix+"REDIRECT_URL", ""),
+			Scopes:        strings.Fields(getEnv(prefix+"SCOPES", "openid profile email")),
+			AutoProvision: getEnv(prefix+"AUTO_PROVISION", "true") == "true",
+		})
+	}
+	return providers
+}
+
+func getEnv(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if v, ok := os.LookupEnv(key); ok {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if v, ok := os.LookupEnv(key); ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
+
+func getEnvInt64(key string, fallback int64) int64 {
+	if v, ok := os.LookupEnv(key); ok {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n
+		}
 	}
+	return fallback
 }
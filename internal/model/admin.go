@@ -0,0 +1,59 @@
+package model
+
+import "time"
+
+// AdminUser - пользователь в списке администратора: данные аккаунта и занятое место
+type AdminUser struct {
+	ID           int64      `json:"id" example:"12"`
+	Username     string     `json:"username" example:"alice"`
+	Email        string     `json:"email,omitempty" example:"alice@example.com"`
+	Role         Role       `json:"role" example:"user"`
+	DisabledAt   *time.Time `json:"disabled_at,omitempty"`
+	DeleteAfter  *time.Time `json:"delete_after,omitempty"`
+	TOTPEnabled  bool       `json:"totp_enabled"`
+	NoteCount    int64      `json:"note_count" example:"42"`
+	StorageBytes int64      `json:"storage_bytes" example:"1048576"` // вложения и картинки
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// SetUserDisabledRequest - блокировка или разблокировка пользователя
+type SetUserDisabledRequest struct {
+	Disabled bool `json:"disabled"`
+}
+
+// SetUserRoleRequest - смена роли пользователя
+type SetUserRoleRequest struct {
+	Role Role `json:"role" example:"admin"`
+}
+
+// AdminPasswordResetResponse - результат сброса пароля администратором. Ссылка отправляется
+// пользователю письмом; reset_token возвращается, только если письмо отправить не удалось
+type AdminPasswordResetResponse struct {
+	ResetToken string    `json:"reset_token,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	EmailSent  bool      `json:"email_sent"`
+}
+
+// UsageStats - сводка по использованию сервиса
+type UsageStats struct {
+	Users       UserStats    `json:"users"`
+	Notes       int64        `json:"notes" example:"1520"`
+	Attachments StorageStats `json:"attachments"`
+	Images      StorageStats `json:"images"`
+	APITokens   int64        `json:"api_tokens" example:"7"`
+}
+
+type UserStats struct {
+	Total           int64 `json:"total" example:"120"`
+	Admins          int64 `json:"admins" example:"2"`
+	Disabled        int64 `json:"disabled" example:"3"`
+	PendingDeletion int64 `json:"pending_deletion" example:"1"`
+	TwoFactor       int64 `json:"two_factor" example:"35"`
+	// NewLast30Days - зарегистрированы за последние 30 дней
+	NewLast30Days int64 `json:"new_last_30_days" example:"14"`
+}
+
+type StorageStats struct {
+	Count int64 `json:"count" example:"310"`
+	Bytes int64 `json:"bytes" example:"73400320"`
+}
@@ -0,0 +1,53 @@
+package model
+
+import "time"
+
+// Области действия API-токенов. Токен без write-областей даёт доступ только на чтение
+const (
+	ScopeRead        = "read"
+	ScopeNotesWrite  = "notes:write"
+	ScopeTablesWrite = "tables:write"
+)
+
+// IsValidScope проверяет, что область действия токена известна
+func IsValidScope(scope string) bool {
+	switch scope {
+	case ScopeRead, ScopeNotesWrite, ScopeTablesWrite:
+		return true
+	}
+	return false
+}
+
+// APIToken - именованный токен для скриптов и интеграций. Сам токен не хранится, только его хеш
+type APIToken struct {
+	ID         int64      `json:"id" example:"4"`
+	UserID     int64      `json:"-"`
+	Name       string     `json:"name" example:"Импорт из CRM"`
+	Scopes     []string   `json:"scopes" example:"read,notes:write"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// HasScope проверяет область действия. Любая write-область подразумевает чтение
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || scope == ScopeRead {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPITokenRequest - запрос на создание токена. Без expires_at токен бессрочный
+type CreateAPITokenRequest struct {
+	Name      string     `json:"name" example:"Импорт из CRM"`
+	Scopes    []string   `json:"scopes" example:"read,notes:write"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// CreateAPITokenResponse - созданный токен. Значение token показывается только один раз
+type CreateAPITokenResponse struct {
+	*APIToken
+	Token string `json:"token" example:"nat_Jx3..."`
+}
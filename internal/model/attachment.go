@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// Attachment - метаданные файла, прикреплённого к заметке. Содержимое лежит в BlobStore
+type Attachment struct {
+	ID          int64     `json:"id" example:"7"`
+	NoteID      int64     `json:"note_id" example:"1"`
+	UserID      int64     `json:"-"`
+	Filename    string    `json:"filename" example:"отчёт.pdf"`
+	ContentType string    `json:"content_type" example:"application/pdf"` // определяется по содержимому файла
+	Size        int64     `json:"size" example:"204800"`
+	StorageKey  string    `json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+}
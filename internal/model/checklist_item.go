@@ -1,13 +1,132 @@
 package model
 
-import "time"
+import (
+	"encoding/json"
+	"notes-api/internal/richtext"
+	"time"
+)
 
 type ChecklistItem struct {
-	ID        int64     `json:"id" example:"101"`
-	Text      string    `json:"text" example:"Купить молоко"`
-	Completed bool      `json:"completed" example:"false"`
-	Style     TextStyle `json:"style,omitempty" example:"italic"` // <-- НОВОЕ ПОЛЕ
-	NoteID    int64     `json:"note_id" example:"1"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID         int64            `json:"id" example:"101"`
+	Text       string           `json:"text" example:"Купить молоко"`
+	Completed  bool             `json:"completed" example:"false"`
+	Style      TextStyle        `json:"style,omitempty" example:"italic"` // <-- НОВОЕ ПОЛЕ
+	RichText   richtext.Inlines `json:"rich_text,omitempty"`              // форматированный текст; text и style вычисляются из него
+	NoteID     int64            `json:"note_id" example:"1"`
+	ParentID   *int64           `json:"parent_id,omitempty" example:"100"`
+	Position   int              `json:"position" example:"0"`
+	DueAt      *time.Time       `json:"due_at,omitempty" example:"2024-12-31T18:00:00Z"`
+	RemindAt   *time.Time       `json:"remind_at,omitempty" example:"2024-12-31T09:00:00Z"`
+	AssigneeID *int64           `json:"assignee_id,omitempty" example:"2"`
+	Recurrence string           `json:"recurrence,omitempty" example:"FREQ=WEEKLY;BYDAY=MO,TH"` // при выполнении создаётся следующий пункт
+	CreatedAt  time.Time        `json:"created_at"`
+	UpdatedAt  time.Time        `json:"updated_at"`
+	// Children заполняется только при получении чек-листа в виде дерева
+	Children []*ChecklistItem `json:"children,omitempty"`
+}
+
+// UpdateChecklistItemRequest - изменение пункта чек-листа. Если recurrence не передано,
+// правило повторения остаётся прежним; пустая строка отменяет повторение.
+// Так же не меняются не переданные due_at, remind_at и assignee_id; null их очищает
+type UpdateChecklistItemRequest struct {
+	ChecklistItem
+	Recurrence *string      `json:"recurrence,omitempty" example:"FREQ=WEEKLY;BYDAY=MO,TH"`
+	DueAt      OptionalTime `json:"due_at" swaggertype:"string" format:"date-time" example:"2024-12-31T18:00:00Z"`
+	RemindAt   OptionalTime `json:"remind_at" swaggertype:"string" format:"date-time" example:"2024-12-31T09:00:00Z"`
+	AssigneeID OptionalID   `json:"assignee_id" swaggertype:"integer" example:"2"`
+}
+
+// OptionalTime - время в запросе на изменение: поле не передано (Set = false), передано null или значение
+type OptionalTime struct {
+	Set   bool
+	Value *time.Time
+}
+
+func (o *OptionalTime) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	o.Value = nil
+	if string(data) == "null" {
+		return nil
+	}
+	return json.Unmarshal(data, &o.Value)
+}
+
+// Or возвращает переданное значение, а если поле не передано - current
+func (o OptionalTime) Or(current *time.Time) *time.Time {
+	if !o.Set {
+		return current
+	}
+	return o.Value
+}
+
+// OptionalID - ID в запросе на изменение: поле не передано (Set = false), передано null или значение
+type OptionalID struct {
+	Set   bool
+	Value *int64
+}
+
+func (o *OptionalID) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	o.Value = nil
+	if string(data) == "null" {
+		return nil
+	}
+	return json.Unmarshal(data, &o.Value)
+}
+
+// Or возвращает переданное значение, а если поле не передано - current
+func (o OptionalID) Or(current *int64) *int64 {
+	if !o.Set {
+		return current
+	}
+	return o.Value
+}
+
+// ReorderChecklistRequest - новый порядок пунктов одного уровня вложенности
+type ReorderChecklistRequest struct {
+	// ParentID не указывается для пунктов верхнего уровня
+	ParentID *int64 `json:"parent_id,omitempty" example:"100"`
+	// Должны быть перечислены все пункты этого уровня
+	ItemIDs []int64 `json:"item_ids" example:"[103,101,102]"`
+}
+
+// SetChecklistCompletedRequest - отметить все пункты заметки выполненными или невыполненными
+type SetChecklistCompletedRequest struct {
+	Completed bool `json:"completed" example:"true"`
+}
+
+// MoveChecklistItemsRequest - перенести пункты (вместе с вложенными) в другую заметку
+type MoveChecklistItemsRequest struct {
+	ItemIDs      []int64 `json:"item_ids" example:"[101,102]"`
+	TargetNoteID int64   `json:"target_note_id" example:"2"`
+}
+
+// StyleChecklistItemsRequest - применить стиль к нескольким пунктам
+type StyleChecklistItemsRequest struct {
+	ItemIDs []int64   `json:"item_ids" example:"[101,102]"`
+	Style   TextStyle `json:"style" example:"bold"`
+}
+
+// BulkChecklistResult - результат массовой операции с чек-листом
+type BulkChecklistResult struct {
+	Affected int64 `json:"affected" example:"12"`
+}
+
+// DueChecklistItems - просроченные и приближающиеся по сроку пункты чек-листов
+type DueChecklistItems struct {
+	Overdue  []*ChecklistItem `json:"overdue"`
+	Upcoming []*ChecklistItem `json:"upcoming"`
+}
+
+// ChecklistReminder - данные для отправки напоминания по пункту чек-листа
+type ChecklistReminder struct {
+	ItemID    int64      `json:"item_id"`
+	NoteID    int64      `json:"note_id"`
+	NoteTitle string     `json:"note_title"`
+	Text      string     `json:"text"`
+	DueAt     *time.Time `json:"due_at,omitempty"`
+	RemindAt  time.Time  `json:"remind_at"`
+	UserID    int64      `json:"user_id"`
+	Username  string     `json:"username"`
+	Email     string     `json:"-"`
 }
@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// Image - картинка, извлечённая из содержимого заметки. Содержимое и миниатюры лежат в BlobStore
+type Image struct {
+	ID          int64     `json:"id" example:"12"`
+	UserID      int64     `json:"-"`
+	ContentType string    `json:"content_type" example:"image/png"`
+	Width       int       `json:"width" example:"1920"`
+	Height      int       `json:"height" example:"1080"`
+	Size        int64     `json:"size" example:"254013"`
+	StorageKey  string    `json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+}
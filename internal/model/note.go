@@ -1,15 +1,61 @@
 package model
 
-import "time"
+import (
+	"notes-api/internal/richtext"
+	"time"
+)
 
 type Note struct {
-	ID             int64            `json:"id" example:"1"`
-	Title          string           `json:"title" example:"My First Note"`
-	Content        string           `json:"content" example:"This is the content of my first note."`
-	Style          TextStyle        `json:"style,omitempty" example:"bold"`
-	UserID         int64            `json:"user_id,omitempty"`
-	CreatedAt      time.Time        `json:"created_at"`
-	UpdatedAt      time.Time        `json:"updated_at"`
-	ChecklistItems []*ChecklistItem `json:"checklist_items,omitempty"`
-	Tables         []*NoteTable     `json:"tables,omitempty"`
+	ID               int64              `json:"id" example:"1"`
+	Title            string             `json:"title" example:"My First Note"`
+	Content          string             `json:"content" example:"This is the content of my first note."`
+	Style            TextStyle          `json:"style,omitempty" example:"bold"`
+	RichContent      *richtext.Document `json:"rich_content,omitempty"` // форматированный текст; content и style вычисляются из него для старых клиентов
+	UserID           int64              `json:"user_id,omitempty"`
+	Recurrence       string             `json:"recurrence,omitempty" example:"FREQ=WEEKLY;BYDAY=MO"` // правило повторения (подмножество RRULE)
+	NextOccurrenceAt *time.Time         `json:"next_occurrence_at,omitempty"`
+	Pinned           bool               `json:"pinned" example:"false"`   // закреплённые заметки идут в списке первыми
+	Archived         bool               `json:"archived" example:"false"` // архивные заметки не попадают в общий список
+	Color            NoteColor          `json:"color,omitempty" example:"yellow"`
+	CreatedAt        time.Time          `json:"created_at"`
+	UpdatedAt        time.Time          `json:"updated_at"`
+	ChecklistItems   []*ChecklistItem   `json:"checklist_items,omitempty"`
+	Tables           []*NoteTable       `json:"tables,omitempty"`
 }
+
+// UpdateNoteRequest - изменение заметки. Если recurrence не передано, правило повторения
+// и дата следующей копии остаются прежними; пустая строка отменяет повторение
+type UpdateNoteRequest struct {
+	Note
+	Recurrence *string `json:"recurrence,omitempty" example:"FREQ=WEEKLY;BYDAY=MO"`
+}
+
+// DuplicateNoteRequest - запрос на копирование заметки
+type DuplicateNoteRequest struct {
+	// Заголовок копии; если не указан, к заголовку добавляется " (копия)"
+	Title string `json:"title,omitempty" example:"Планёрка (копия)"`
+}
+
+// SetNotePinnedRequest - закрепить или открепить заметку
+type SetNotePinnedRequest struct {
+	Pinned bool `json:"pinned" example:"true"`
+}
+
+// SetNoteArchivedRequest - перенести заметку в архив или вернуть из него
+type SetNoteArchivedRequest struct {
+	Archived bool `json:"archived" example:"true"`
+}
+
+// SetNoteColorRequest - изменить цветовую метку заметки
+type SetNoteColorRequest struct {
+	Color NoteColor `json:"color" example:"yellow"`
+}
+
+// ContentFormat - представление содержимого заметки при чтении и записи через /content
+type ContentFormat string
+
+const (
+	ContentFormatJSON     ContentFormat = "json"
+	ContentFormatMarkdown ContentFormat = "markdown"
+	ContentFormatHTML     ContentFormat = "html"
+)
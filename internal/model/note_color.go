@@ -0,0 +1,26 @@
+package model
+
+// NoteColor - цветовая метка заметки. Пустая строка означает цвет по умолчанию
+type NoteColor string
+
+const (
+	ColorDefault NoteColor = ""
+	ColorRed     NoteColor = "red"
+	ColorOrange  NoteColor = "orange"
+	ColorYellow  NoteColor = "yellow"
+	ColorGreen   NoteColor = "green"
+	ColorTeal    NoteColor = "teal"
+	ColorBlue    NoteColor = "blue"
+	ColorPurple  NoteColor = "purple"
+	ColorPink    NoteColor = "pink"
+	ColorGray    NoteColor = "gray"
+)
+
+// IsValid проверяет, что цвет входит в палитру
+func (c NoteColor) IsValid() bool {
+	switch c {
+	case ColorDefault, ColorRed, ColorOrange, ColorYellow, ColorGreen, ColorTeal, ColorBlue, ColorPurple, ColorPink, ColorGray:
+		return true
+	}
+	return false
+}
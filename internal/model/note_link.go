@@ -0,0 +1,10 @@
+package model
+
+// NoteLink - вики-ссылка между заметками. В списке ссылок заметки NoteID и Title описывают
+// заметку, на которую она ссылается, в списке обратных ссылок - заметку, из которой сослались
+type NoteLink struct {
+	Ref      string `json:"ref" example:"Список покупок"` // текст внутри [[...]], например "Список покупок" или "id:12"
+	NoteID   *int64 `json:"note_id,omitempty" example:"12"`
+	Title    string `json:"title,omitempty" example:"Список покупок"`
+	Dangling bool   `json:"dangling"` // заметки, на которую ведёт ссылка, нет
+}
@@ -46,3 +46,34 @@ type AddTableRowRequest struct {
 	// Значения ячеек должны идти в том же порядке, что и колонки
 	Cells []string `json:"cells" example:"[\"Реализовать API\",\"2024-12-31\",\"В процессе\"]"`
 }
+
+// AddTableRowsRequest - запрос на пакетное добавление строк
+type AddTableRowsRequest struct {
+	// Каждая строка содержит значения ячеек в порядке колонок
+	Rows [][]string `json:"rows" example:"[[\"Реализовать API\",\"2024-12-31\",\"В процессе\"],[\"Написать тесты\",\"2025-01-15\",\"Новая\"]]"`
+}
+
+// MoveNoteTableRequest - перенести таблицу в другую заметку пользователя
+type MoveNoteTableRequest struct {
+	TargetNoteID int64 `json:"target_note_id" example:"2"`
+}
+
+// TableCellUpdate - новое содержимое одной ячейки
+type TableCellUpdate struct {
+	RowID    int64  `json:"row_id" example:"10"`
+	ColumnID int64  `json:"column_id" example:"3"`
+	Content  string `json:"content" example:"Готово"`
+}
+
+// UpdateTableCellsRequest - запрос на пакетное обновление ячеек
+type UpdateTableCellsRequest struct {
+	Cells []TableCellUpdate `json:"cells"`
+}
+
+// TableFormat - формат файла для импорта и экспорта таблиц
+type TableFormat string
+
+const (
+	TableFormatCSV  TableFormat = "csv"
+	TableFormatXLSX TableFormat = "xlsx"
+)
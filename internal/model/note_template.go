@@ -0,0 +1,49 @@
+package model
+
+import (
+	"notes-api/internal/richtext"
+	"time"
+)
+
+// NoteTemplate - заготовка заметки: текст, структура чек-листа и схемы таблиц без строк.
+// В заголовке, тексте, пунктах, названиях таблиц и колонок можно использовать переменные
+// вида {{date}}, {{time}}, {{datetime}}, {{user}} и произвольные переменные из запроса
+type NoteTemplate struct {
+	ID        int64                    `json:"id" example:"3"`
+	UserID    int64                    `json:"-"`
+	Name      string                   `json:"name" example:"Планёрка"`
+	Title     string                   `json:"title" example:"Планёрка {{date}}"`
+	Content   string                   `json:"content" example:"Ведущий: {{user}}"`
+	Style     TextStyle                `json:"style,omitempty" example:"normal"`
+	Checklist []*TemplateChecklistItem `json:"checklist"`
+	Tables    []*TemplateTable         `json:"tables"`
+	CreatedAt time.Time                `json:"created_at"`
+}
+
+// TemplateChecklistItem - пункт чек-листа шаблона. Выполненность, сроки и исполнители не сохраняются
+type TemplateChecklistItem struct {
+	Text     string                   `json:"text" example:"Обсудить задачи"`
+	Style    TextStyle                `json:"style,omitempty" example:"normal"`
+	RichText richtext.Inlines         `json:"rich_text,omitempty"`
+	Children []*TemplateChecklistItem `json:"children,omitempty"`
+}
+
+// TemplateTable - схема таблицы шаблона
+type TemplateTable struct {
+	Title   string   `json:"title" example:"Задачи"`
+	Columns []string `json:"columns" example:"[\"Задача\",\"Ответственный\",\"Срок\"]"`
+}
+
+// CreateTemplateRequest - сохранить заметку как шаблон
+type CreateTemplateRequest struct {
+	NoteID int64  `json:"note_id" example:"1"`
+	Name   string `json:"name" example:"Планёрка"`
+}
+
+// CreateNoteFromTemplateRequest - создать заметку по шаблону
+type CreateNoteFromTemplateRequest struct {
+	// Заголовок заметки; если не указан, берётся заголовок шаблона
+	Title string `json:"title,omitempty" example:"Планёрка отдела"`
+	// Значения переменных. Перекрывают встроенные date, time, datetime и user
+	Variables map[string]string `json:"variables,omitempty"`
+}
@@ -0,0 +1,70 @@
+package model
+
+import "time"
+
+// Locale - язык интерфейса. Сервер его только хранит: по нему клиент выбирает язык
+type Locale string
+
+const (
+	LocaleRu Locale = "ru"
+	LocaleEn Locale = "en"
+)
+
+// IsValid проверяет, что язык поддерживается
+func (l Locale) IsValid() bool {
+	switch l {
+	case LocaleRu, LocaleEn:
+		return true
+	}
+	return false
+}
+
+// NoteSort - порядок списка заметок. Закреплённые заметки всегда идут первыми
+type NoteSort string
+
+const (
+	// NoteSortUpdated - сначала недавно изменённые
+	NoteSortUpdated NoteSort = "updated"
+	// NoteSortCreated - сначала недавно созданные
+	NoteSortCreated NoteSort = "created"
+	// NoteSortTitle - по заголовку без учёта регистра
+	NoteSortTitle NoteSort = "title"
+)
+
+// IsValid проверяет, что порядок сортировки поддерживается
+func (s NoteSort) IsValid() bool {
+	switch s {
+	case NoteSortUpdated, NoteSortCreated, NoteSortTitle:
+		return true
+	}
+	return false
+}
+
+// Profile - данные и настройки текущего пользователя
+type Profile struct {
+	ID           int64     `json:"id" example:"12"`
+	Username     string    `json:"username" example:"alice"`
+	DisplayName  string    `json:"display_name" example:"Алиса"`
+	Email        string    `json:"email,omitempty" example:"alice@example.com"`
+	Locale       Locale    `json:"locale" example:"ru"`
+	Timezone     string    `json:"timezone" example:"Europe/Moscow"`
+	DefaultStyle TextStyle `json:"default_style" example:"normal"`
+	NoteSort     NoteSort  `json:"note_sort" example:"updated"`
+	Role         Role      `json:"role" example:"user"`
+	TOTPEnabled  bool      `json:"totp_enabled"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// UpdateProfileRequest - изменение профиля. Меняются только переданные поля; пустой email
+// удаляет адрес. Для смены email нужен текущий пароль; у аккаунта без пароля - код 2FA
+// или недавний повторный вход через провайдера
+type UpdateProfileRequest struct {
+	DisplayName     *string    `json:"display_name,omitempty" example:"Алиса"`
+	Email           *string    `json:"email,omitempty" example:"alice@example.com"`
+	Locale          *Locale    `json:"locale,omitempty" example:"en"`
+	Timezone        *string    `json:"timezone,omitempty" example:"Europe/Moscow"`
+	DefaultStyle    *TextStyle `json:"default_style,omitempty" example:"bold"`
+	NoteSort        *NoteSort  `json:"note_sort,omitempty" example:"title"`
+	CurrentPassword string     `json:"current_password,omitempty"`
+	Code            string     `json:"code,omitempty"`
+}
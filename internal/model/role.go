@@ -0,0 +1,18 @@
+package model
+
+// Role - роль пользователя. Администратор управляет пользователями через /admin
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleAdmin Role = "admin"
+)
+
+// IsValid проверяет, что роль входит в список поддерживаемых
+func (r Role) IsValid() bool {
+	switch r {
+	case RoleUser, RoleAdmin:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// Session - вход пользователя на устройстве. Отзыв сеанса делает недействительным его JWT
+type Session struct {
+	ID         int64     `json:"id" example:"17"`
+	UserID     int64     `json:"-"`
+	UserAgent  string    `json:"user_agent" example:"Mozilla/5.0 (X11; Linux x86_64) Firefox/128.0"`
+	IP         string    `json:"ip" example:"203.0.113.7"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// Current - сеанс, токеном которого выполнен запрос
+	Current bool `json:"current"`
+}
@@ -7,3 +7,12 @@ const (
 	StyleBold   TextStyle = "bold"
 	StyleItalic TextStyle = "italic"
 )
+
+// IsValid проверяет, что стиль входит в список поддерживаемых
+func (s TextStyle) IsValid() bool {
+	switch s {
+	case StyleNormal, StyleBold, StyleItalic:
+		return true
+	}
+	return false
+}
@@ -3,10 +3,32 @@ package model
 import "time"
 
 type User struct {
-	ID        int64     `json:"id"`
-	Username  string    `json:"username" binding:"required"`
-	Password  string    `json:"password" binding:"required"`
-	CreatedAt time.Time `json:"created_at"`
+	ID           int64      `json:"id"`
+	Username     string     `json:"username" binding:"required"`
+	Password     string     `json:"password" binding:"required"`
+	Email        string     `json:"email,omitempty" example:"user@example.com"` // нужен для восстановления пароля
+	TokenVersion int        `json:"-"`
+	DeleteAfter  *time.Time `json:"-"`
+	TOTPSecret   string     `json:"-"`
+	TOTPEnabled  bool       `json:"-"`
+	TOTPLastStep int64      `json:"-"`
+	Role         Role       `json:"-"`
+	DisabledAt   *time.Time `json:"-"`
+	DisplayName  string     `json:"-"`
+	Locale       Locale     `json:"-"`
+	Timezone     string     `json:"-"`
+	DefaultStyle TextStyle  `json:"-"`
+	NoteSort     NoteSort   `json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// Location возвращает часовой пояс пользователя. Если пояс не задан или неизвестен - UTC
+func (u *User) Location() *time.Location {
+	loc, err := time.LoadLocation(u.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
 }
 
 type LoginRequest struct {
@@ -14,6 +36,69 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+// LoginResponse содержит JWT. Если у пользователя включена двухфакторная аутентификация,
+// вместо него возвращается challenge_token, который обменивается на JWT через /auth/login/2fa
 type LoginResponse struct {
-	Token string `json:"token"`
+	Token          string `json:"token,omitempty"`
+	TwoFactor      bool   `json:"two_factor_required,omitempty"`
+	ChallengeToken string `json:"challenge_token,omitempty"`
+}
+
+// TwoFactorLoginRequest - второй шаг входа: код из приложения или код восстановления
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code" example:"123456"`
+}
+
+// TOTPSetupResponse - секрет для приложения-аутентификатора. Подключение нужно подтвердить кодом
+type TOTPSetupResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri" example:"otpauth://totp/Notes%20API:user?secret=...&issuer=Notes+API"`
+}
+
+// TOTPCodeRequest - код из приложения-аутентификатора
+type TOTPCodeRequest struct {
+	Code string `json:"code" example:"123456"`
+}
+
+// DisableTOTPRequest - отключение двухфакторной аутентификации, подтверждается паролем и кодом
+type DisableTOTPRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// RecoveryCodesResponse - коды восстановления. Показываются один раз
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// ChangePasswordRequest - смена пароля авторизованным пользователем. Аккаунт без пароля
+// подтверждает операцию кодом 2FA (code) или недавним повторным входом через провайдера
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+	Code            string `json:"code,omitempty"`
+}
+
+// ForgotPasswordRequest - запрос письма для сброса пароля по имени пользователя или email
+type ForgotPasswordRequest struct {
+	Login string `json:"login" example:"user@example.com"`
+}
+
+// ResetPasswordRequest - установка нового пароля по токену из письма
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// DeleteAccountRequest - запрос на удаление аккаунта, подтверждается паролем. Аккаунт без пароля
+// подтверждает удаление кодом 2FA (code) или недавним повторным входом через провайдера
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code,omitempty"`
+}
+
+// DeleteAccountResponse - когда аккаунт будет удалён окончательно
+type DeleteAccountResponse struct {
+	DeleteAfter time.Time `json:"delete_after"`
 }
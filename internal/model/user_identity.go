@@ -0,0 +1,37 @@
+package model
+
+import "time"
+
+// UserIdentity - внешняя учётная запись (OpenID Connect), через которую пользователь входит
+type UserIdentity struct {
+	ID          int64      `json:"id" example:"2"`
+	UserID      int64      `json:"-"`
+	Provider    string     `json:"provider" example:"corp"`
+	Subject     string     `json:"subject" example:"248289761001"`
+	Email       string     `json:"email,omitempty" example:"user@example.com"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+}
+
+// OIDCState - незавершённый вход через провайдера OpenID Connect
+type OIDCState struct {
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	// UserID не равен нулю, если внешняя учётная запись привязывается к аккаунту
+	UserID int64
+	// SessionID не равен нулю при повторном входе, которым пользователь без пароля
+	// подтверждает операции с аккаунтом в этом сеансе
+	SessionID int64
+	ExpiresAt time.Time
+}
+
+// OIDCAuthorizationResponse - адрес страницы провайдера, на которую нужно перейти
+type OIDCAuthorizationResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+// ReauthenticationResponse - до какого времени сеанс может менять пароль, email и удалять аккаунт
+type ReauthenticationResponse struct {
+	ExpiresAt time.Time `json:"expires_at"`
+}
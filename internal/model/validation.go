@@ -0,0 +1,8 @@
+package model
+
+// ValidationErrorResponse - ответ на запрос с некорректными полями: общий текст ошибки
+// и сообщение для каждого поля, которое нужно исправить
+type ValidationErrorResponse struct {
+	Error  string            `json:"error" example:"Некорректные данные"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
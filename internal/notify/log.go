@@ -0,0 +1,19 @@
+package notify
+
+import (
+	"context"
+	"log"
+)
+
+// LogNotifier пишет уведомления в лог сервера
+type LogNotifier struct{}
+
+func NewLogNotifier() *LogNotifier {
+	return &LogNotifier{}
+}
+
+func (n *LogNotifier) Notify(ctx context.Context, notification *Notification) error {
+	log.Printf("[NOTIFY] %s для %s (id=%d): %s - %s",
+		notification.Event, notification.Username, notification.UserID, notification.Subject, notification.Body)
+	return nil
+}
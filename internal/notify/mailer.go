@@ -0,0 +1,42 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"notes-api/internal/config"
+)
+
+// Mailer отправляет письма на адрес пользователя: ссылки для сброса пароля, уведомления об аккаунте
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// NewMailer создает Mailer по настройкам
+func NewMailer(cfg config.MailerConfig) (Mailer, error) {
+	switch cfg.Type {
+	case "", "log":
+		return &LogMailer{}, nil
+	case "smtp":
+		return &SMTPMailer{cfg: cfg.SMTP}, nil
+	default:
+		return nil, fmt.Errorf("неизвестный тип отправки писем: %s", cfg.Type)
+	}
+}
+
+// LogMailer пишет письма в лог сервера. Подходит для разработки без почтового сервера
+type LogMailer struct{}
+
+func (m *LogMailer) Send(ctx context.Context, to, subject, body string) error {
+	log.Printf("[MAIL] для %s: %s\n%s", to, subject, body)
+	return nil
+}
+
+// SMTPMailer отправляет письма через SMTP. Для разработки подходит Mailpit из docker-compose
+type SMTPMailer struct {
+	cfg config.SMTPConfig
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, to, subject, body string) error {
+	return SendMail(m.cfg, to, subject, body)
+}
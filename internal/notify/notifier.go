@@ -0,0 +1,41 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"notes-api/internal/config"
+)
+
+// Notification - уведомление для пользователя
+type Notification struct {
+	Event    string `json:"event"`
+	UserID   int64  `json:"user_id"`
+	Username string `json:"username"`
+	// Email - адрес получателя; пустой, если пользователь его не указал
+	Email   string      `json:"email,omitempty"`
+	Subject string      `json:"subject"`
+	Body    string      `json:"body"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// Notifier доставляет уведомления пользователям
+type Notifier interface {
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// New создает Notifier по настройкам
+func New(cfg config.NotifierConfig) (Notifier, error) {
+	switch cfg.Type {
+	case "", "log":
+		return NewLogNotifier(), nil
+	case "webhook":
+		if cfg.WebhookURL == "" {
+			return nil, fmt.Errorf("не задан URL для webhook-уведомлений")
+		}
+		return NewWebhookNotifier(cfg.WebhookURL), nil
+	case "smtp":
+		return NewSMTPNotifier(cfg.SMTP), nil
+	default:
+		return nil, fmt.Errorf("неизвестный тип уведомлений: %s", cfg.Type)
+	}
+}
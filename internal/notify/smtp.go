@@ -0,0 +1,57 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"notes-api/internal/config"
+	"strings"
+)
+
+// SMTPNotifier отправляет уведомления письмом. Для разработки подходит
+// локальный SMTP-сервер без авторизации (например, Mailpit).
+type SMTPNotifier struct {
+	cfg config.SMTPConfig
+}
+
+func NewSMTPNotifier(cfg config.SMTPConfig) *SMTPNotifier {
+	return &SMTPNotifier{cfg: cfg}
+}
+
+// Notify отправляет письмо на email получателя. Если задан SMTP_TO, все письма уходят
+// на него. Уведомление для пользователя без email пропускается
+func (n *SMTPNotifier) Notify(ctx context.Context, notification *Notification) error {
+	to := n.cfg.To
+	if to == "" {
+		to = notification.Email
+	}
+	if to == "" {
+		log.Printf("Уведомление %s для пользователя %d не отправлено: email не указан", notification.Event, notification.UserID)
+		return nil
+	}
+	return SendMail(n.cfg, to, notification.Subject, notification.Body)
+}
+
+// SendMail отправляет текстовое письмо в UTF-8
+func SendMail(cfg config.SMTPConfig, to, subject, body string) error {
+	addr := net.JoinHostPort(cfg.Host, cfg.Port)
+
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return smtp.SendMail(addr, auth, cfg.From, []string{to}, []byte(msg.String()))
+}
@@ -0,0 +1,67 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"notes-api/internal/util"
+	"time"
+)
+
+// Не чаще этого JWKS перезагружается из-за неизвестного kid: провайдер мог сменить ключи,
+// но подделанный kid не должен приводить к запросу к провайдеру на каждый вход
+const jwksRefreshInterval = time.Minute
+
+type keySet struct {
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+// key возвращает открытый ключ провайдера по kid, при необходимости перезагружая JWKS
+func (p *Provider) key(ctx context.Context, meta *discovery, kid string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys.lookup(kid); ok {
+		return key, nil
+	}
+	if p.keys != nil && time.Since(p.keys.fetchedAt) < jwksRefreshInterval {
+		return nil, fmt.Errorf("неизвестный ключ подписи %q", kid)
+	}
+
+	var set util.JWKS
+	if err := p.getJSON(ctx, meta.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("не удалось загрузить ключи провайдера %s: %w", p.cfg.Name, err)
+	}
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			// Ключи неподдерживаемых типов пропускаются
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	p.keys = &keySet{keys: keys, fetchedAt: time.Now()}
+
+	if key, ok := p.keys.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("неизвестный ключ подписи %q", kid)
+}
+
+// lookup ищет ключ по kid. Токен без kid принимается, только если ключ один
+func (s *keySet) lookup(kid string) (interface{}, bool) {
+	if s == nil {
+		return nil, false
+	}
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	key, ok := s.keys[kid]
+	return key, ok
+}
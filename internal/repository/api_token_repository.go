@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"notes-api/internal/model"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Чаще этого last_used_at не обновляется, чтобы не писать в БД на каждый запрос
+const apiTokenTouchInterval = time.Minute
+
+type APITokenRepository interface {
+	Create(t *model.APIToken, tokenHash string) error
+	GetAll(userID int64) ([]*model.APIToken, error)
+	Delete(id, userID int64) error
+	GetActiveByHash(tokenHash string, now time.Time) (*model.APIToken, error)
+	TouchLastUsed(id int64, now time.Time) error
+}
+
+const apiTokenColumns = `id, user_id, name, scopes, expires_at, last_used_at, created_at`
+
+func scanAPIToken(s rowScanner) (*model.APIToken, error) {
+	t := new(model.APIToken)
+	var expiresAt, lastUsedAt sql.NullTime
+	err := s.Scan(&t.ID, &t.UserID, &t.Name, pq.Array(&t.Scopes), &expiresAt, &lastUsedAt, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		t.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		t.LastUsedAt = &lastUsedAt.Time
+	}
+	return t, nil
+}
+
+type PostgresAPITokenRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresAPITokenRepository(db *sql.DB) APITokenRepository {
+	return &PostgresAPITokenRepository{db: db}
+}
+
+func (r *PostgresAPITokenRepository) Create(t *model.APIToken, tokenHash string) error {
+	query := `INSERT INTO api_tokens (user_id, name, token_hash, scopes, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;`
+	t.CreatedAt = time.Now()
+	return r.db.QueryRow(query, t.UserID, t.Name, tokenHash, pq.Array(t.Scopes), t.ExpiresAt, t.CreatedAt).Scan(&t.ID)
+}
+
+func (r *PostgresAPITokenRepository) GetAll(userID int64) ([]*model.APIToken, error) {
+	rows, err := r.db.Query(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE user_id = $1 ORDER BY created_at DESC, id DESC;`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]*model.APIToken, 0)
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func (r *PostgresAPITokenRepository) Delete(id, userID int64) error {
+	res, err := r.db.Exec(`DELETE FROM api_tokens WHERE id = $1 AND user_id = $2;`, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("токен не найден")
+	}
+	return nil
+}
+
+// GetActiveByHash ищет неистёкший токен. Токены заблокированных аккаунтов и аккаунтов,
+// ожидающих удаления, не возвращаются
+func (r *PostgresAPITokenRepository) GetActiveByHash(tokenHash string, now time.Time) (*model.APIToken, error) {
+	query := `SELECT t.id, t.user_id, t.name, t.scopes, t.expires_at, t.last_used_at, t.created_at
+		FROM api_tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = $1 AND (t.expires_at IS NULL OR t.expires_at > $2) AND u.delete_after IS NULL AND u.disabled_at IS NULL;`
+	t, err := scanAPIToken(r.db.QueryRow(query, tokenHash, now))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("токен не найден или истёк")
+	}
+	return t, err
+}
+
+// TouchLastUsed обновляет время последнего использования не чаще раза в apiTokenTouchInterval
+func (r *PostgresAPITokenRepository) TouchLastUsed(id int64, now time.Time) error {
+	query := `UPDATE api_tokens SET last_used_at = $2 WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $3);`
+	_, err := r.db.Exec(query, id, now, now.Add(-apiTokenTouchInterval))
+	return err
+}
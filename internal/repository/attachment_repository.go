@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"notes-api/internal/model"
+	"time"
+)
+
+// ErrAttachmentNotFound - вложения нет или оно прикреплено к другой заметке
+var ErrAttachmentNotFound = errors.New("вложение не найдено")
+
+type AttachmentRepository interface {
+	BeginTx() (*sql.Tx, error)
+	LockUsageTx(tx *sql.Tx, userID int64) (int64, error)
+	CreateTx(tx *sql.Tx, attachment *model.Attachment) error
+	GetByNoteID(noteID int64) ([]*model.Attachment, error)
+	GetByID(id, noteID int64) (*model.Attachment, error)
+	Delete(id int64) error
+	GetStorageKeysByNoteID(noteID int64) ([]string, error)
+	GetStorageKeysByUserID(userID int64) ([]string, error)
+}
+
+const attachmentColumns = `id, note_id, user_id, filename, content_type, size, storage_key, created_at`
+
+func scanAttachment(s rowScanner) (*model.Attachment, error) {
+	a := new(model.Attachment)
+	err := s.Scan(&a.ID, &a.NoteID, &a.UserID, &a.Filename, &a.ContentType, &a.Size, &a.StorageKey, &a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+type PostgresAttachmentRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresAttachmentRepository(db *sql.DB) AttachmentRepository {
+	return &PostgresAttachmentRepository{db: db}
+}
+
+func (r *PostgresAttachmentRepository) BeginTx() (*sql.Tx, error) {
+	return r.db.Begin()
+}
+
+// LockUsageTx блокирует строку пользователя до конца транзакции и возвращает занятый им объём.
+// Так параллельные загрузки одного пользователя не превысят квоту.
+func (r *PostgresAttachmentRepository) LockUsageTx(tx *sql.Tx, userID int64) (int64, error) {
+	return lockStorageUsageTx(tx, userID)
+}
+
+// lockStorageUsageTx блокирует строку пользователя и считает объём его вложений и картинок:
+// они делят одну квоту
+func lockStorageUsageTx(tx *sql.Tx, userID int64) (int64, error) {
+	if _, err := tx.Exec(`SELECT id FROM users WHERE id = $1 FOR UPDATE;`, userID); err != nil {
+		return 0, err
+	}
+	var used int64
+	err := tx.QueryRow(`SELECT (SELECT COALESCE(SUM(size), 0) FROM attachments WHERE user_id = $1)
+		+ (SELECT COALESCE(SUM(size), 0) FROM images WHERE user_id = $1);`, userID).Scan(&used)
+	return used, err
+}
+
+func (r *PostgresAttachmentRepository) CreateTx(tx *sql.Tx, a *model.Attachment) error {
+	query := `INSERT INTO attachments (note_id, user_id, filename, content_type, size, storage_key, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id;`
+	a.CreatedAt = time.Now()
+	return tx.QueryRow(query, a.NoteID, a.UserID, a.Filename, a.ContentType, a.Size, a.StorageKey, a.CreatedAt).Scan(&a.ID)
+}
+
+func (r *PostgresAttachmentRepository) GetByNoteID(noteID int64) ([]*model.Attachment, error) {
+	rows, err := r.db.Query(`SELECT `+attachmentColumns+` FROM attachments WHERE note_id = $1 ORDER BY created_at, id;`, noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := make([]*model.Attachment, 0)
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
+
+// GetByID возвращает вложение, только если оно относится к заметке noteID
+func (r *PostgresAttachmentRepository) GetByID(id, noteID int64) (*model.Attachment, error) {
+	a, err := scanAttachment(r.db.QueryRow(`SELECT `+attachmentColumns+` FROM attachments WHERE id = $1 AND note_id = $2;`, id, noteID))
+	if err == sql.ErrNoRows {
+		return nil, ErrAttachmentNotFound
+	}
+	return a, err
+}
+
+func (r *PostgresAttachmentRepository) Delete(id int64) error {
+	_, err := r.db.Exec(`DELETE FROM attachments WHERE id = $1;`, id)
+	return err
+}
+
+// GetStorageKeysByNoteID возвращает ключи содержимого всех вложений заметки
+func (r *PostgresAttachmentRepository) GetStorageKeysByNoteID(noteID int64) ([]string, error) {
+	return queryStorageKeys(r.db, `SELECT storage_key FROM attachments WHERE note_id = $1;`, noteID)
+}
+
+// GetStorageKeysByUserID возвращает ключи содержимого всех вложений пользователя
+func (r *PostgresAttachmentRepository) GetStorageKeysByUserID(userID int64) ([]string, error) {
+	return queryStorageKeys(r.db, `SELECT storage_key FROM attachments WHERE user_id = $1;`, userID)
+}
+
+func queryStorageKeys(db *sql.DB, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
@@ -3,10 +3,60 @@ package repository
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"notes-api/internal/model"
+	"notes-api/internal/richtext"
+	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
+var checklistItemFields = []string{
+	"id", "text", "completed", "note_id", "style", "rich_text", "parent_id", `"position"`,
+	"due_at", "remind_at", "assignee_id", "recurrence", "created_at", "updated_at",
+}
+
+var checklistItemColumns = checklistItemColumnsAs("")
+
+// checklistItemColumnsAs возвращает список колонок с префиксом псевдонима таблицы
+func checklistItemColumnsAs(alias string) string {
+	if alias == "" {
+		return strings.Join(checklistItemFields, ", ")
+	}
+	cols := make([]string, len(checklistItemFields))
+	for i, f := range checklistItemFields {
+		cols[i] = alias + "." + f
+	}
+	return strings.Join(cols, ", ")
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// execer и queryer позволяют выполнять запросы как через *sql.DB, так и внутри *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+type queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func scanChecklistItem(s rowScanner) (*model.ChecklistItem, error) {
+	item := new(model.ChecklistItem)
+	err := s.Scan(&item.ID, &item.Text, &item.Completed, &item.NoteID, &item.Style, &item.RichText, &item.ParentID, &item.Position,
+		&item.DueAt, &item.RemindAt, &item.AssigneeID, &item.Recurrence, &item.CreatedAt, &item.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if item.RichText == nil {
+		item.RichText = richtext.InlinesFromLegacy(item.Text, string(item.Style))
+	}
+	return item, nil
+}
+
 type PostgresChecklistItemRepository struct {
 	db *sql.DB
 }
@@ -15,19 +65,44 @@ func NewPostgresChecklistItemRepository(db *sql.DB) ChecklistItemRepository {
 	return &PostgresChecklistItemRepository{db: db}
 }
 
+func (r *PostgresChecklistItemRepository) BeginTx() (*sql.Tx, error) {
+	return r.db.Begin()
+}
+
 func (r *PostgresChecklistItemRepository) Create(item *model.ChecklistItem) error {
-	query := `INSERT INTO checklist_items (text, note_id, style) VALUES ($1, $2, $3) RETURNING id, completed, created_at, updated_at;`
+	return createChecklistItem(r.db, item)
+}
+
+func (r *PostgresChecklistItemRepository) CreateTx(tx *sql.Tx, item *model.ChecklistItem) error {
+	return createChecklistItem(tx, item)
+}
+
+func createChecklistItem(db queryer, item *model.ChecklistItem) error {
+	// Новый пункт добавляется в конец своего уровня вложенности
+	query := `INSERT INTO checklist_items (text, note_id, style, parent_id, "position", due_at, remind_at, assignee_id, recurrence, rich_text)
+		VALUES ($1, $2, $3, $4, (SELECT COALESCE(MAX("position"), -1) + 1 FROM checklist_items WHERE note_id = $2 AND parent_id IS NOT DISTINCT FROM $4), $5, $6, $7, $8, $9)
+		RETURNING id, completed, "position", created_at, updated_at;`
 	now := time.Now()
 	if item.Style == "" {
 		item.Style = model.StyleNormal
 	}
-	err := r.db.QueryRow(query, item.Text, item.NoteID, item.Style).Scan(&item.ID, &item.Completed, &item.CreatedAt, &item.UpdatedAt)
+	err := db.QueryRow(query, item.Text, item.NoteID, item.Style, item.ParentID, item.DueAt, item.RemindAt, item.AssigneeID, item.Recurrence, item.RichText).
+		Scan(&item.ID, &item.Completed, &item.Position, &item.CreatedAt, &item.UpdatedAt)
 	item.UpdatedAt = now
 	return err
 }
 
+// GetByNoteID возвращает пункты чек-листа плоским списком в порядке документа:
+// каждый пункт идёт сразу после своего родителя и предыдущих соседей
 func (r *PostgresChecklistItemRepository) GetByNoteID(noteID int64) ([]*model.ChecklistItem, error) {
-	query := `SELECT id, text, completed, note_id, style, created_at, updated_at FROM checklist_items WHERE note_id = $1 ORDER BY created_at ASC;`
+	query := `WITH RECURSIVE tree AS (
+			SELECT id, ARRAY["position"::bigint, id] AS path
+			FROM checklist_items WHERE note_id = $1 AND parent_id IS NULL
+			UNION ALL
+			SELECT c.id, t.path || ARRAY[c."position"::bigint, c.id]
+			FROM checklist_items c JOIN tree t ON c.parent_id = t.id
+		)
+		SELECT ` + checklistItemColumnsAs("ci") + ` FROM checklist_items ci JOIN tree t ON t.id = ci.id ORDER BY t.path;`
 	rows, err := r.db.Query(query, noteID)
 	if err != nil {
 		return nil, err
@@ -36,19 +111,18 @@ func (r *PostgresChecklistItemRepository) GetByNoteID(noteID int64) ([]*model.Ch
 
 	var items []*model.ChecklistItem
 	for rows.Next() {
-		item := new(model.ChecklistItem)
-		if err := rows.Scan(&item.ID, &item.Text, &item.Completed, &item.NoteID, &item.Style, &item.CreatedAt, &item.UpdatedAt); err != nil {
+		item, err := scanChecklistItem(rows)
+		if err != nil {
 			return nil, err
 		}
 		items = append(items, item)
 	}
-	return items, nil
+	return items, rows.Err()
 }
 
 func (r *PostgresChecklistItemRepository) GetByID(itemID int64) (*model.ChecklistItem, error) {
-	query := `SELECT id, text, completed, note_id, style, created_at, updated_at FROM checklist_items WHERE id = $1;`
-	item := new(model.ChecklistItem)
-	err := r.db.QueryRow(query, itemID).Scan(&item.ID, &item.Text, &item.Completed, &item.NoteID, &item.Style, &item.CreatedAt, &item.UpdatedAt)
+	query := `SELECT ` + checklistItemColumns + ` FROM checklist_items WHERE id = $1;`
+	item, err := scanChecklistItem(r.db.QueryRow(query, itemID))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errors.New("элемент чек-листа не найден")
@@ -59,12 +133,38 @@ func (r *PostgresChecklistItemRepository) GetByID(itemID int64) (*model.Checklis
 }
 
 func (r *PostgresChecklistItemRepository) Update(item *model.ChecklistItem) error {
-	query := `UPDATE checklist_items SET text = $1, completed = $2, style = $3, updated_at = $4 WHERE id = $5;`
+	return updateChecklistItem(r.db, item)
+}
+
+func (r *PostgresChecklistItemRepository) UpdateTx(tx *sql.Tx, item *model.ChecklistItem) error {
+	return updateChecklistItem(tx, item)
+}
+
+// SetChildrenCompletedTx переносит статус выполнения на все вложенные пункты
+func (r *PostgresChecklistItemRepository) SetChildrenCompletedTx(tx *sql.Tx, itemID int64, completed bool) error {
+	query := `WITH RECURSIVE tree AS (
+			SELECT id FROM checklist_items WHERE parent_id = $1
+			UNION ALL
+			SELECT c.id FROM checklist_items c JOIN tree t ON c.parent_id = t.id
+		)
+		UPDATE checklist_items SET completed = $2, updated_at = $3 WHERE id IN (SELECT id FROM tree);`
+	_, err := tx.Exec(query, itemID, completed, time.Now())
+	return err
+}
+
+// updateChecklistItem сохраняет изменяемые поля пункта.
+// При переносе времени напоминания оно снова становится неотправленным.
+func updateChecklistItem(db execer, item *model.ChecklistItem) error {
+	query := `UPDATE checklist_items SET text = $1, completed = $2, style = $3, updated_at = $4,
+			due_at = $5, assignee_id = $6, recurrence = $7,
+			reminded_at = CASE WHEN remind_at IS DISTINCT FROM $8 THEN NULL ELSE reminded_at END,
+			remind_at = $8, rich_text = $9
+		WHERE id = $10;`
 	item.UpdatedAt = time.Now()
 	if item.Style == "" {
 		item.Style = model.StyleNormal
 	}
-	_, err := r.db.Exec(query, item.Text, item.Completed, item.Style, item.UpdatedAt, item.ID)
+	_, err := db.Exec(query, item.Text, item.Completed, item.Style, item.UpdatedAt, item.DueAt, item.AssigneeID, item.Recurrence, item.RemindAt, item.RichText, item.ID)
 	return err
 }
 
@@ -73,3 +173,291 @@ func (r *PostgresChecklistItemRepository) Delete(itemID int64) error {
 	_, err := r.db.Exec(query, itemID)
 	return err
 }
+
+// Move переносит пункт на уровень parentID в позицию position,
+// сдвигая соседей на старом и новом уровне
+func (r *PostgresChecklistItemRepository) Move(itemID int64, parentID *int64, position int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var noteID int64
+	var oldParentID *int64
+	var oldPosition int
+	err = tx.QueryRow(`SELECT note_id, parent_id, "position" FROM checklist_items WHERE id = $1 FOR UPDATE;`, itemID).Scan(&noteID, &oldParentID, &oldPosition)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("элемент чек-листа не найден")
+		}
+		return err
+	}
+
+	if err := lockNoteChecklist(tx, noteID); err != nil {
+		return err
+	}
+
+	closeGap := `UPDATE checklist_items SET "position" = "position" - 1
+		WHERE note_id = $1 AND parent_id IS NOT DISTINCT FROM $2 AND "position" > $3;`
+	if _, err := tx.Exec(closeGap, noteID, oldParentID, oldPosition); err != nil {
+		return err
+	}
+
+	openGap := `UPDATE checklist_items SET "position" = "position" + 1
+		WHERE note_id = $1 AND parent_id IS NOT DISTINCT FROM $2 AND "position" >= $3 AND id <> $4;`
+	if _, err := tx.Exec(openGap, noteID, parentID, position, itemID); err != nil {
+		return err
+	}
+
+	move := `UPDATE checklist_items SET parent_id = $1, "position" = $2, updated_at = $3 WHERE id = $4;`
+	if _, err := tx.Exec(move, parentID, position, time.Now(), itemID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Reorder задаёт порядок всех пунктов одного уровня вложенности
+func (r *PostgresChecklistItemRepository) Reorder(noteID int64, parentID *int64, itemIDs []int64) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockNoteChecklist(tx, noteID); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT id FROM checklist_items WHERE note_id = $1 AND parent_id IS NOT DISTINCT FROM $2;`, noteID, parentID)
+	if err != nil {
+		return err
+	}
+	siblings := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		siblings[id] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(itemIDs) != len(siblings) {
+		return fmt.Errorf("нужно перечислить все пункты уровня: ожидалось %d, передано %d", len(siblings), len(itemIDs))
+	}
+	seen := make(map[int64]bool, len(itemIDs))
+	for _, id := range itemIDs {
+		if !siblings[id] || seen[id] {
+			return fmt.Errorf("пункт %d не относится к этому уровню чек-листа или указан повторно", id)
+		}
+		seen[id] = true
+	}
+
+	query := `UPDATE checklist_items SET "position" = u.ord - 1, updated_at = $2
+		FROM unnest($1::bigint[]) WITH ORDINALITY AS u(id, ord)
+		WHERE checklist_items.id = u.id;`
+	if _, err := tx.Exec(query, pq.Array(itemIDs), time.Now()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetCompletedForNoteTx отмечает все пункты заметки выполненными или невыполненными
+func (r *PostgresChecklistItemRepository) SetCompletedForNoteTx(tx *sql.Tx, noteID int64, completed bool) (int64, error) {
+	query := `UPDATE checklist_items SET completed = $1, updated_at = $2 WHERE note_id = $3 AND completed <> $1;`
+	res, err := tx.Exec(query, completed, time.Now(), noteID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteCompleted удаляет выполненные пункты заметки вместе с их вложенными пунктами
+func (r *PostgresChecklistItemRepository) DeleteCompleted(noteID int64) (int64, error) {
+	res, err := r.db.Exec(`DELETE FROM checklist_items WHERE note_id = $1 AND completed = TRUE;`, noteID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// MoveToNote переносит пункты вместе со всеми вложенными в другую заметку.
+// Пункты, родитель которых не переносится, становятся пунктами верхнего уровня
+// и добавляются в конец чек-листа целевой заметки в прежнем порядке.
+func (r *PostgresChecklistItemRepository) MoveToNote(noteID, targetNoteID int64, itemIDs []int64) (int64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Блокируем обе заметки в порядке ID, чтобы встречные переносы не взаимоблокировались
+	first, second := noteID, targetNoteID
+	if first > second {
+		first, second = second, first
+	}
+	if err := lockNoteChecklist(tx, first); err != nil {
+		return 0, err
+	}
+	if err := lockNoteChecklist(tx, second); err != nil {
+		return 0, err
+	}
+
+	if err := checkItemsBelongToNote(tx, noteID, itemIDs); err != nil {
+		return 0, err
+	}
+
+	var base int
+	err = tx.QueryRow(`SELECT COALESCE(MAX("position"), -1) + 1 FROM checklist_items WHERE note_id = $1 AND parent_id IS NULL;`, targetNoteID).Scan(&base)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `WITH RECURSIVE moved AS (
+			SELECT id FROM checklist_items WHERE note_id = $1 AND id = ANY($2)
+			UNION
+			SELECT c.id FROM checklist_items c JOIN moved m ON c.parent_id = m.id
+		),
+		roots AS (
+			SELECT c.id, ROW_NUMBER() OVER (ORDER BY c.parent_id NULLS FIRST, c."position", c.id) - 1 AS rn
+			FROM checklist_items c
+			WHERE c.id IN (SELECT id FROM moved)
+				AND (c.parent_id IS NULL OR c.parent_id NOT IN (SELECT id FROM moved))
+		)
+		UPDATE checklist_items c SET
+			note_id = $3,
+			parent_id = CASE WHEN roots.id IS NULL THEN c.parent_id ELSE NULL END,
+			"position" = CASE WHEN roots.id IS NULL THEN c."position" ELSE $4 + roots.rn END,
+			updated_at = $5
+		FROM moved LEFT JOIN roots ON roots.id = moved.id
+		WHERE c.id = moved.id;`
+	res, err := tx.Exec(query, noteID, pq.Array(itemIDs), targetNoteID, base, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return affected, tx.Commit()
+}
+
+// SetStyle применяет стиль к указанным пунктам заметки
+func (r *PostgresChecklistItemRepository) SetStyle(noteID int64, itemIDs []int64, style model.TextStyle) (int64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := checkItemsBelongToNote(tx, noteID, itemIDs); err != nil {
+		return 0, err
+	}
+
+	// Форматированный текст сбрасывается и при чтении строится заново из text и нового style
+	query := `UPDATE checklist_items SET style = $1, rich_text = NULL, updated_at = $2 WHERE note_id = $3 AND id = ANY($4);`
+	res, err := tx.Exec(query, style, time.Now(), noteID, pq.Array(itemIDs))
+	if err != nil {
+		return 0, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return affected, tx.Commit()
+}
+
+// checkItemsBelongToNote проверяет, что все пункты относятся к заметке
+func checkItemsBelongToNote(tx *sql.Tx, noteID int64, itemIDs []int64) error {
+	if len(itemIDs) == 0 {
+		return errors.New("не передано ни одного пункта")
+	}
+
+	unique := make(map[int64]bool, len(itemIDs))
+	for _, id := range itemIDs {
+		unique[id] = true
+	}
+
+	var found int
+	query := `SELECT COUNT(*) FROM checklist_items WHERE note_id = $1 AND id = ANY($2);`
+	if err := tx.QueryRow(query, noteID, pq.Array(itemIDs)).Scan(&found); err != nil {
+		return err
+	}
+	if found != len(unique) {
+		return errors.New("некоторые пункты не найдены в этой заметке")
+	}
+	return nil
+}
+
+// lockNoteChecklist сериализует изменения порядка пунктов в рамках одной заметки
+func lockNoteChecklist(tx *sql.Tx, noteID int64) error {
+	_, err := tx.Exec(`SELECT 1 FROM notes WHERE id = $1 FOR UPDATE;`, noteID)
+	return err
+}
+
+// GetDue возвращает невыполненные пункты со сроком не позже until из заметок пользователя
+// или назначенные ему, отсортированные по сроку
+func (r *PostgresChecklistItemRepository) GetDue(userID int64, until time.Time) ([]*model.ChecklistItem, error) {
+	query := `SELECT ` + checklistItemColumnsAs("ci") + `
+		FROM checklist_items ci JOIN notes n ON n.id = ci.note_id
+		WHERE ci.completed = FALSE AND ci.due_at IS NOT NULL AND ci.due_at <= $2
+			AND (n.user_id = $1 OR ci.assignee_id = $1)
+		ORDER BY ci.due_at ASC, ci.id ASC;`
+	rows, err := r.db.Query(query, userID, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]*model.ChecklistItem, 0)
+	for rows.Next() {
+		item, err := scanChecklistItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// GetPendingReminders возвращает неотправленные напоминания, время которых уже наступило.
+// Получателем считается исполнитель пункта, а если он не назначен - владелец заметки.
+func (r *PostgresChecklistItemRepository) GetPendingReminders(now time.Time, limit int) ([]*model.ChecklistReminder, error) {
+	query := `SELECT ci.id, ci.note_id, n.title, ci.text, ci.due_at, ci.remind_at, u.id, u.username, COALESCE(u.email, '')
+		FROM checklist_items ci
+		JOIN notes n ON n.id = ci.note_id
+		JOIN users u ON u.id = COALESCE(ci.assignee_id, n.user_id)
+		WHERE ci.completed = FALSE AND ci.remind_at IS NOT NULL AND ci.remind_at <= $1 AND ci.reminded_at IS NULL
+		ORDER BY ci.remind_at ASC
+		LIMIT $2;`
+	rows, err := r.db.Query(query, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reminders []*model.ChecklistReminder
+	for rows.Next() {
+		rem := new(model.ChecklistReminder)
+		if err := rows.Scan(&rem.ItemID, &rem.NoteID, &rem.NoteTitle, &rem.Text, &rem.DueAt, &rem.RemindAt, &rem.UserID, &rem.Username, &rem.Email); err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, rem)
+	}
+	return reminders, rows.Err()
+}
+
+// MarkReminded отмечает напоминание отправленным
+func (r *PostgresChecklistItemRepository) MarkReminded(itemID int64, at time.Time) error {
+	_, err := r.db.Exec(`UPDATE checklist_items SET reminded_at = $1 WHERE id = $2;`, at, itemID)
+	return err
+}
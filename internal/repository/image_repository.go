@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"notes-api/internal/model"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type ImageRepository interface {
+	BeginTx() (*sql.Tx, error)
+	LockUsageTx(tx *sql.Tx, userID int64) (int64, error)
+	CreateTx(tx *sql.Tx, img *model.Image) error
+	GetByID(id, userID int64) (*model.Image, error)
+	GetStorageKeysByUserID(userID int64) ([]string, error)
+	DeleteUnreferenced(userID int64, ids []int64, urlPrefix string) ([]string, error)
+}
+
+type PostgresImageRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresImageRepository(db *sql.DB) ImageRepository {
+	return &PostgresImageRepository{db: db}
+}
+
+func (r *PostgresImageRepository) BeginTx() (*sql.Tx, error) {
+	return r.db.Begin()
+}
+
+// LockUsageTx блокирует строку пользователя до конца транзакции и возвращает занятый им объём
+func (r *PostgresImageRepository) LockUsageTx(tx *sql.Tx, userID int64) (int64, error) {
+	return lockStorageUsageTx(tx, userID)
+}
+
+func (r *PostgresImageRepository) CreateTx(tx *sql.Tx, img *model.Image) error {
+	query := `INSERT INTO images (user_id, content_type, width, height, size, storage_key, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id;`
+	img.CreatedAt = time.Now()
+	return tx.QueryRow(query, img.UserID, img.ContentType, img.Width, img.Height, img.Size, img.StorageKey, img.CreatedAt).Scan(&img.ID)
+}
+
+// GetByID возвращает картинку, только если она принадлежит пользователю
+func (r *PostgresImageRepository) GetByID(id, userID int64) (*model.Image, error) {
+	query := `SELECT id, user_id, content_type, width, height, size, storage_key, created_at FROM images WHERE id = $1 AND user_id = $2;`
+	img := new(model.Image)
+	err := r.db.QueryRow(query, id, userID).
+		Scan(&img.ID, &img.UserID, &img.ContentType, &img.Width, &img.Height, &img.Size, &img.StorageKey, &img.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("картинка не найдена")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// GetStorageKeysByUserID возвращает ключи оригиналов всех картинок пользователя
+func (r *PostgresImageRepository) GetStorageKeysByUserID(userID int64) ([]string, error) {
+	return queryStorageKeys(r.db, `SELECT storage_key FROM images WHERE user_id = $1;`, userID)
+}
+
+// DeleteUnreferenced удаляет картинки пользователя из ids, на которые не ссылается ни одна его заметка
+// или шаблон (ссылка - urlPrefix и ID картинки), и возвращает ключи удалённых оригиналов
+func (r *PostgresImageRepository) DeleteUnreferenced(userID int64, ids []int64, urlPrefix string) ([]string, error) {
+	query := `DELETE FROM images i
+		WHERE i.user_id = $1 AND i.id = ANY($2)
+			AND NOT EXISTS (SELECT 1 FROM notes n WHERE n.user_id = $1 AND n.content ~ ($3 || i.id || '([^0-9]|$)'))
+			AND NOT EXISTS (SELECT 1 FROM note_templates t WHERE t.user_id = $1 AND t.content ~ ($3 || i.id || '([^0-9]|$)'))
+		RETURNING storage_key;`
+	return queryStorageKeys(r.db, query, userID, pq.Array(ids), urlPrefix)
+}
@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"database/sql"
+	"notes-api/internal/model"
+	"notes-api/internal/richtext"
+)
+
+type NoteLinkRepository interface {
+	Replace(sourceID, userID int64, links []richtext.WikiLink) error
+	GetOutgoing(noteID int64) ([]*model.NoteLink, error)
+	GetBacklinks(noteID int64) ([]*model.NoteLink, error)
+	ResolveDangling(userID, noteID int64, title string) error
+}
+
+type PostgresNoteLinkRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresNoteLinkRepository(db *sql.DB) NoteLinkRepository {
+	return &PostgresNoteLinkRepository{db: db}
+}
+
+// Replace заменяет ссылки заметки sourceID. Ссылка по заголовку ведёт на самую старую
+// заметку пользователя с таким заголовком (без учёта регистра), ссылка по ID - на заметку
+// с этим ID, если она принадлежит пользователю. Иначе ссылка сохраняется висячей.
+func (r *PostgresNoteLinkRepository) Replace(sourceID, userID int64, links []richtext.WikiLink) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM note_links WHERE source_note_id = $1;`, sourceID); err != nil {
+		return err
+	}
+
+	byTitle, err := tx.Prepare(`INSERT INTO note_links (source_note_id, target_note_id, ref)
+		VALUES ($1, (SELECT id FROM notes WHERE user_id = $2 AND lower(title) = lower($3) ORDER BY id LIMIT 1), $3)
+		ON CONFLICT (source_note_id, ref) DO NOTHING;`)
+	if err != nil {
+		return err
+	}
+	defer byTitle.Close()
+	byID, err := tx.Prepare(`INSERT INTO note_links (source_note_id, target_note_id, ref)
+		VALUES ($1, (SELECT id FROM notes WHERE id = $3 AND user_id = $2), $4)
+		ON CONFLICT (source_note_id, ref) DO NOTHING;`)
+	if err != nil {
+		return err
+	}
+	defer byID.Close()
+
+	for _, link := range links {
+		if link.ID != 0 {
+			_, err = byID.Exec(sourceID, userID, link.ID, link.Ref())
+		} else {
+			_, err = byTitle.Exec(sourceID, userID, link.Title)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetOutgoing возвращает ссылки из заметки вместе с заголовками заметок, на которые они ведут
+func (r *PostgresNoteLinkRepository) GetOutgoing(noteID int64) ([]*model.NoteLink, error) {
+	query := `SELECT l.ref, l.target_note_id, COALESCE(n.title, '')
+		FROM note_links l LEFT JOIN notes n ON n.id = l.target_note_id
+		WHERE l.source_note_id = $1 ORDER BY l.id;`
+	return r.query(query, noteID)
+}
+
+// GetBacklinks возвращает ссылки на заметку вместе с заголовками заметок, из которых они сделаны
+func (r *PostgresNoteLinkRepository) GetBacklinks(noteID int64) ([]*model.NoteLink, error) {
+	query := `SELECT l.ref, l.source_note_id, n.title
+		FROM note_links l JOIN notes n ON n.id = l.source_note_id
+		WHERE l.target_note_id = $1 ORDER BY n.id;`
+	return r.query(query, noteID)
+}
+
+func (r *PostgresNoteLinkRepository) query(query string, noteID int64) ([]*model.NoteLink, error) {
+	rows, err := r.db.Query(query, noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := make([]*model.NoteLink, 0)
+	for rows.Next() {
+		link := new(model.NoteLink)
+		if err := rows.Scan(&link.Ref, &link.NoteID, &link.Title); err != nil {
+			return nil, err
+		}
+		link.Dangling = link.NoteID == nil
+		if link.Dangling {
+			link.Title = ""
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+// ResolveDangling направляет висячие ссылки пользователя с заголовком title на заметку noteID.
+// Вызывается, когда заметка с таким заголовком появляется или её переименовывают
+func (r *PostgresNoteLinkRepository) ResolveDangling(userID, noteID int64, title string) error {
+	query := `UPDATE note_links l SET target_note_id = $2
+		FROM notes s
+		WHERE s.id = l.source_note_id AND s.user_id = $1
+			AND l.target_note_id IS NULL AND l.ref NOT LIKE 'id:%' AND lower(l.ref) = lower($3);`
+	_, err := r.db.Exec(query, userID, noteID, title)
+	return err
+}
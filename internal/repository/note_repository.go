@@ -5,9 +5,25 @@ import (
 	"errors"
 	"fmt"
 	"notes-api/internal/model"
+	"notes-api/internal/richtext"
 	"time"
 )
 
+const noteColumns = `id, title, content, user_id, style, rich_content, recurrence, next_occurrence_at, pinned, archived, color, created_at, updated_at`
+
+func scanNote(s rowScanner) (*model.Note, error) {
+	note := new(model.Note)
+	err := s.Scan(&note.ID, &note.Title, &note.Content, &note.UserID, &note.Style, &note.RichContent, &note.Recurrence, &note.NextOccurrenceAt, &note.Pinned, &note.Archived, &note.Color, &note.CreatedAt, &note.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	// У заметок, созданных до появления форматированного текста, он строится из content и style
+	if note.RichContent == nil {
+		note.RichContent = richtext.FromLegacy(note.Content, string(note.Style))
+	}
+	return note, nil
+}
+
 type PostgresNoteRepository struct {
 	db *sql.DB
 }
@@ -17,12 +33,20 @@ func NewPostgresNoteRepository(db *sql.DB) *PostgresNoteRepository {
 }
 
 func (r *PostgresNoteRepository) Create(note *model.Note) error {
-	query := `INSERT INTO notes (title, content, user_id, style, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;`
+	return createNote(r.db, note)
+}
+
+func (r *PostgresNoteRepository) CreateTx(tx *sql.Tx, note *model.Note) error {
+	return createNote(tx, note)
+}
+
+func createNote(db queryer, note *model.Note) error {
+	query := `INSERT INTO notes (title, content, user_id, style, rich_content, recurrence, next_occurrence_at, pinned, archived, color, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id;`
 	now := time.Now()
 	if note.Style == "" {
 		note.Style = model.StyleNormal
 	}
-	err := r.db.QueryRow(query, note.Title, note.Content, note.UserID, note.Style, now, now).Scan(&note.ID)
+	err := db.QueryRow(query, note.Title, note.Content, note.UserID, note.Style, note.RichContent, note.Recurrence, note.NextOccurrenceAt, note.Pinned, note.Archived, note.Color, now, now).Scan(&note.ID)
 	if err != nil {
 		return err
 	}
@@ -32,9 +56,8 @@ func (r *PostgresNoteRepository) Create(note *model.Note) error {
 }
 
 func (r *PostgresNoteRepository) GetByID(id int64, userID int64) (*model.Note, error) {
-	queryNote := `SELECT id, title, content, user_id, style, created_at, updated_at FROM notes WHERE id = $1 AND user_id = $2;`
-	note := new(model.Note)
-	err := r.db.QueryRow(queryNote, id, userID).Scan(&note.ID, &note.Title, &note.Content, &note.UserID, &note.Style, &note.CreatedAt, &note.UpdatedAt)
+	queryNote := `SELECT ` + noteColumns + ` FROM notes WHERE id = $1 AND user_id = $2;`
+	note, err := scanNote(r.db.QueryRow(queryNote, id, userID))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errors.New("заметка не найдена")
@@ -42,34 +65,60 @@ func (r *PostgresNoteRepository) GetByID(id int64, userID int64) (*model.Note, e
 		return nil, err
 	}
 
-	queryItems := `SELECT id, text, completed, note_id, style, created_at, updated_at FROM checklist_items WHERE note_id = $1 ORDER BY created_at ASC;`
-	rows, err := r.db.Query(queryItems, id)
+	items, err := NewPostgresChecklistItemRepository(r.db).GetByNoteID(note.ID)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	note.ChecklistItems = items
 
-	for rows.Next() {
-		item := new(model.ChecklistItem)
-		if err := rows.Scan(&item.ID, &item.Text, &item.Completed, &item.NoteID, &item.Style, &item.CreatedAt, &item.UpdatedAt); err != nil {
-			return nil, err
-		}
-		note.ChecklistItems = append(note.ChecklistItems, item)
-	}
-
-	tableRepo := NewPostgresNoteTableRepository(r.db)
-	tables, err := tableRepo.GetTablesByNoteID(note.ID)
+	tables, err := NewPostgresNoteTableRepository(r.db).GetTablesByNoteID(note.ID)
 	if err != nil {
-		fmt.Println(err)
+		return nil, err
 	}
 	note.Tables = tables
 
 	return note, nil
 }
 
-func (r *PostgresNoteRepository) GetAll(userID int64) ([]*model.Note, error) {
-	query := `SELECT id, title, content, user_id, style, created_at, updated_at FROM notes WHERE user_id = $1;`
-	rows, err := r.db.Query(query, userID)
+// Exists проверяет, что заметка принадлежит пользователю, не загружая её содержимое
+func (r *PostgresNoteRepository) Exists(id int64, userID int64) (bool, error) {
+	query := `SELECT EXISTS(SELECT 1 FROM notes WHERE id = $1 AND user_id = $2);`
+	var exists bool
+	err := r.db.QueryRow(query, id, userID).Scan(&exists)
+	return exists, err
+}
+
+// GetOwnerID возвращает владельца заметки; found = false, если заметки нет
+func (r *PostgresNoteRepository) GetOwnerID(id int64) (int64, bool, error) {
+	var ownerID int64
+	err := r.db.QueryRow(`SELECT user_id FROM notes WHERE id = $1;`, id).Scan(&ownerID)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return ownerID, true, nil
+}
+
+// noteOrderBy - ORDER BY для порядка сортировки списка заметок; закреплённые всегда первые
+func noteOrderBy(sort model.NoteSort) string {
+	switch sort {
+	case model.NoteSortCreated:
+		return `pinned DESC, created_at DESC, id DESC`
+	case model.NoteSortTitle:
+		return `pinned DESC, lower(title), id`
+	default:
+		return `pinned DESC, updated_at DESC, id DESC`
+	}
+}
+
+// GetAll возвращает заметки пользователя: сначала закреплённые, затем в порядке sort
+// (по дате изменения, дате создания или названию). Архивные заметки возвращаются, только если includeArchived = true
+func (r *PostgresNoteRepository) GetAll(userID int64, includeArchived bool, sort model.NoteSort) ([]*model.Note, error) {
+	query := `SELECT ` + noteColumns + ` FROM notes WHERE user_id = $1 AND ($2 OR NOT archived)
+		ORDER BY ` + noteOrderBy(sort) + `;`
+	rows, err := r.db.Query(query, userID, includeArchived)
 	if err != nil {
 		return nil, err
 	}
@@ -82,8 +131,8 @@ func (r *PostgresNoteRepository) GetAll(userID int64) ([]*model.Note, error) {
 
 	notes := make([]*model.Note, 0)
 	for rows.Next() {
-		note := new(model.Note)
-		if err = rows.Scan(&note.ID, &note.Title, &note.Content, &note.UserID, &note.Style, &note.CreatedAt, &note.UpdatedAt); err != nil {
+		note, err := scanNote(rows)
+		if err != nil {
 			return nil, err
 		}
 		notes = append(notes, note)
@@ -91,13 +140,36 @@ func (r *PostgresNoteRepository) GetAll(userID int64) ([]*model.Note, error) {
 	return notes, nil
 }
 
+// GetContent возвращает текст заметки, только если она принадлежит пользователю
+func (r *PostgresNoteRepository) GetContent(id int64, userID int64) (string, error) {
+	query := `SELECT COALESCE(content, '') FROM notes WHERE id = $1 AND user_id = $2;`
+	var content string
+	err := r.db.QueryRow(query, id, userID).Scan(&content)
+	if err == sql.ErrNoRows {
+		return "", errors.New("заметка не найдена или у вас нет прав на её изменение")
+	}
+	return content, err
+}
+
+// GetRecurrence возвращает правило повторения заметки и дату её следующей копии
+func (r *PostgresNoteRepository) GetRecurrence(id int64, userID int64) (string, *time.Time, error) {
+	query := `SELECT recurrence, next_occurrence_at FROM notes WHERE id = $1 AND user_id = $2;`
+	var recurrence string
+	var next *time.Time
+	err := r.db.QueryRow(query, id, userID).Scan(&recurrence, &next)
+	if err == sql.ErrNoRows {
+		return "", nil, errors.New("заметка не найдена или у вас нет прав на её изменение")
+	}
+	return recurrence, next, err
+}
+
 func (r *PostgresNoteRepository) Update(note *model.Note, userID int64) error {
-	query := `UPDATE notes SET title = $1, content = $2, style = $3, updated_at = $4 WHERE id = $5 AND user_id = $6;`
+	query := `UPDATE notes SET title = $1, content = $2, style = $3, rich_content = $4, recurrence = $5, next_occurrence_at = $6, updated_at = $7 WHERE id = $8 AND user_id = $9;`
 	now := time.Now()
 	if note.Style == "" {
 		note.Style = model.StyleNormal
 	}
-	res, err := r.db.Exec(query, note.Title, note.Content, note.Style, now, note.ID, userID)
+	res, err := r.db.Exec(query, note.Title, note.Content, note.Style, note.RichContent, note.Recurrence, note.NextOccurrenceAt, now, note.ID, userID)
 	if err != nil {
 		return err
 	}
@@ -128,3 +200,152 @@ func (r *PostgresNoteRepository) Delete(id int64, userID int64) error {
 	}
 	return nil
 }
+
+func (r *PostgresNoteRepository) SetPinned(id int64, userID int64, pinned bool) error {
+	return r.setState(`UPDATE notes SET pinned = $3 WHERE id = $1 AND user_id = $2;`, id, userID, pinned)
+}
+
+// SetArchived переносит заметку в архив или возвращает из него. Архивная заметка открепляется
+func (r *PostgresNoteRepository) SetArchived(id int64, userID int64, archived bool) error {
+	return r.setState(`UPDATE notes SET archived = $3, pinned = pinned AND NOT $3 WHERE id = $1 AND user_id = $2;`, id, userID, archived)
+}
+
+func (r *PostgresNoteRepository) SetColor(id int64, userID int64, color model.NoteColor) error {
+	return r.setState(`UPDATE notes SET color = $3 WHERE id = $1 AND user_id = $2;`, id, userID, color)
+}
+
+// setState меняет организационное состояние заметки, не трогая updated_at:
+// закрепление и архивирование не считаются изменением содержимого
+func (r *PostgresNoteRepository) setState(query string, id int64, userID int64, value interface{}) error {
+	res, err := r.db.Exec(query, id, userID, value)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("заметка не найдена или у вас нет прав на её изменение")
+	}
+	return nil
+}
+
+func (r *PostgresNoteRepository) BeginTx() (*sql.Tx, error) {
+	return r.db.Begin()
+}
+
+// CopyTx делает полную копию заметки пользователя: пункты чек-листа с вложенностью,
+// таблицы, колонки, строки, ячейки и вики-ссылки. Копия создаётся без правила повторения.
+// Если resetChecklist = true, пункты копируются невыполненными и без сроков и напоминаний.
+// Количество запросов не зависит от размера заметки: новые ID выделяются заранее
+// во временной таблице соответствий, а данные копируются INSERT ... SELECT.
+func (r *PostgresNoteRepository) CopyTx(tx *sql.Tx, id int64, userID int64, title string, resetChecklist bool) (int64, error) {
+	var newID int64
+	now := time.Now()
+	err := tx.QueryRow(`INSERT INTO notes (title, content, style, rich_content, color, user_id, created_at, updated_at)
+		SELECT $3, content, style, rich_content, color, user_id, $4, $4 FROM notes WHERE id = $1 AND user_id = $2
+		RETURNING id;`, id, userID, title, now).Scan(&newID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, errors.New("заметка не найдена")
+		}
+		return 0, err
+	}
+
+	steps := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`CREATE TEMP TABLE note_copy_map (kind TEXT NOT NULL, old_id BIGINT NOT NULL, new_id BIGINT NOT NULL) ON COMMIT DROP;`, nil},
+		{`INSERT INTO note_copy_map (kind, old_id, new_id)
+			SELECT 'item', id, nextval(pg_get_serial_sequence('checklist_items', 'id')) FROM checklist_items WHERE note_id = $1
+			UNION ALL
+			SELECT 'table', id, nextval(pg_get_serial_sequence('note_tables', 'id')) FROM note_tables WHERE note_id = $1
+			UNION ALL
+			SELECT 'column', c.id, nextval(pg_get_serial_sequence('table_columns', 'id'))
+				FROM table_columns c JOIN note_tables t ON t.id = c.table_id WHERE t.note_id = $1
+			UNION ALL
+			SELECT 'row', rw.id, nextval(pg_get_serial_sequence('table_rows', 'id'))
+				FROM table_rows rw JOIN note_tables t ON t.id = rw.table_id WHERE t.note_id = $1;`,
+			[]interface{}{id}},
+		{`INSERT INTO checklist_items (id, text, completed, style, rich_text, note_id, parent_id, "position", due_at, remind_at, reminded_at, assignee_id, recurrence, created_at, updated_at)
+			SELECT m.new_id, c.text,
+				CASE WHEN $2 THEN FALSE ELSE c.completed END,
+				c.style, c.rich_text, $1, pm.new_id, c."position",
+				CASE WHEN $2 THEN NULL ELSE c.due_at END,
+				CASE WHEN $2 THEN NULL ELSE c.remind_at END,
+				CASE WHEN $2 THEN NULL ELSE c.reminded_at END,
+				c.assignee_id, c.recurrence, $3, $3
+			FROM checklist_items c
+			JOIN note_copy_map m ON m.kind = 'item' AND m.old_id = c.id
+			LEFT JOIN note_copy_map pm ON pm.kind = 'item' AND pm.old_id = c.parent_id;`,
+			[]interface{}{newID, resetChecklist, now}},
+		{`INSERT INTO note_tables (id, note_id, title, created_at)
+			SELECT m.new_id, $1, t.title, $2
+			FROM note_tables t JOIN note_copy_map m ON m.kind = 'table' AND m.old_id = t.id;`,
+			[]interface{}{newID, now}},
+		{`INSERT INTO table_columns (id, table_id, name, "position")
+			SELECT m.new_id, tm.new_id, c.name, c."position"
+			FROM table_columns c
+			JOIN note_copy_map m ON m.kind = 'column' AND m.old_id = c.id
+			JOIN note_copy_map tm ON tm.kind = 'table' AND tm.old_id = c.table_id;`, nil},
+		{`INSERT INTO table_rows (id, table_id, "position")
+			SELECT m.new_id, tm.new_id, rw."position"
+			FROM table_rows rw
+			JOIN note_copy_map m ON m.kind = 'row' AND m.old_id = rw.id
+			JOIN note_copy_map tm ON tm.kind = 'table' AND tm.old_id = rw.table_id;`, nil},
+		{`INSERT INTO table_cells (row_id, column_id, content)
+			SELECT rm.new_id, cm.new_id, c.content
+			FROM table_cells c
+			JOIN note_copy_map rm ON rm.kind = 'row' AND rm.old_id = c.row_id
+			JOIN note_copy_map cm ON cm.kind = 'column' AND cm.old_id = c.column_id;`, nil},
+		{`INSERT INTO note_links (source_note_id, target_note_id, ref)
+			SELECT $1, target_note_id, ref FROM note_links WHERE source_note_id = $2;`,
+			[]interface{}{newID, id}},
+		// Таблица удаляется сразу, чтобы в одной транзакции можно было скопировать несколько заметок
+		{`DROP TABLE note_copy_map;`, nil},
+	}
+
+	for _, step := range steps {
+		if _, err := tx.Exec(step.query, step.args...); err != nil {
+			return 0, err
+		}
+	}
+	return newID, nil
+}
+
+// GetDueRecurring возвращает повторяющиеся заметки, очередное повторение которых уже наступило.
+// Архивные заметки не повторяются
+func (r *PostgresNoteRepository) GetDueRecurring(now time.Time, limit int) ([]*model.Note, error) {
+	query := `SELECT ` + noteColumns + ` FROM notes
+		WHERE recurrence <> '' AND NOT archived AND next_occurrence_at IS NOT NULL AND next_occurrence_at <= $1
+		ORDER BY next_occurrence_at ASC
+		LIMIT $2;`
+	rows, err := r.db.Query(query, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notes []*model.Note
+	for rows.Next() {
+		note, err := scanNote(rows)
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, note)
+	}
+	return notes, rows.Err()
+}
+
+// SetNextOccurrenceTx сдвигает дату следующего повторения, только если её не изменили
+// параллельно (например, другой экземпляр планировщика)
+func (r *PostgresNoteRepository) SetNextOccurrenceTx(tx *sql.Tx, id int64, current time.Time, next time.Time) (bool, error) {
+	res, err := tx.Exec(`UPDATE notes SET next_occurrence_at = $1 WHERE id = $2 AND next_occurrence_at = $3;`, next, id, current)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
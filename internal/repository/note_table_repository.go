@@ -2,15 +2,25 @@ package repository
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"notes-api/internal/model"
+	"sort"
+
+	"github.com/lib/pq"
 )
 
 type NoteTableRepository interface {
 	Create(tx *sql.Tx, table *model.NoteTable) error
 	CreateColumns(tx *sql.Tx, tableID int64, columns []string) error
 	AddRow(tableID int64, cells []string) (*model.TableRow, error)
+	AddRows(tableID int64, cells [][]string) ([]*model.TableRow, error)
+	AddRowsTx(tx *sql.Tx, tableID int64, cells [][]string) ([]*model.TableRow, error)
+	UpdateCells(tableID int64, updates []model.TableCellUpdate) ([]*model.TableRow, error)
+	GetTableByID(tableID int64) (*model.NoteTable, error)
+	GetNoteIDByTableID(tableID int64) (int64, error)
 	GetTablesByNoteID(noteID int64) ([]*model.NoteTable, error)
+	MoveToNote(tableID, noteID, targetNoteID int64) error
 	BeginTx() (*sql.Tx, error)
 }
 
@@ -47,17 +57,224 @@ func (r *PostgresNoteTableRepository) CreateColumns(tx *sql.Tx, tableID int64, c
 }
 
 func (r *PostgresNoteTableRepository) AddRow(tableID int64, cells []string) (*model.TableRow, error) {
+	rows, err := r.AddRows(tableID, [][]string{cells})
+	if err != nil {
+		return nil, err
+	}
+	return rows[0], nil
+}
+
+func (r *PostgresNoteTableRepository) AddRows(tableID int64, cells [][]string) ([]*model.TableRow, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := r.AddRowsTx(tx, tableID, cells)
+	if err != nil {
+		return nil, err
+	}
+
+	return rows, tx.Commit()
+}
+
+// AddRowsTx добавляет строки в рамках уже открытой транзакции.
+// Все строки проверяются на соответствие количеству колонок до вставки,
+// после чего строки и ячейки вставляются двумя многострочными INSERT.
+func (r *PostgresNoteTableRepository) AddRowsTx(tx *sql.Tx, tableID int64, cells [][]string) ([]*model.TableRow, error) {
+	if len(cells) == 0 {
+		return []*model.TableRow{}, nil
+	}
+
+	// Блокируем таблицу, чтобы параллельные вставки не получили одинаковые позиции
+	var locked int64
+	err := tx.QueryRow(`SELECT id FROM note_tables WHERE id = $1 FOR UPDATE;`, tableID).Scan(&locked)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("таблица не найдена")
+		}
+		return nil, err
+	}
+
+	columnIDs, err := r.getColumnIDs(tx, tableID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, rowCells := range cells {
+		if len(rowCells) != len(columnIDs) {
+			return nil, fmt.Errorf("строка %d: количество ячеек (%d) не соответствует количеству колонок (%d)", i+1, len(rowCells), len(columnIDs))
+		}
+	}
+
+	var start int
+	err = tx.QueryRow(`SELECT COALESCE(MAX("position"), -1) + 1 FROM table_rows WHERE table_id = $1;`, tableID).Scan(&start)
+	if err != nil {
+		return nil, err
+	}
+
+	rowQuery := `INSERT INTO table_rows (table_id, "position")
+		SELECT $1, p FROM generate_series($2::int, $3::int) AS p
+		RETURNING id, "position";`
+	rowsData, err := tx.Query(rowQuery, tableID, start, start+len(cells)-1)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*model.TableRow, len(cells))
+	for rowsData.Next() {
+		row := &model.TableRow{TableID: tableID}
+		if err := rowsData.Scan(&row.ID, &row.Position); err != nil {
+			rowsData.Close()
+			return nil, err
+		}
+		result[row.Position-start] = row
+	}
+	rowsData.Close()
+	if err := rowsData.Err(); err != nil {
+		return nil, err
+	}
+
+	rowIDs := make([]int64, 0, len(cells)*len(columnIDs))
+	colIDs := make([]int64, 0, len(cells)*len(columnIDs))
+	contents := make([]string, 0, len(cells)*len(columnIDs))
+	for i, rowCells := range cells {
+		for j, content := range rowCells {
+			rowIDs = append(rowIDs, result[i].ID)
+			colIDs = append(colIDs, columnIDs[j])
+			contents = append(contents, content)
+		}
+	}
+
+	cellQuery := `INSERT INTO table_cells (row_id, column_id, content)
+		SELECT * FROM unnest($1::bigint[], $2::bigint[], $3::text[])
+		RETURNING id, row_id, column_id, content;`
+	cellsData, err := tx.Query(cellQuery, pq.Array(rowIDs), pq.Array(colIDs), pq.Array(contents))
+	if err != nil {
+		return nil, err
+	}
+	defer cellsData.Close()
+
+	rowMap := make(map[int64]*model.TableRow, len(result))
+	for _, row := range result {
+		rowMap[row.ID] = row
+	}
+	for cellsData.Next() {
+		cell := new(model.TableCell)
+		if err := cellsData.Scan(&cell.ID, &cell.RowID, &cell.ColumnID, &cell.Content); err != nil {
+			return nil, err
+		}
+		rowMap[cell.RowID].Cells = append(rowMap[cell.RowID].Cells, cell)
+	}
+	if err := cellsData.Err(); err != nil {
+		return nil, err
+	}
+
+	sortCellsByColumns(result, columnIDs)
+	return result, nil
+}
+
+// UpdateCells обновляет содержимое ячеек одной таблицы в одной транзакции.
+// Возвращает затронутые строки, содержащие только изменённые ячейки.
+func (r *PostgresNoteTableRepository) UpdateCells(tableID int64, updates []model.TableCellUpdate) ([]*model.TableRow, error) {
 	tx, err := r.db.Begin()
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback()
 
-	colsQuery := `SELECT id FROM table_columns WHERE table_id = $1 ORDER BY "position" ASC;`
-	rows, err := tx.Query(colsQuery, tableID)
+	columnIDs, err := r.getColumnIDs(tx, tableID)
+	if err != nil {
+		return nil, err
+	}
+	knownColumns := make(map[int64]bool, len(columnIDs))
+	for _, id := range columnIDs {
+		knownColumns[id] = true
+	}
+
+	type cellKey struct{ rowID, columnID int64 }
+	seen := make(map[cellKey]bool, len(updates))
+	rowIDs := make([]int64, len(updates))
+	colIDs := make([]int64, len(updates))
+	contents := make([]string, len(updates))
+	for i, u := range updates {
+		if !knownColumns[u.ColumnID] {
+			return nil, fmt.Errorf("колонка %d не принадлежит таблице", u.ColumnID)
+		}
+		key := cellKey{u.RowID, u.ColumnID}
+		if seen[key] {
+			return nil, fmt.Errorf("ячейка (строка %d, колонка %d) указана несколько раз", u.RowID, u.ColumnID)
+		}
+		seen[key] = true
+		rowIDs[i], colIDs[i], contents[i] = u.RowID, u.ColumnID, u.Content
+	}
+
+	rowsQuery := `SELECT id, "position" FROM table_rows WHERE table_id = $1 AND id = ANY($2);`
+	rowsData, err := tx.Query(rowsQuery, tableID, pq.Array(rowIDs))
+	if err != nil {
+		return nil, err
+	}
+	rowMap := make(map[int64]*model.TableRow)
+	for rowsData.Next() {
+		row := &model.TableRow{TableID: tableID}
+		if err := rowsData.Scan(&row.ID, &row.Position); err != nil {
+			rowsData.Close()
+			return nil, err
+		}
+		rowMap[row.ID] = row
+	}
+	rowsData.Close()
+	if err := rowsData.Err(); err != nil {
+		return nil, err
+	}
+	for _, id := range rowIDs {
+		if _, ok := rowMap[id]; !ok {
+			return nil, fmt.Errorf("строка %d не принадлежит таблице", id)
+		}
+	}
+
+	cellQuery := `INSERT INTO table_cells (row_id, column_id, content)
+		SELECT * FROM unnest($1::bigint[], $2::bigint[], $3::text[])
+		ON CONFLICT (row_id, column_id) DO UPDATE SET content = EXCLUDED.content
+		RETURNING id, row_id, column_id, content;`
+	cellsData, err := tx.Query(cellQuery, pq.Array(rowIDs), pq.Array(colIDs), pq.Array(contents))
+	if err != nil {
+		return nil, err
+	}
+	for cellsData.Next() {
+		cell := new(model.TableCell)
+		if err := cellsData.Scan(&cell.ID, &cell.RowID, &cell.ColumnID, &cell.Content); err != nil {
+			cellsData.Close()
+			return nil, err
+		}
+		rowMap[cell.RowID].Cells = append(rowMap[cell.RowID].Cells, cell)
+	}
+	cellsData.Close()
+	if err := cellsData.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	result := make([]*model.TableRow, 0, len(rowMap))
+	for _, row := range rowMap {
+		result = append(result, row)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Position < result[j].Position })
+	sortCellsByColumns(result, columnIDs)
+	return result, nil
+}
+
+// getColumnIDs возвращает ID колонок таблицы в порядке их позиций
+func (r *PostgresNoteTableRepository) getColumnIDs(tx *sql.Tx, tableID int64) ([]int64, error) {
+	rows, err := tx.Query(`SELECT id FROM table_columns WHERE table_id = $1 ORDER BY "position" ASC;`, tableID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
 	var columnIDs []int64
 	for rows.Next() {
 		var colID int64
@@ -66,40 +283,73 @@ func (r *PostgresNoteTableRepository) AddRow(tableID int64, cells []string) (*mo
 		}
 		columnIDs = append(columnIDs, colID)
 	}
-	rows.Close()
+	return columnIDs, rows.Err()
+}
 
-	if len(cells) != len(columnIDs) {
-		return nil, fmt.Errorf("количество ячеек (%d) не соответствует количеству колонок (%d)", len(cells), len(columnIDs))
+// sortCellsByColumns упорядочивает ячейки каждой строки по позициям колонок
+func sortCellsByColumns(rows []*model.TableRow, columnIDs []int64) {
+	order := make(map[int64]int, len(columnIDs))
+	for i, id := range columnIDs {
+		order[id] = i
+	}
+	for _, row := range rows {
+		sort.Slice(row.Cells, func(i, j int) bool {
+			return order[row.Cells[i].ColumnID] < order[row.Cells[j].ColumnID]
+		})
 	}
+}
 
-	row := &model.TableRow{TableID: tableID}
-	rowQuery := `INSERT INTO table_rows (table_id, "position") VALUES ($1, (SELECT COALESCE(MAX("position"), -1) + 1 FROM table_rows WHERE table_id = $1)) RETURNING id, "position";`
-	err = tx.QueryRow(rowQuery, tableID).Scan(&row.ID, &row.Position)
+func (r *PostgresNoteTableRepository) GetTableByID(tableID int64) (*model.NoteTable, error) {
+	query := `SELECT id, note_id, title, created_at FROM note_tables WHERE id = $1;`
+	table := new(model.NoteTable)
+	err := r.db.QueryRow(query, tableID).Scan(&table.ID, &table.NoteID, &table.Title, &table.CreatedAt)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("таблица не найдена")
+		}
 		return nil, err
 	}
 
-	cellStmt, err := tx.Prepare(`INSERT INTO table_cells (row_id, column_id, content) VALUES ($1, $2, $3) RETURNING id, column_id, content;`)
-	if err != nil {
+	if err := r.loadTablesData([]*model.NoteTable{table}); err != nil {
 		return nil, err
 	}
-	defer cellStmt.Close()
+	return table, nil
+}
 
-	for i, content := range cells {
-		cell := &model.TableCell{}
-		colID := columnIDs[i]
-		err := cellStmt.QueryRow(row.ID, colID, content).Scan(&cell.ID, &cell.ColumnID, &cell.Content)
-		if err != nil {
-			return nil, err
+// GetNoteIDByTableID возвращает ID заметки, к которой относится таблица
+func (r *PostgresNoteTableRepository) GetNoteIDByTableID(tableID int64) (int64, error) {
+	var noteID int64
+	err := r.db.QueryRow(`SELECT note_id FROM note_tables WHERE id = $1;`, tableID).Scan(&noteID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, errors.New("таблица не найдена")
 		}
-		row.Cells = append(row.Cells, cell)
+		return 0, err
 	}
+	return noteID, nil
+}
 
-	return row, tx.Commit()
+// MoveToNote переносит таблицу со всеми колонками, строками и ячейками в другую заметку
+func (r *PostgresNoteTableRepository) MoveToNote(tableID, noteID, targetNoteID int64) error {
+	res, err := r.db.Exec(`UPDATE note_tables SET note_id = $3 WHERE id = $1 AND note_id = $2;`, tableID, noteID, targetNoteID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("таблица не найдена")
+	}
+	return nil
 }
 
+// GetTablesByNoteID загружает все таблицы заметки вместе с колонками, строками и ячейками.
+// Количество запросов не зависит от числа таблиц и строк: один запрос на таблицы
+// и по одному на колонки, строки и ячейки всех таблиц сразу.
 func (r *PostgresNoteTableRepository) GetTablesByNoteID(noteID int64) ([]*model.NoteTable, error) {
-	tablesQuery := `SELECT id, title, created_at FROM note_tables WHERE note_id = $1;`
+	tablesQuery := `SELECT id, title, created_at FROM note_tables WHERE note_id = $1 ORDER BY id ASC;`
 	rows, err := r.db.Query(tablesQuery, noteID)
 	if err != nil {
 		return nil, err
@@ -107,64 +357,113 @@ func (r *PostgresNoteTableRepository) GetTablesByNoteID(noteID int64) ([]*model.
 	defer rows.Close()
 
 	var tables []*model.NoteTable
-	tableMap := make(map[int64]*model.NoteTable)
-
 	for rows.Next() {
 		table := &model.NoteTable{NoteID: noteID}
 		if err := rows.Scan(&table.ID, &table.Title, &table.CreatedAt); err != nil {
 			return nil, err
 		}
 		tables = append(tables, table)
-		tableMap[table.ID] = table
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
 	if len(tables) == 0 {
 		return nil, nil
 	}
 
-	for _, table := range tables {
-		cols, err := r.db.Query(`SELECT id, name, "position" FROM table_columns WHERE table_id = $1 ORDER BY "position" ASC;`, table.ID)
-		if err != nil {
-			return nil, err
-		}
-		defer cols.Close()
+	if err := r.loadTablesData(tables); err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+// loadTablesData заполняет колонки, строки и ячейки сразу для всех переданных таблиц
+func (r *PostgresNoteTableRepository) loadTablesData(tables []*model.NoteTable) error {
+	tableIDs := make([]int64, len(tables))
+	tableMap := make(map[int64]*model.NoteTable, len(tables))
+	for i, table := range tables {
+		tableIDs[i] = table.ID
+		tableMap[table.ID] = table
+	}
 
-		for cols.Next() {
-			col := &model.TableColumn{TableID: table.ID}
-			if err := cols.Scan(&col.ID, &col.Name, &col.Position); err != nil {
-				return nil, err
-			}
-			table.Columns = append(table.Columns, col)
+	if err := r.loadColumns(tableIDs, tableMap); err != nil {
+		return err
+	}
+
+	rowMap, err := r.loadRows(tableIDs, tableMap)
+	if err != nil {
+		return err
+	}
+
+	return r.loadCells(tableIDs, rowMap)
+}
+
+func (r *PostgresNoteTableRepository) loadColumns(tableIDs []int64, tableMap map[int64]*model.NoteTable) error {
+	query := `SELECT id, table_id, name, "position" FROM table_columns WHERE table_id = ANY($1) ORDER BY table_id, "position" ASC;`
+	rows, err := r.db.Query(query, pq.Array(tableIDs))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		col := new(model.TableColumn)
+		if err := rows.Scan(&col.ID, &col.TableID, &col.Name, &col.Position); err != nil {
+			return err
 		}
+		table := tableMap[col.TableID]
+		table.Columns = append(table.Columns, col)
+	}
+	return rows.Err()
+}
+
+func (r *PostgresNoteTableRepository) loadRows(tableIDs []int64, tableMap map[int64]*model.NoteTable) (map[int64]*model.TableRow, error) {
+	query := `SELECT id, table_id, "position" FROM table_rows WHERE table_id = ANY($1) ORDER BY table_id, "position" ASC;`
+	rows, err := r.db.Query(query, pq.Array(tableIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-		rowsData, err := r.db.Query(`SELECT id, "position" FROM table_rows WHERE table_id = $1 ORDER BY "position" ASC;`, table.ID)
-		if err != nil {
+	rowMap := make(map[int64]*model.TableRow)
+	for rows.Next() {
+		row := new(model.TableRow)
+		if err := rows.Scan(&row.ID, &row.TableID, &row.Position); err != nil {
 			return nil, err
 		}
-		defer rowsData.Close()
+		table := tableMap[row.TableID]
+		table.Rows = append(table.Rows, row)
+		rowMap[row.ID] = row
+	}
+	return rowMap, rows.Err()
+}
 
-		for rowsData.Next() {
-			row := &model.TableRow{TableID: table.ID}
-			if err := rowsData.Scan(&row.ID, &row.Position); err != nil {
-				return nil, err
-			}
+func (r *PostgresNoteTableRepository) loadCells(tableIDs []int64, rowMap map[int64]*model.TableRow) error {
+	if len(rowMap) == 0 {
+		return nil
+	}
 
-			cellsData, err := r.db.Query(`SELECT id, column_id, content FROM table_cells WHERE row_id = $1;`, row.ID)
-			if err != nil {
-				return nil, err
-			}
-			defer cellsData.Close()
+	query := `SELECT c.id, c.row_id, c.column_id, c.content
+		FROM table_cells c
+		JOIN table_rows r ON r.id = c.row_id
+		JOIN table_columns col ON col.id = c.column_id
+		WHERE r.table_id = ANY($1)
+		ORDER BY c.row_id, col."position" ASC;`
+	rows, err := r.db.Query(query, pq.Array(tableIDs))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
 
-			for cellsData.Next() {
-				cell := &model.TableCell{RowID: row.ID}
-				if err := cellsData.Scan(&cell.ID, &cell.ColumnID, &cell.Content); err != nil {
-					return nil, err
-				}
-				row.Cells = append(row.Cells, cell)
-			}
-			table.Rows = append(table.Rows, row)
+	for rows.Next() {
+		cell := new(model.TableCell)
+		if err := rows.Scan(&cell.ID, &cell.RowID, &cell.ColumnID, &cell.Content); err != nil {
+			return err
+		}
+		if row, ok := rowMap[cell.RowID]; ok {
+			row.Cells = append(row.Cells, cell)
 		}
 	}
-
-	return tables, nil
+	return rows.Err()
 }
@@ -0,0 +1,200 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"notes-api/internal/model"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// Размер заметки для бенчмарков загрузки таблиц
+const (
+	benchTables  = 50
+	benchRows    = 1000
+	benchColumns = 3
+)
+
+// openTestDB подключается к базе из TEST_DATABASE_URL со схемой из init.sql.
+// Без переменной бенчмарк пропускается. Используйте отдельную базу: в неё записываются данные
+func openTestDB(tb testing.TB) *sql.DB {
+	tb.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		tb.Skip("TEST_DATABASE_URL не задан")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	return db
+}
+
+// seedNoteWithTables создаёт пользователя и заметку с tables таблицами по rows строк.
+// После бенчмарка пользователь удаляется вместе со всеми данными
+func seedNoteWithTables(tb testing.TB, db *sql.DB, tables, rows int) (userID, noteID int64) {
+	tb.Helper()
+	username := fmt.Sprintf("bench-%d", time.Now().UnixNano())
+	if err := db.QueryRow(`INSERT INTO users (username, password) VALUES ($1, '') RETURNING id;`, username).Scan(&userID); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		if _, err := db.Exec(`DELETE FROM users WHERE id = $1;`, userID); err != nil {
+			tb.Error(err)
+		}
+	})
+	if err := db.QueryRow(`INSERT INTO notes (title, content, user_id) VALUES ('Бенчмарк', '', $1) RETURNING id;`, userID).Scan(&noteID); err != nil {
+		tb.Fatal(err)
+	}
+
+	for t := 0; t < tables; t++ {
+		var tableID int64
+		if err := db.QueryRow(`INSERT INTO note_tables (note_id, title) VALUES ($1, $2) RETURNING id;`, noteID, fmt.Sprintf("Таблица %d", t)).Scan(&tableID); err != nil {
+			tb.Fatal(err)
+		}
+		if _, err := db.Exec(`INSERT INTO table_columns (table_id, name, "position")
+			SELECT $1, 'Колонка ' || p, p FROM generate_series(0, $2 - 1) AS p;`, tableID, benchColumns); err != nil {
+			tb.Fatal(err)
+		}
+		if _, err := db.Exec(`INSERT INTO table_rows (table_id, "position")
+			SELECT $1, p FROM generate_series(0, $2 - 1) AS p;`, tableID, rows); err != nil {
+			tb.Fatal(err)
+		}
+		if _, err := db.Exec(`INSERT INTO table_cells (row_id, column_id, content)
+			SELECT r.id, c.id, 'ячейка ' || r."position" || ':' || c."position"
+			FROM table_rows r JOIN table_columns c ON c.table_id = r.table_id
+			WHERE r.table_id = $1;`, tableID); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return userID, noteID
+}
+
+func BenchmarkGetTablesByNoteID(b *testing.B) {
+	db := openTestDB(b)
+	_, noteID := seedNoteWithTables(b, db, benchTables, benchRows)
+	repo := NewPostgresNoteTableRepository(db)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tables, err := repo.GetTablesByNoteID(noteID)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(tables) != benchTables || len(tables[0].Rows) != benchRows {
+			b.Fatalf("загружено %d таблиц, ожидалось %d по %d строк", len(tables), benchTables, benchRows)
+		}
+	}
+}
+
+// BenchmarkGetTablesByNoteIDNPlusOne - прежняя загрузка таблиц для сравнения с BenchmarkGetTablesByNoteID
+func BenchmarkGetTablesByNoteIDNPlusOne(b *testing.B) {
+	db := openTestDB(b)
+	_, noteID := seedNoteWithTables(b, db, benchTables, benchRows)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tables, err := getTablesByNoteIDNPlusOne(db, noteID)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(tables) != benchTables || len(tables[0].Rows) != benchRows {
+			b.Fatalf("загружено %d таблиц, ожидалось %d по %d строк", len(tables), benchTables, benchRows)
+		}
+	}
+}
+
+// getTablesByNoteIDNPlusOne повторяет GetTablesByNoteID до перехода на загрузку через ANY($1):
+// запрос на таблицы, затем по запросу на колонки и строки каждой таблицы и на ячейки каждой строки.
+// Только для бенчмарка. В отличие от старого кода курсор ячеек закрывается сразу, а не через defer
+// в цикле, иначе на 1000 строк не хватает соединений
+func getTablesByNoteIDNPlusOne(db *sql.DB, noteID int64) ([]*model.NoteTable, error) {
+	rows, err := db.Query(`SELECT id, title, created_at FROM note_tables WHERE note_id = $1 ORDER BY id ASC;`, noteID)
+	if err != nil {
+		return nil, err
+	}
+	var tables []*model.NoteTable
+	for rows.Next() {
+		table := &model.NoteTable{NoteID: noteID}
+		if err := rows.Scan(&table.ID, &table.Title, &table.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, table := range tables {
+		cols, err := db.Query(`SELECT id, name, "position" FROM table_columns WHERE table_id = $1 ORDER BY "position" ASC;`, table.ID)
+		if err != nil {
+			return nil, err
+		}
+		for cols.Next() {
+			col := &model.TableColumn{TableID: table.ID}
+			if err := cols.Scan(&col.ID, &col.Name, &col.Position); err != nil {
+				cols.Close()
+				return nil, err
+			}
+			table.Columns = append(table.Columns, col)
+		}
+		cols.Close()
+
+		tableRows, err := db.Query(`SELECT id, "position" FROM table_rows WHERE table_id = $1 ORDER BY "position" ASC;`, table.ID)
+		if err != nil {
+			return nil, err
+		}
+		for tableRows.Next() {
+			row := &model.TableRow{TableID: table.ID}
+			if err := tableRows.Scan(&row.ID, &row.Position); err != nil {
+				tableRows.Close()
+				return nil, err
+			}
+			table.Rows = append(table.Rows, row)
+		}
+		tableRows.Close()
+
+		for _, row := range table.Rows {
+			cells, err := db.Query(`SELECT id, column_id, content FROM table_cells WHERE row_id = $1;`, row.ID)
+			if err != nil {
+				return nil, err
+			}
+			for cells.Next() {
+				cell := &model.TableCell{RowID: row.ID}
+				if err := cells.Scan(&cell.ID, &cell.ColumnID, &cell.Content); err != nil {
+					cells.Close()
+					return nil, err
+				}
+				row.Cells = append(row.Cells, cell)
+			}
+			cells.Close()
+		}
+	}
+	return tables, nil
+}
+
+func BenchmarkNoteGetByID(b *testing.B) {
+	db := openTestDB(b)
+	userID, noteID := seedNoteWithTables(b, db, benchTables, benchRows)
+	repo := NewPostgresNoteRepository(db)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		note, err := repo.GetByID(noteID, userID)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(note.Tables) != benchTables {
+			b.Fatalf("загружено %d таблиц, ожидалось %d", len(note.Tables), benchTables)
+		}
+	}
+}
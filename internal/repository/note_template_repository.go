@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"notes-api/internal/model"
+	"time"
+)
+
+type NoteTemplateRepository interface {
+	Create(t *model.NoteTemplate) error
+	GetAll(userID int64) ([]*model.NoteTemplate, error)
+	GetByID(id, userID int64) (*model.NoteTemplate, error)
+	Delete(id, userID int64) error
+}
+
+const noteTemplateColumns = `id, user_id, name, title, content, style, checklist, tables, created_at`
+
+func scanNoteTemplate(s rowScanner) (*model.NoteTemplate, error) {
+	t := new(model.NoteTemplate)
+	var checklist, tables []byte
+	err := s.Scan(&t.ID, &t.UserID, &t.Name, &t.Title, &t.Content, &t.Style, &checklist, &tables, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(checklist, &t.Checklist); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(tables, &t.Tables); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+type PostgresNoteTemplateRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresNoteTemplateRepository(db *sql.DB) NoteTemplateRepository {
+	return &PostgresNoteTemplateRepository{db: db}
+}
+
+func (r *PostgresNoteTemplateRepository) Create(t *model.NoteTemplate) error {
+	if t.Checklist == nil {
+		t.Checklist = []*model.TemplateChecklistItem{}
+	}
+	if t.Tables == nil {
+		t.Tables = []*model.TemplateTable{}
+	}
+	checklist, err := json.Marshal(t.Checklist)
+	if err != nil {
+		return err
+	}
+	tables, err := json.Marshal(t.Tables)
+	if err != nil {
+		return err
+	}
+	if t.Style == "" {
+		t.Style = model.StyleNormal
+	}
+
+	query := `INSERT INTO note_templates (user_id, name, title, content, style, checklist, tables, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id;`
+	t.CreatedAt = time.Now()
+	return r.db.QueryRow(query, t.UserID, t.Name, t.Title, t.Content, t.Style, checklist, tables, t.CreatedAt).Scan(&t.ID)
+}
+
+func (r *PostgresNoteTemplateRepository) GetAll(userID int64) ([]*model.NoteTemplate, error) {
+	rows, err := r.db.Query(`SELECT `+noteTemplateColumns+` FROM note_templates WHERE user_id = $1 ORDER BY name, id;`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := make([]*model.NoteTemplate, 0)
+	for rows.Next() {
+		t, err := scanNoteTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, rows.Err()
+}
+
+func (r *PostgresNoteTemplateRepository) GetByID(id, userID int64) (*model.NoteTemplate, error) {
+	t, err := scanNoteTemplate(r.db.QueryRow(`SELECT `+noteTemplateColumns+` FROM note_templates WHERE id = $1 AND user_id = $2;`, id, userID))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("шаблон не найден")
+	}
+	return t, err
+}
+
+func (r *PostgresNoteTemplateRepository) Delete(id, userID int64) error {
+	res, err := r.db.Exec(`DELETE FROM note_templates WHERE id = $1 AND user_id = $2;`, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("шаблон не найден")
+	}
+	return nil
+}
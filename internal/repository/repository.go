@@ -1,19 +1,48 @@
 package repository
 
-import "notes-api/internal/model"
+import (
+	"database/sql"
+	"notes-api/internal/model"
+	"time"
+)
 
 type NoteRepository interface {
 	Create(note *model.Note) error
+	CreateTx(tx *sql.Tx, note *model.Note) error
 	GetByID(id int64, userID int64) (*model.Note, error)
-	GetAll(userID int64) ([]*model.Note, error)
+	Exists(id int64, userID int64) (bool, error)
+	GetOwnerID(id int64) (int64, bool, error)
+	GetContent(id int64, userID int64) (string, error)
+	GetAll(userID int64, includeArchived bool, sort model.NoteSort) ([]*model.Note, error)
 	Update(note *model.Note, userID int64) error
+	GetRecurrence(id int64, userID int64) (string, *time.Time, error)
+	SetPinned(id int64, userID int64, pinned bool) error
+	SetArchived(id int64, userID int64, archived bool) error
+	SetColor(id int64, userID int64, color model.NoteColor) error
 	Delete(id int64, userID int64) error
+	BeginTx() (*sql.Tx, error)
+	CopyTx(tx *sql.Tx, id int64, userID int64, title string, resetChecklist bool) (int64, error)
+	GetDueRecurring(now time.Time, limit int) ([]*model.Note, error)
+	SetNextOccurrenceTx(tx *sql.Tx, id int64, current time.Time, next time.Time) (bool, error)
 }
 
 type ChecklistItemRepository interface {
+	BeginTx() (*sql.Tx, error)
 	Create(item *model.ChecklistItem) error
+	CreateTx(tx *sql.Tx, item *model.ChecklistItem) error
 	GetByNoteID(noteID int64) ([]*model.ChecklistItem, error)
 	GetByID(itemID int64) (*model.ChecklistItem, error)
 	Update(item *model.ChecklistItem) error
+	UpdateTx(tx *sql.Tx, item *model.ChecklistItem) error
+	SetChildrenCompletedTx(tx *sql.Tx, itemID int64, completed bool) error
 	Delete(itemID int64) error
+	Move(itemID int64, parentID *int64, position int) error
+	Reorder(noteID int64, parentID *int64, itemIDs []int64) error
+	SetCompletedForNoteTx(tx *sql.Tx, noteID int64, completed bool) (int64, error)
+	DeleteCompleted(noteID int64) (int64, error)
+	MoveToNote(noteID, targetNoteID int64, itemIDs []int64) (int64, error)
+	SetStyle(noteID int64, itemIDs []int64, style model.TextStyle) (int64, error)
+	GetDue(userID int64, until time.Time) ([]*model.ChecklistItem, error)
+	GetPendingReminders(now time.Time, limit int) ([]*model.ChecklistReminder, error)
+	MarkReminded(itemID int64, at time.Time) error
 }
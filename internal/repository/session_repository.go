@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"notes-api/internal/model"
+	"time"
+)
+
+// ErrSessionNotFound - сеанса нет, он принадлежит другому пользователю или уже завершён
+var ErrSessionNotFound = errors.New("сеанс не найден")
+
+// Чаще этого last_seen_at не обновляется, чтобы не писать в БД на каждый запрос
+const sessionTouchInterval = time.Minute
+
+type SessionRepository interface {
+	Create(s *model.Session, tokenID string, tokenVersion int) error
+	GetActiveByTokenID(tokenID string, now time.Time) (*model.Session, error)
+	TouchLastSeen(id int64, now time.Time) error
+	GetActive(userID int64, now time.Time) ([]*model.Session, error)
+	Revoke(id, userID int64, now time.Time) error
+	MarkReauthenticated(id, userID int64, now time.Time) error
+	IsReauthenticated(id, userID int64, since time.Time) (bool, error)
+}
+
+type PostgresSessionRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresSessionRepository(db *sql.DB) *PostgresSessionRepository {
+	return &PostgresSessionRepository{db: db}
+}
+
+const sessionColumns = `s.id, s.user_id, s.user_agent, s.ip, s.created_at, s.last_seen_at, s.expires_at`
+
+// activeSessionCondition - сеанс не отозван, не истёк и выдан после последней смены пароля
+// или другого отзыва всех токенов пользователя
+const activeSessionCondition = `s.revoked_at IS NULL AND s.expires_at > $2 AND s.token_version = u.token_version`
+
+func scanSession(s rowScanner) (*model.Session, error) {
+	session := new(model.Session)
+	err := s.Scan(&session.ID, &session.UserID, &session.UserAgent, &session.IP,
+		&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Create сохраняет сеанс и заодно удаляет завершённые сеансы пользователя
+func (r *PostgresSessionRepository) Create(s *model.Session, tokenID string, tokenVersion int) error {
+	_, err := r.db.Exec(`DELETE FROM sessions WHERE user_id = $1
+		AND (revoked_at IS NOT NULL OR expires_at <= $2 OR token_version <> $3);`, s.UserID, s.CreatedAt, tokenVersion)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO sessions (user_id, token_id, token_version, user_agent, ip, created_at, last_seen_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $6, $7) RETURNING id, last_seen_at;`
+	return r.db.QueryRow(query, s.UserID, tokenID, tokenVersion, s.UserAgent, s.IP, s.CreatedAt, s.ExpiresAt).
+		Scan(&s.ID, &s.LastSeenAt)
+}
+
+// GetActiveByTokenID ищет действующий сеанс по jti токена
+func (r *PostgresSessionRepository) GetActiveByTokenID(tokenID string, now time.Time) (*model.Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.token_id = $1 AND ` + activeSessionCondition + `;`
+	s, err := scanSession(r.db.QueryRow(query, tokenID, now))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("сеанс завершён")
+	}
+	return s, err
+}
+
+// TouchLastSeen обновляет время последней активности не чаще раза в sessionTouchInterval
+func (r *PostgresSessionRepository) TouchLastSeen(id int64, now time.Time) error {
+	query := `UPDATE sessions SET last_seen_at = $2 WHERE id = $1 AND last_seen_at < $3;`
+	_, err := r.db.Exec(query, id, now, now.Add(-sessionTouchInterval))
+	return err
+}
+
+// GetActive возвращает действующие сеансы пользователя, последние активные первыми
+func (r *PostgresSessionRepository) GetActive(userID int64, now time.Time) ([]*model.Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.user_id = $1 AND ` + activeSessionCondition + `
+		ORDER BY s.last_seen_at DESC;`
+	rows, err := r.db.Query(query, userID, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*model.Session{}
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// Revoke завершает сеанс пользователя: его токен больше не принимается
+func (r *PostgresSessionRepository) Revoke(id, userID int64, now time.Time) error {
+	res, err := r.db.Exec(`UPDATE sessions SET revoked_at = $3 WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;`, id, userID, now)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// MarkReauthenticated запоминает, что пользователь только что подтвердил вход у провайдера в этом сеансе
+func (r *PostgresSessionRepository) MarkReauthenticated(id, userID int64, now time.Time) error {
+	res, err := r.db.Exec(`UPDATE sessions SET reauthenticated_at = $3 WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;`, id, userID, now)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// IsReauthenticated проверяет, подтверждал ли пользователь вход в этом сеансе не раньше since
+func (r *PostgresSessionRepository) IsReauthenticated(id, userID int64, since time.Time) (bool, error) {
+	var ok bool
+	query := `SELECT EXISTS (SELECT 1 FROM sessions WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND reauthenticated_at >= $3);`
+	err := r.db.QueryRow(query, id, userID, since).Scan(&ok)
+	return ok, err
+}
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"database/sql"
+	"notes-api/internal/model"
+	"time"
+)
+
+type StatsRepository interface {
+	GetUsageStats(now time.Time) (*model.UsageStats, error)
+}
+
+type PostgresStatsRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresStatsRepository(db *sql.DB) *PostgresStatsRepository {
+	return &PostgresStatsRepository{db: db}
+}
+
+// GetUsageStats считает пользователей, заметки, файлы и действующие API-токены одним запросом
+func (r *PostgresStatsRepository) GetUsageStats(now time.Time) (*model.UsageStats, error) {
+	query := `SELECT
+		(SELECT count(*) FROM users),
+		(SELECT count(*) FROM users WHERE role = 'admin'),
+		(SELECT count(*) FROM users WHERE disabled_at IS NOT NULL),
+		(SELECT count(*) FROM users WHERE delete_after IS NOT NULL),
+		(SELECT count(*) FROM users WHERE totp_enabled),
+		(SELECT count(*) FROM users WHERE created_at > $1),
+		(SELECT count(*) FROM notes),
+		(SELECT count(*) FROM attachments),
+		(SELECT COALESCE(sum(size), 0) FROM attachments),
+		(SELECT count(*) FROM images),
+		(SELECT COALESCE(sum(size), 0) FROM images),
+		(SELECT count(*) FROM api_tokens WHERE expires_at IS NULL OR expires_at > $2);`
+
+	s := &model.UsageStats{}
+	err := r.db.QueryRow(query, now.AddDate(0, 0, -30), now).Scan(
+		&s.Users.Total, &s.Users.Admins, &s.Users.Disabled, &s.Users.PendingDeletion, &s.Users.TwoFactor, &s.Users.NewLast30Days,
+		&s.Notes,
+		&s.Attachments.Count, &s.Attachments.Bytes,
+		&s.Images.Count, &s.Images.Bytes,
+		&s.APITokens,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -2,9 +2,24 @@ package repository
 
 import (
 	"database/sql"
+	"errors"
 	"notes-api/internal/model"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrInvalidResetToken - токен сброса пароля не найден, уже использован или истёк
+	ErrInvalidResetToken = errors.New("ссылка для сброса пароля недействительна или устарела")
+	ErrUsernameTaken     = errors.New("имя пользователя уже занято")
+	ErrEmailTaken        = errors.New("этот email уже используется")
 )
 
+const userColumns = `id, username, password, COALESCE(email, ''), token_version, delete_after,
+	COALESCE(totp_secret, ''), totp_enabled, totp_last_step, role, disabled_at,
+	display_name, locale, timezone, default_style, note_sort, created_at`
+
 type UserRepository struct {
 	db *sql.DB
 }
@@ -13,17 +28,360 @@ func NewUserRepository(db *sql.DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
+func scanUser(s rowScanner) (*model.User, error) {
+	user := &model.User{}
+	var deleteAfter, disabledAt sql.NullTime
+	err := s.Scan(&user.ID, &user.Username, &user.Password, &user.Email, &user.TokenVersion, &deleteAfter,
+		&user.TOTPSecret, &user.TOTPEnabled, &user.TOTPLastStep, &user.Role, &disabledAt,
+		&user.DisplayName, &user.Locale, &user.Timezone, &user.DefaultStyle, &user.NoteSort, &user.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if deleteAfter.Valid {
+		user.DeleteAfter = &deleteAfter.Time
+	}
+	if disabledAt.Valid {
+		user.DisabledAt = &disabledAt.Time
+	}
+	return user, nil
+}
+
 func (r *UserRepository) Create(user *model.User) error {
-	query := `INSERT INTO users (username, password) VALUES ($1, $2) RETURNING id`
-	return r.db.QueryRow(query, user.Username, user.Password).Scan(&user.ID)
+	// Пустой email сохраняется как NULL, чтобы не нарушать уникальность
+	var email sql.NullString
+	if user.Email != "" {
+		email = sql.NullString{String: user.Email, Valid: true}
+	}
+	query := `INSERT INTO users (username, password, email) VALUES ($1, $2, $3) RETURNING id, created_at`
+	err := r.db.QueryRow(query, user.Username, user.Password, email).Scan(&user.ID, &user.CreatedAt)
+	return uniqueViolation(err)
+}
+
+// uniqueViolation заменяет нарушение уникальности имени или email на ErrUsernameTaken/ErrEmailTaken
+func uniqueViolation(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		switch pqErr.Constraint {
+		case "users_username_key":
+			return ErrUsernameTaken
+		case "users_email_key":
+			return ErrEmailTaken
+		}
+	}
+	return err
 }
 
 func (r *UserRepository) GetByUsername(username string) (*model.User, error) {
-	user := &model.User{}
-	query := `SELECT id, username, password FROM users WHERE username = $1`
-	err := r.db.QueryRow(query, username).Scan(&user.ID, &user.Username, &user.Password)
+	query := `SELECT ` + userColumns + ` FROM users WHERE username = $1`
+	return scanUser(r.db.QueryRow(query, username))
+}
+
+func (r *UserRepository) GetByID(id int64) (*model.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+	return scanUser(r.db.QueryRow(query, id))
+}
+
+// GetByLogin ищет пользователя по имени или email (без учёта регистра)
+func (r *UserRepository) GetByLogin(login string) (*model.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE username = $1 OR lower(email) = lower($1) LIMIT 1`
+	return scanUser(r.db.QueryRow(query, login))
+}
+
+// UpdateProfile сохраняет отображаемое имя, email и настройки пользователя
+func (r *UserRepository) UpdateProfile(user *model.User) error {
+	var email sql.NullString
+	if user.Email != "" {
+		email = sql.NullString{String: user.Email, Valid: true}
+	}
+	query := `UPDATE users SET display_name = $2, email = $3, locale = $4, timezone = $5, default_style = $6, note_sort = $7
+		WHERE id = $1`
+	_, err := r.db.Exec(query, user.ID, user.DisplayName, email, user.Locale, user.Timezone, user.DefaultStyle, user.NoteSort)
+	return uniqueViolation(err)
+}
+
+// UpdatePassword меняет пароль и увеличивает версию токенов. Возвращает новую версию
+func (r *UserRepository) UpdatePassword(userID int64, passwordHash string) (int, error) {
+	query := `UPDATE users SET password = $2, token_version = token_version + 1 WHERE id = $1 RETURNING token_version`
+	var version int
+	err := r.db.QueryRow(query, userID, passwordHash).Scan(&version)
+	return version, err
+}
+
+// ScheduleDeletion помечает аккаунт на удаление и отзывает все выданные токены
+func (r *UserRepository) ScheduleDeletion(userID int64, deleteAfter time.Time) error {
+	query := `UPDATE users SET delete_after = $2, token_version = token_version + 1 WHERE id = $1`
+	_, err := r.db.Exec(query, userID, deleteAfter)
+	return err
+}
+
+// CancelDeletion снимает пометку на удаление
+func (r *UserRepository) CancelDeletion(userID int64) error {
+	_, err := r.db.Exec(`UPDATE users SET delete_after = NULL WHERE id = $1`, userID)
+	return err
+}
+
+// GetDueDeletion возвращает id аккаунтов, срок удаления которых наступил к моменту now
+func (r *UserRepository) GetDueDeletion(now time.Time, limit int) ([]int64, error) {
+	query := `SELECT id FROM users WHERE delete_after <= $1 ORDER BY delete_after LIMIT $2`
+	rows, err := r.db.Query(query, now, limit)
 	if err != nil {
 		return nil, err
 	}
-	return user, nil
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// DeleteIfDue удаляет аккаунт вместе с заметками (каскадом), если удаление
+// не было отменено. Возвращает false, если пользователь успел войти и отменить удаление
+func (r *UserRepository) DeleteIfDue(userID int64, now time.Time) (bool, error) {
+	res, err := r.db.Exec(`DELETE FROM users WHERE id = $1 AND delete_after <= $2`, userID, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// CreateResetToken сохраняет хеш токена сброса пароля
+func (r *UserRepository) CreateResetToken(userID int64, tokenHash string, expiresAt time.Time) error {
+	query := `INSERT INTO password_reset_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`
+	_, err := r.db.Exec(query, userID, tokenHash, expiresAt)
+	return err
+}
+
+// ResetPassword по хешу токена устанавливает новый пароль. Токен одноразовый:
+// он и остальные неиспользованные токены пользователя помечаются использованными
+func (r *UserRepository) ResetPassword(tokenHash, passwordHash string, now time.Time) (int64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	err = tx.QueryRow(`UPDATE password_reset_tokens SET used_at = $2
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+		RETURNING user_id`, tokenHash, now).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidResetToken
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(`UPDATE password_reset_tokens SET used_at = $2 WHERE user_id = $1 AND used_at IS NULL`, userID, now); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`UPDATE users SET password = $2, token_version = token_version + 1 WHERE id = $1`, userID, passwordHash); err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
+
+// SetTOTPSecret сохраняет новый секрет до подтверждения. Если TOTP уже включён, возвращает false
+func (r *UserRepository) SetTOTPSecret(userID int64, secret string) (bool, error) {
+	res, err := r.db.Exec(`UPDATE users SET totp_secret = $2 WHERE id = $1 AND NOT totp_enabled`, userID, secret)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// EnableTOTP включает TOTP с подтверждённым кодом интервала step и сохраняет хеши кодов восстановления
+func (r *UserRepository) EnableTOTP(userID, step int64, codeHashes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE users SET totp_enabled = TRUE, totp_last_step = $2
+		WHERE id = $1 AND NOT totp_enabled AND totp_secret IS NOT NULL`, userID, step)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = errors.New("двухфакторная аутентификация уже включена или не настроена")
+		}
+		return err
+	}
+
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DisableTOTP отключает TOTP и удаляет коды восстановления
+func (r *UserRepository) DisableTOTP(userID int64) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE users SET totp_secret = NULL, totp_enabled = FALSE, totp_last_step = 0 WHERE id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep запоминает использованный интервал. Возвращает false, если код этого
+// или более позднего интервала уже был принят (повторное использование кода)
+func (r *UserRepository) UseTOTPStep(userID, step int64) (bool, error) {
+	res, err := r.db.Exec(`UPDATE users SET totp_last_step = $2 WHERE id = $1 AND totp_last_step < $2`, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// UseRecoveryCode помечает код восстановления использованным. Возвращает false, если код не найден или уже использован
+func (r *UserRepository) UseRecoveryCode(userID int64, codeHash string) (bool, error) {
+	res, err := r.db.Exec(`UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ReplaceRecoveryCodes заменяет все коды восстановления пользователя новыми
+func (r *UserRepository) ReplaceRecoveryCodes(userID int64, codeHashes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int64, codeHashes []string) error {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec(`INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adminUserQuery выбирает пользователей с числом заметок и объёмом файлов
+const adminUserQuery = `SELECT u.id, u.username, COALESCE(u.email, ''), u.role, u.disabled_at, u.delete_after,
+		u.totp_enabled, u.created_at,
+		(SELECT count(*) FROM notes n WHERE n.user_id = u.id),
+		(SELECT COALESCE(sum(a.size), 0) FROM attachments a WHERE a.user_id = u.id) +
+		(SELECT COALESCE(sum(i.size), 0) FROM images i WHERE i.user_id = u.id)
+	FROM users u`
+
+func scanAdminUser(s rowScanner) (*model.AdminUser, error) {
+	u := &model.AdminUser{}
+	var disabledAt, deleteAfter sql.NullTime
+	err := s.Scan(&u.ID, &u.Username, &u.Email, &u.Role, &disabledAt, &deleteAfter,
+		&u.TOTPEnabled, &u.CreatedAt, &u.NoteCount, &u.StorageBytes)
+	if err != nil {
+		return nil, err
+	}
+	if disabledAt.Valid {
+		u.DisabledAt = &disabledAt.Time
+	}
+	if deleteAfter.Valid {
+		u.DeleteAfter = &deleteAfter.Time
+	}
+	return u, nil
+}
+
+// List возвращает пользователей по порядку регистрации. search ищет по подстроке имени или email
+func (r *UserRepository) List(search string, limit, offset int) ([]*model.AdminUser, error) {
+	query := adminUserQuery + `
+		WHERE $1 = '' OR u.username ILIKE '%' || $1 || '%' OR u.email ILIKE '%' || $1 || '%'
+		ORDER BY u.id LIMIT $2 OFFSET $3`
+	rows, err := r.db.Query(query, search, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*model.AdminUser{}
+	for rows.Next() {
+		u, err := scanAdminUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func (r *UserRepository) GetAdminUser(id int64) (*model.AdminUser, error) {
+	return scanAdminUser(r.db.QueryRow(adminUserQuery+` WHERE u.id = $1`, id))
+}
+
+// SetDisabled блокирует или разблокирует пользователя. Блокировка отзывает все выданные токены
+func (r *UserRepository) SetDisabled(userID int64, disabled bool, now time.Time) error {
+	query := `UPDATE users SET disabled_at = NULL WHERE id = $1`
+	args := []interface{}{userID}
+	if disabled {
+		query = `UPDATE users SET disabled_at = COALESCE(disabled_at, $2), token_version = token_version + 1 WHERE id = $1`
+		args = append(args, now)
+	}
+	return execAffectingUser(r.db, query, args...)
+}
+
+// SetRole меняет роль. Роль записана в токенах, поэтому выданные ранее токены отзываются
+func (r *UserRepository) SetRole(userID int64, role model.Role) error {
+	return execAffectingUser(r.db, `UPDATE users SET role = $2, token_version = token_version + 1 WHERE id = $1`, userID, role)
+}
+
+// RevokeTokens завершает все сеансы пользователя: выданные ранее JWT перестают действовать
+func (r *UserRepository) RevokeTokens(userID int64) error {
+	return execAffectingUser(r.db, `UPDATE users SET token_version = token_version + 1 WHERE id = $1`, userID)
+}
+
+// PromoteAdmins назначает администраторами существующих пользователей из списка.
+// Возвращает число пользователей, чья роль изменилась
+func (r *UserRepository) PromoteAdmins(usernames []string) (int64, error) {
+	res, err := r.db.Exec(`UPDATE users SET role = 'admin', token_version = token_version + 1
+		WHERE username = ANY($1) AND role <> 'admin'`, pq.Array(usernames))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func execAffectingUser(db *sql.DB, query string, args ...interface{}) error {
+	res, err := db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
 }
@@ -0,0 +1,210 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"notes-api/internal/model"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrIdentityTaken - внешняя учётная запись уже привязана к другому пользователю
+var ErrIdentityTaken = errors.New("эта учётная запись уже привязана к другому пользователю")
+
+type UserIdentityRepository interface {
+	CreateState(stateHash string, st *model.OIDCState) error
+	ConsumeState(stateHash string, now time.Time) (*model.OIDCState, error)
+	FindUserID(provider, subject string) (int64, bool, error)
+	TouchLogin(provider, subject string, now time.Time) error
+	Link(userID int64, provider, subject, email string) (*model.UserIdentity, error)
+	CreateUserWithIdentity(user *model.User, provider, subject string) error
+	GetByUserID(userID int64) ([]*model.UserIdentity, error)
+	Delete(id, userID int64) error
+}
+
+const userIdentityColumns = `id, user_id, provider, subject, COALESCE(email, ''), created_at, last_login_at`
+
+func scanUserIdentity(s rowScanner) (*model.UserIdentity, error) {
+	i := new(model.UserIdentity)
+	var lastLoginAt sql.NullTime
+	if err := s.Scan(&i.ID, &i.UserID, &i.Provider, &i.Subject, &i.Email, &i.CreatedAt, &lastLoginAt); err != nil {
+		return nil, err
+	}
+	if lastLoginAt.Valid {
+		i.LastLoginAt = &lastLoginAt.Time
+	}
+	return i, nil
+}
+
+type PostgresUserIdentityRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresUserIdentityRepository(db *sql.DB) UserIdentityRepository {
+	return &PostgresUserIdentityRepository{db: db}
+}
+
+// CreateState сохраняет незавершённый вход и заодно удаляет просроченные
+func (r *PostgresUserIdentityRepository) CreateState(stateHash string, st *model.OIDCState) error {
+	if _, err := r.db.Exec(`DELETE FROM oidc_states WHERE expires_at < $1;`, time.Now()); err != nil {
+		return err
+	}
+	var userID sql.NullInt64
+	if st.UserID != 0 {
+		userID = sql.NullInt64{Int64: st.UserID, Valid: true}
+	}
+	var sessionID sql.NullInt64
+	if st.SessionID != 0 {
+		sessionID = sql.NullInt64{Int64: st.SessionID, Valid: true}
+	}
+	query := `INSERT INTO oidc_states (state_hash, provider, nonce, code_verifier, user_id, session_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7);`
+	_, err := r.db.Exec(query, stateHash, st.Provider, st.Nonce, st.CodeVerifier, userID, sessionID, st.ExpiresAt)
+	return err
+}
+
+// ConsumeState возвращает и удаляет незавершённый вход: каждый state используется один раз
+func (r *PostgresUserIdentityRepository) ConsumeState(stateHash string, now time.Time) (*model.OIDCState, error) {
+	query := `DELETE FROM oidc_states WHERE state_hash = $1
+		RETURNING provider, nonce, code_verifier, user_id, session_id, expires_at;`
+	st := new(model.OIDCState)
+	var userID, sessionID sql.NullInt64
+	err := r.db.QueryRow(query, stateHash).Scan(&st.Provider, &st.Nonce, &st.CodeVerifier, &userID, &sessionID, &st.ExpiresAt)
+	if err == sql.ErrNoRows || (err == nil && !st.ExpiresAt.After(now)) {
+		return nil, errors.New("вход устарел или уже завершён, начните заново")
+	}
+	if err != nil {
+		return nil, err
+	}
+	st.UserID = userID.Int64
+	st.SessionID = sessionID.Int64
+	return st, nil
+}
+
+// FindUserID ищет пользователя, к которому привязана внешняя учётная запись
+func (r *PostgresUserIdentityRepository) FindUserID(provider, subject string) (int64, bool, error) {
+	var userID int64
+	err := r.db.QueryRow(`SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2;`, provider, subject).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return userID, true, nil
+}
+
+func (r *PostgresUserIdentityRepository) TouchLogin(provider, subject string, now time.Time) error {
+	_, err := r.db.Exec(`UPDATE user_identities SET last_login_at = $3 WHERE provider = $1 AND subject = $2;`, provider, subject, now)
+	return err
+}
+
+// Link привязывает внешнюю учётную запись к пользователю
+func (r *PostgresUserIdentityRepository) Link(userID int64, provider, subject, email string) (*model.UserIdentity, error) {
+	return insertIdentity(r.db, userID, provider, subject, email)
+}
+
+// CreateUserWithIdentity создаёт пользователя и привязывает к нему внешнюю учётную запись в одной транзакции.
+// Если имя user.Username занято, к нему добавляется номер
+func (r *PostgresUserIdentityRepository) CreateUserWithIdentity(user *model.User, provider, subject string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	username, err := freeUsernameTx(tx, user.Username)
+	if err != nil {
+		return err
+	}
+	user.Username = username
+
+	var email sql.NullString
+	if user.Email != "" {
+		var taken bool
+		if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE lower(email) = lower($1));`, user.Email).Scan(&taken); err != nil {
+			return err
+		}
+		// Занятый email не переносится: привязать учётную запись к существующему аккаунту может только его владелец
+		if taken {
+			user.Email = ""
+		} else {
+			email = sql.NullString{String: user.Email, Valid: true}
+		}
+	}
+
+	query := `INSERT INTO users (username, password, email) VALUES ($1, $2, $3) RETURNING id, created_at;`
+	if err := tx.QueryRow(query, user.Username, user.Password, email).Scan(&user.ID, &user.CreatedAt); err != nil {
+		return err
+	}
+	if _, err := insertIdentity(tx, user.ID, provider, subject, user.Email); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *PostgresUserIdentityRepository) GetByUserID(userID int64) ([]*model.UserIdentity, error) {
+	rows, err := r.db.Query(`SELECT `+userIdentityColumns+` FROM user_identities WHERE user_id = $1 ORDER BY provider, id;`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := make([]*model.UserIdentity, 0)
+	for rows.Next() {
+		i, err := scanUserIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, i)
+	}
+	return identities, rows.Err()
+}
+
+func (r *PostgresUserIdentityRepository) Delete(id, userID int64) error {
+	res, err := r.db.Exec(`DELETE FROM user_identities WHERE id = $1 AND user_id = $2;`, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("учётная запись не найдена")
+	}
+	return nil
+}
+
+func insertIdentity(db queryer, userID int64, provider, subject, email string) (*model.UserIdentity, error) {
+	var emailValue sql.NullString
+	if email != "" {
+		emailValue = sql.NullString{String: email, Valid: true}
+	}
+	query := `INSERT INTO user_identities (user_id, provider, subject, email, created_at, last_login_at)
+		VALUES ($1, $2, $3, $4, $5, $5) RETURNING ` + userIdentityColumns + `;`
+	identity, err := scanUserIdentity(db.QueryRow(query, userID, provider, subject, emailValue, time.Now()))
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return nil, ErrIdentityTaken
+	}
+	return identity, err
+}
+
+// freeUsernameTx возвращает base, если имя свободно, иначе base2, base3, ...
+func freeUsernameTx(tx *sql.Tx, base string) (string, error) {
+	candidate := base
+	for n := 2; n < 1000; n++ {
+		var taken bool
+		if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE username = $1);`, candidate).Scan(&taken); err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s%d", base, n)
+	}
+	return "", errors.New("не удалось подобрать свободное имя пользователя")
+}
@@ -0,0 +1,454 @@
+package richtext
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Типы блоков
+const (
+	BlockParagraph = "paragraph"
+	BlockHeading   = "heading"
+	BlockList      = "list"
+	BlockQuote     = "quote"
+	BlockCode      = "code"
+)
+
+// Типы строчных отметок
+const (
+	MarkBold      = "bold"
+	MarkItalic    = "italic"
+	MarkUnderline = "underline"
+	MarkStrike    = "strike"
+	MarkCode      = "code"
+	MarkLink      = "link"
+)
+
+// Ограничения на размер документа
+const (
+	maxBlocks     = 5000
+	maxQuoteDepth = 5
+	maxTextLength = 1 << 20
+)
+
+// Document - форматированный текст заметки: последовательность блоков
+type Document struct {
+	Blocks []Block `json:"blocks"`
+}
+
+// Block - абзац, заголовок, список, цитата или блок кода
+type Block struct {
+	Type string `json:"type" example:"paragraph"`
+	// Level - уровень заголовка от 1 до 6
+	Level int `json:"level,omitempty"`
+	// Ordered - нумерованный список
+	Ordered bool `json:"ordered,omitempty"`
+	// Language - язык блока кода
+	Language string `json:"language,omitempty"`
+	// Content - текст абзаца или заголовка
+	Content Inlines `json:"content,omitempty"`
+	// Items - пункты списка
+	Items []Inlines `json:"items,omitempty"`
+	// Blocks - содержимое цитаты
+	Blocks []Block `json:"blocks,omitempty"`
+	// Text - содержимое блока кода
+	Text string `json:"text,omitempty"`
+}
+
+// Inline - фрагмент текста с одинаковым набором отметок
+type Inline struct {
+	Text  string `json:"text" example:"Купить молоко"`
+	Marks []Mark `json:"marks,omitempty"`
+}
+
+// Mark - строчная отметка. Для ссылок заполняется Href
+type Mark struct {
+	Type string `json:"type" example:"bold"`
+	Href string `json:"href,omitempty"`
+}
+
+// Inlines - строка форматированного текста, например текст пункта чек-листа
+type Inlines []Inline
+
+// Validate проверяет структуру документа
+func (d *Document) Validate() error {
+	count, length := 0, 0
+	return validateBlocks(d.Blocks, 0, &count, &length)
+}
+
+func validateBlocks(blocks []Block, depth int, count, length *int) error {
+	for i, b := range blocks {
+		*count++
+		if *count > maxBlocks {
+			return fmt.Errorf("документ содержит больше %d блоков", maxBlocks)
+		}
+
+		switch b.Type {
+		case BlockParagraph:
+		case BlockHeading:
+			if b.Level < 1 || b.Level > 6 {
+				return fmt.Errorf("блок %d: уровень заголовка должен быть от 1 до 6", i)
+			}
+		case BlockList:
+			if len(b.Items) == 0 {
+				return fmt.Errorf("блок %d: список не содержит пунктов", i)
+			}
+			for _, item := range b.Items {
+				if err := item.validate(length); err != nil {
+					return fmt.Errorf("блок %d: %w", i, err)
+				}
+			}
+		case BlockQuote:
+			if depth+1 > maxQuoteDepth {
+				return fmt.Errorf("блок %d: слишком глубокая вложенность цитат", i)
+			}
+			if err := validateBlocks(b.Blocks, depth+1, count, length); err != nil {
+				return err
+			}
+		case BlockCode:
+			*length += len(b.Text)
+		default:
+			return fmt.Errorf("блок %d: неизвестный тип %q", i, b.Type)
+		}
+
+		if b.Type != BlockParagraph && b.Type != BlockHeading && len(b.Content) > 0 {
+			return fmt.Errorf("блок %d: поле content допустимо только для абзацев и заголовков", i)
+		}
+		if err := b.Content.validate(length); err != nil {
+			return fmt.Errorf("блок %d: %w", i, err)
+		}
+		if *length > maxTextLength {
+			return errors.New("документ слишком большой")
+		}
+	}
+	return nil
+}
+
+// Validate проверяет строку форматированного текста
+func (s Inlines) Validate() error {
+	length := 0
+	if err := s.validate(&length); err != nil {
+		return err
+	}
+	if length > maxTextLength {
+		return errors.New("текст слишком большой")
+	}
+	return nil
+}
+
+func (s Inlines) validate(length *int) error {
+	for _, in := range s {
+		if in.Text == "" {
+			return errors.New("фрагмент текста не может быть пустым")
+		}
+		*length += len(in.Text)
+
+		seen := make(map[string]bool, len(in.Marks))
+		for _, m := range in.Marks {
+			switch m.Type {
+			case MarkBold, MarkItalic, MarkUnderline, MarkStrike, MarkCode:
+				if m.Href != "" {
+					return fmt.Errorf("href допустим только для отметки link")
+				}
+			case MarkLink:
+				if !IsSafeURL(m.Href) {
+					return fmt.Errorf("недопустимая ссылка: %q", m.Href)
+				}
+			default:
+				return fmt.Errorf("неизвестная отметка %q", m.Type)
+			}
+			if seen[m.Type] {
+				return fmt.Errorf("отметка %q указана несколько раз", m.Type)
+			}
+			seen[m.Type] = true
+		}
+	}
+	return nil
+}
+
+// IsSafeURL разрешает только http(s)- и mailto-ссылки, а также пути на этом же сервере
+// (например, ссылки на картинки заметки)
+func IsSafeURL(href string) bool {
+	href = strings.TrimSpace(href)
+	u, err := url.Parse(href)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "":
+		return strings.HasPrefix(href, "/") && !strings.HasPrefix(href, "//") && !strings.HasPrefix(href, `/\`)
+	case "http", "https":
+		return u.Host != ""
+	case "mailto":
+		return u.Opaque != ""
+	}
+	return false
+}
+
+// HasMark проверяет наличие отметки у фрагмента
+func (in Inline) HasMark(markType string) bool {
+	return in.mark(markType) != nil
+}
+
+func (in Inline) mark(markType string) *Mark {
+	for i := range in.Marks {
+		if in.Marks[i].Type == markType {
+			return &in.Marks[i]
+		}
+	}
+	return nil
+}
+
+// PlainText возвращает текст документа без форматирования: блоки разделяются пустой строкой
+func (d *Document) PlainText() string {
+	return strings.Join(plainBlocks(d.Blocks), "\n\n")
+}
+
+func plainBlocks(blocks []Block) []string {
+	parts := make([]string, 0, len(blocks))
+	for _, b := range blocks {
+		switch b.Type {
+		case BlockList:
+			items := make([]string, len(b.Items))
+			for i, item := range b.Items {
+				items[i] = item.PlainText()
+			}
+			parts = append(parts, strings.Join(items, "\n"))
+		case BlockQuote:
+			parts = append(parts, plainBlocks(b.Blocks)...)
+		case BlockCode:
+			parts = append(parts, b.Text)
+		default:
+			parts = append(parts, b.Content.PlainText())
+		}
+	}
+	return parts
+}
+
+// PlainText возвращает текст без форматирования
+func (s Inlines) PlainText() string {
+	var sb strings.Builder
+	for _, in := range s {
+		sb.WriteString(in.Text)
+	}
+	return sb.String()
+}
+
+// LegacyStyle вычисляет стиль для старых клиентов, которые знают только
+// normal/bold/italic для всего текста: bold или italic возвращается,
+// только если так отформатирован весь текст
+func (d *Document) LegacyStyle() string {
+	var all Inlines
+	collectInlines(d.Blocks, &all)
+	return all.LegacyStyle()
+}
+
+func collectInlines(blocks []Block, out *Inlines) {
+	for _, b := range blocks {
+		*out = append(*out, b.Content...)
+		for _, item := range b.Items {
+			*out = append(*out, item...)
+		}
+		collectInlines(b.Blocks, out)
+	}
+}
+
+// LegacyStyle вычисляет стиль для старых клиентов (см. Document.LegacyStyle)
+func (s Inlines) LegacyStyle() string {
+	if len(s) == 0 {
+		return "normal"
+	}
+	bold, italic := true, true
+	for _, in := range s {
+		bold = bold && in.HasMark(MarkBold)
+		italic = italic && in.HasMark(MarkItalic)
+	}
+	switch {
+	case bold:
+		return "bold"
+	case italic:
+		return "italic"
+	}
+	return "normal"
+}
+
+// FromLegacy строит документ из content и style старого формата:
+// content разбирается как Markdown, а style применяется ко всему тексту
+func FromLegacy(text, style string) *Document {
+	doc := FromMarkdown(text)
+	if mark := legacyMark(style); mark != "" {
+		applyMark(doc.Blocks, Mark{Type: mark})
+	}
+	return doc
+}
+
+func legacyMark(style string) string {
+	switch style {
+	case "bold":
+		return MarkBold
+	case "italic":
+		return MarkItalic
+	}
+	return ""
+}
+
+func applyMark(blocks []Block, mark Mark) {
+	for i := range blocks {
+		b := &blocks[i]
+		b.Content = b.Content.withMark(mark)
+		for j := range b.Items {
+			b.Items[j] = b.Items[j].withMark(mark)
+		}
+		applyMark(b.Blocks, mark)
+	}
+}
+
+func (s Inlines) withMark(mark Mark) Inlines {
+	for i := range s {
+		s[i].Marks = withMark(s[i].Marks, mark)
+	}
+	return s
+}
+
+// InlinesFromLegacy строит строку форматированного текста из простого текста и стиля
+func InlinesFromLegacy(text, style string) Inlines {
+	if text == "" {
+		return Inlines{}
+	}
+	in := Inline{Text: text}
+	if mark := legacyMark(style); mark != "" {
+		in.Marks = []Mark{{Type: mark}}
+	}
+	return Inlines{in}
+}
+
+// normalize склеивает соседние фрагменты с одинаковыми отметками и убирает пустые
+func (s Inlines) normalize() Inlines {
+	out := make(Inlines, 0, len(s))
+	for _, in := range s {
+		if in.Text == "" {
+			continue
+		}
+		if n := len(out); n > 0 && sameMarks(out[n-1].Marks, in.Marks) {
+			out[n-1].Text += in.Text
+			continue
+		}
+		out = append(out, in)
+	}
+	return out
+}
+
+func sameMarks(a, b []Mark) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, m := range a {
+		found := false
+		for _, o := range b {
+			if m == o {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// wrapMarks - отметки, которые оборачивают текст; code обрабатывается вместе с самим текстом
+var wrapMarks = []string{MarkLink, MarkBold, MarkItalic, MarkStrike, MarkUnderline}
+
+// renderGrouped выводит строку так, чтобы соседние фрагменты с общей отметкой
+// оборачивались один раз: "**a _b_**" вместо "**a ****_b_**"
+func renderGrouped(s Inlines, leaf func(Inline) string, wrap func(m Mark, inner string) string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		// Выбираем отметку с самой длинной серией, начиная с текущего фрагмента
+		var best *Mark
+		bestEnd := i + 1
+		for _, markType := range wrapMarks {
+			m := s[i].mark(markType)
+			if m == nil {
+				continue
+			}
+			end := i + 1
+			for end < len(s) {
+				if o := s[end].mark(markType); o == nil || *o != *m {
+					break
+				}
+				end++
+			}
+			if best == nil || end > bestEnd {
+				best, bestEnd = m, end
+			}
+		}
+
+		if best == nil {
+			sb.WriteString(leaf(s[i]))
+			i++
+			continue
+		}
+
+		inner := make(Inlines, 0, bestEnd-i)
+		for _, in := range s[i:bestEnd] {
+			in.Marks = withoutMark(in.Marks, best.Type)
+			inner = append(inner, in)
+		}
+		sb.WriteString(wrap(*best, renderGrouped(inner, leaf, wrap)))
+		i = bestEnd
+	}
+	return sb.String()
+}
+
+func withoutMark(marks []Mark, markType string) []Mark {
+	out := make([]Mark, 0, len(marks))
+	for _, m := range marks {
+		if m.Type != markType {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// Value сохраняет документ в колонку JSONB
+func (d Document) Value() (driver.Value, error) {
+	return json.Marshal(d)
+}
+
+// Scan читает документ из колонки JSONB
+func (d *Document) Scan(src interface{}) error {
+	return scanJSON(src, d)
+}
+
+// Value сохраняет строку в колонку JSONB, nil сохраняется как NULL
+func (s Inlines) Value() (driver.Value, error) {
+	if s == nil {
+		return nil, nil
+	}
+	return json.Marshal(s)
+}
+
+// Scan читает строку из колонки JSONB
+func (s *Inlines) Scan(src interface{}) error {
+	if src == nil {
+		*s = nil
+		return nil
+	}
+	return scanJSON(src, s)
+}
+
+func scanJSON(src interface{}, dest interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, dest)
+	case string:
+		return json.Unmarshal([]byte(v), dest)
+	}
+	return fmt.Errorf("неподдерживаемый тип для JSON: %T", src)
+}
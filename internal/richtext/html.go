@@ -0,0 +1,296 @@
+package richtext
+
+import (
+	"html"
+	"strconv"
+	"strings"
+
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ToHTML преобразует документ в HTML. Текст экранируется, ссылки получают rel="nofollow noopener noreferrer"
+func (d *Document) ToHTML() string {
+	var sb strings.Builder
+	writeHTMLBlocks(&sb, d.Blocks)
+	return sb.String()
+}
+
+func writeHTMLBlocks(sb *strings.Builder, blocks []Block) {
+	for _, b := range blocks {
+		switch b.Type {
+		case BlockHeading:
+			tag := "h" + strconv.Itoa(b.Level)
+			sb.WriteString("<" + tag + ">" + b.Content.ToHTML() + "</" + tag + ">\n")
+		case BlockList:
+			tag := "ul"
+			if b.Ordered {
+				tag = "ol"
+			}
+			sb.WriteString("<" + tag + ">\n")
+			for _, item := range b.Items {
+				sb.WriteString("<li>" + item.ToHTML() + "</li>\n")
+			}
+			sb.WriteString("</" + tag + ">\n")
+		case BlockQuote:
+			sb.WriteString("<blockquote>\n")
+			writeHTMLBlocks(sb, b.Blocks)
+			sb.WriteString("</blockquote>\n")
+		case BlockCode:
+			sb.WriteString("<pre><code")
+			if b.Language != "" {
+				sb.WriteString(` class="language-` + html.EscapeString(b.Language) + `"`)
+			}
+			sb.WriteString(">" + html.EscapeString(b.Text) + "</code></pre>\n")
+		default:
+			sb.WriteString("<p>" + b.Content.ToHTML() + "</p>\n")
+		}
+	}
+}
+
+// ToHTML преобразует строку форматированного текста в HTML
+func (s Inlines) ToHTML() string {
+	return renderGrouped(s, func(in Inline) string {
+		text := strings.ReplaceAll(html.EscapeString(in.Text), "\n", "<br>")
+		if in.HasMark(MarkCode) {
+			return "<code>" + text + "</code>"
+		}
+		return text
+	}, func(m Mark, inner string) string {
+		switch m.Type {
+		case MarkBold:
+			return "<strong>" + inner + "</strong>"
+		case MarkItalic:
+			return "<em>" + inner + "</em>"
+		case MarkStrike:
+			return "<s>" + inner + "</s>"
+		case MarkUnderline:
+			return "<u>" + inner + "</u>"
+		case MarkLink:
+			if IsSafeURL(m.Href) {
+				return `<a href="` + html.EscapeString(m.Href) + `" rel="nofollow noopener noreferrer">` + inner + "</a>"
+			}
+		}
+		return inner
+	})
+}
+
+// inlineMarks - теги, которые превращаются в строчные отметки
+var inlineMarks = map[atom.Atom]string{
+	atom.B:      MarkBold,
+	atom.Strong: MarkBold,
+	atom.I:      MarkItalic,
+	atom.Em:     MarkItalic,
+	atom.U:      MarkUnderline,
+	atom.S:      MarkStrike,
+	atom.Strike: MarkStrike,
+	atom.Del:    MarkStrike,
+	atom.Code:   MarkCode,
+	atom.Kbd:    MarkCode,
+}
+
+// FromHTML разбирает HTML в документ. Неизвестные теги отбрасываются
+// вместе с атрибутами, остаётся только их текст; script и style пропускаются целиком
+func FromHTML(src string) (*Document, error) {
+	body := &nethtml.Node{Type: nethtml.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := nethtml.ParseFragment(strings.NewReader(src), body)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &htmlParser{}
+	for _, n := range nodes {
+		p.block(n, 0)
+	}
+	p.flush()
+	return &Document{Blocks: p.blocks}, nil
+}
+
+// htmlParser собирает блоки; строчный текст вне блочных тегов копится в pending
+type htmlParser struct {
+	blocks  []Block
+	pending Inlines
+}
+
+func (p *htmlParser) flush() {
+	if content := trimInlines(p.pending); len(content) > 0 {
+		p.blocks = append(p.blocks, Block{Type: BlockParagraph, Content: content})
+	}
+	p.pending = nil
+}
+
+func (p *htmlParser) block(n *nethtml.Node, depth int) {
+	if n.Type != nethtml.ElementNode {
+		collectHTMLInlines(n, nil, &p.pending)
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Script, atom.Style, atom.Head, atom.Template:
+		return
+	case atom.P:
+		p.flush()
+		collectHTMLChildren(n, nil, &p.pending)
+		p.flush()
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		p.flush()
+		var content Inlines
+		collectHTMLChildren(n, nil, &content)
+		if content = trimInlines(content); len(content) > 0 {
+			level := int(n.Data[1] - '0')
+			p.blocks = append(p.blocks, Block{Type: BlockHeading, Level: level, Content: content})
+		}
+	case atom.Ul, atom.Ol:
+		p.flush()
+		list := Block{Type: BlockList, Ordered: n.DataAtom == atom.Ol}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			var item Inlines
+			collectHTMLInlines(c, nil, &item)
+			if item = trimInlines(item); len(item) > 0 {
+				list.Items = append(list.Items, item)
+			}
+		}
+		if len(list.Items) > 0 {
+			p.blocks = append(p.blocks, list)
+		}
+	case atom.Blockquote:
+		p.flush()
+		if depth >= maxQuoteDepth {
+			collectHTMLChildren(n, nil, &p.pending)
+			p.flush()
+			return
+		}
+		inner := &htmlParser{}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			inner.block(c, depth+1)
+		}
+		inner.flush()
+		p.blocks = append(p.blocks, Block{Type: BlockQuote, Blocks: inner.blocks})
+	case atom.Pre:
+		p.flush()
+		code := Block{Type: BlockCode, Text: strings.TrimSuffix(textContent(n), "\n")}
+		if c := n.FirstChild; c != nil && c.DataAtom == atom.Code {
+			code.Language = languageFromClass(attr(c, "class"))
+		}
+		p.blocks = append(p.blocks, code)
+	case atom.Div, atom.Section, atom.Article, atom.Main, atom.Header, atom.Footer, atom.Body, atom.Html:
+		p.flush()
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			p.block(c, depth)
+		}
+		p.flush()
+	default:
+		collectHTMLInlines(n, nil, &p.pending)
+	}
+}
+
+func collectHTMLChildren(n *nethtml.Node, marks []Mark, out *Inlines) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		collectHTMLInlines(c, marks, out)
+	}
+}
+
+func collectHTMLInlines(n *nethtml.Node, marks []Mark, out *Inlines) {
+	switch n.Type {
+	case nethtml.TextNode:
+		text := collapseSpaces(n.Data)
+		if text != "" {
+			*out = append(*out, Inline{Text: text, Marks: marks})
+		}
+		return
+	case nethtml.ElementNode:
+	default:
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Script, atom.Style, atom.Template:
+		return
+	case atom.Br:
+		*out = append(*out, Inline{Text: "\n", Marks: marks})
+		return
+	case atom.A:
+		if href := strings.TrimSpace(attr(n, "href")); IsSafeURL(href) {
+			marks = withMark(marks, Mark{Type: MarkLink, Href: href})
+		}
+	default:
+		if mark, ok := inlineMarks[n.DataAtom]; ok {
+			marks = withMark(marks, Mark{Type: mark})
+		}
+	}
+	collectHTMLChildren(n, marks, out)
+}
+
+// trimInlines убирает пробелы по краям строки и склеивает фрагменты
+func trimInlines(s Inlines) Inlines {
+	s = s.normalize()
+	for len(s) > 0 {
+		s[0].Text = strings.TrimLeft(s[0].Text, " ")
+		if s[0].Text != "" {
+			break
+		}
+		s = s[1:]
+	}
+	for len(s) > 0 {
+		last := len(s) - 1
+		s[last].Text = strings.TrimRight(s[last].Text, " ")
+		if s[last].Text != "" {
+			break
+		}
+		s = s[:last]
+	}
+	return s.normalize()
+}
+
+func collapseSpaces(s string) string {
+	var sb strings.Builder
+	space := false
+	for _, r := range s {
+		if r == ' ' || r == '\n' || r == '\t' || r == '\r' || r == '\f' {
+			space = true
+			continue
+		}
+		if space {
+			sb.WriteByte(' ')
+			space = false
+		}
+		sb.WriteRune(r)
+	}
+	if space {
+		sb.WriteByte(' ')
+	}
+	return sb.String()
+}
+
+func textContent(n *nethtml.Node) string {
+	var sb strings.Builder
+	var walk func(*nethtml.Node)
+	walk = func(n *nethtml.Node) {
+		if n.Type == nethtml.TextNode {
+			sb.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return sb.String()
+}
+
+func attr(n *nethtml.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func languageFromClass(class string) string {
+	for _, c := range strings.Fields(class) {
+		if lang, ok := strings.CutPrefix(c, "language-"); ok {
+			return lang
+		}
+	}
+	return ""
+}
@@ -0,0 +1,409 @@
+package richtext
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	headingLine     = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	bulletItemLine  = regexp.MustCompile(`^\s{0,3}[-*+]\s+(.*)$`)
+	orderedItemLine = regexp.MustCompile(`^\s{0,3}\d{1,9}[.)]\s+(.*)$`)
+	quoteLine       = regexp.MustCompile(`^\s{0,3}>\s?(.*)$`)
+	fenceLine       = regexp.MustCompile("^\\s{0,3}(```+|~~~+)\\s*([^`\\s]*)")
+	orderedPrefix   = regexp.MustCompile(`^\d+[.)]`)
+)
+
+// markdownEscaper экранирует символы, которые иначе были бы прочитаны как разметка
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, `*`, `\*`, `_`, `\_`, "`", "\\`", `[`, `\[`, `]`, `\]`, `~`, `\~`, `<`, `\<`,
+)
+
+// EscapeMarkdown экранирует текст, чтобы он попал в Markdown без изменений
+func EscapeMarkdown(text string) string {
+	return escapeMarkdownText(text)
+}
+
+// ToMarkdown преобразует документ в Markdown. Подчёркивание в Markdown
+// отсутствует, поэтому оно записывается тегом <u>, который понимает FromMarkdown
+func (d *Document) ToMarkdown() string {
+	return strings.Join(markdownBlocks(d.Blocks), "\n\n")
+}
+
+func markdownBlocks(blocks []Block) []string {
+	parts := make([]string, 0, len(blocks))
+	for _, b := range blocks {
+		switch b.Type {
+		case BlockHeading:
+			parts = append(parts, strings.Repeat("#", b.Level)+" "+b.Content.ToMarkdown())
+		case BlockList:
+			lines := make([]string, len(b.Items))
+			for i, item := range b.Items {
+				marker := "- "
+				if b.Ordered {
+					marker = strconv.Itoa(i+1) + ". "
+				}
+				lines[i] = marker + item.ToMarkdown()
+			}
+			parts = append(parts, strings.Join(lines, "\n"))
+		case BlockQuote:
+			inner := strings.Split(strings.Join(markdownBlocks(b.Blocks), "\n\n"), "\n")
+			for i, line := range inner {
+				inner[i] = strings.TrimRight("> "+line, " ")
+			}
+			parts = append(parts, strings.Join(inner, "\n"))
+		case BlockCode:
+			fence := "```"
+			for strings.Contains(b.Text, fence) {
+				fence += "`"
+			}
+			parts = append(parts, fence+b.Language+"\n"+b.Text+"\n"+fence)
+		default:
+			text := b.Content.ToMarkdown()
+			// Абзац не должен начинаться как заголовок, цитата или список
+			if strings.HasPrefix(text, "#") || strings.HasPrefix(text, ">") ||
+				bulletItemLine.MatchString(text) || orderedPrefix.MatchString(text) {
+				text = `\` + text
+			}
+			parts = append(parts, text)
+		}
+	}
+	return parts
+}
+
+// ToMarkdown преобразует строку форматированного текста в Markdown
+func (s Inlines) ToMarkdown() string {
+	return renderGrouped(s, func(in Inline) string {
+		if in.HasMark(MarkCode) {
+			return codeSpan(in.Text)
+		}
+		return escapeMarkdownText(in.Text)
+	}, func(m Mark, inner string) string {
+		// Пробелы по краям выносятся за разделители, иначе они не распознаются как закрывающие
+		trimmed := strings.TrimLeft(inner, " ")
+		lead := inner[:len(inner)-len(trimmed)]
+		body := strings.TrimRight(trimmed, " ")
+		trail := trimmed[len(body):]
+		if body == "" {
+			return inner
+		}
+		switch m.Type {
+		case MarkBold:
+			body = "**" + body + "**"
+		case MarkItalic:
+			body = "_" + body + "_"
+		case MarkStrike:
+			body = "~~" + body + "~~"
+		case MarkUnderline:
+			body = "<u>" + body + "</u>"
+		case MarkLink:
+			body = "[" + body + "](" + strings.NewReplacer("(", "%28", ")", "%29", " ", "%20").Replace(m.Href) + ")"
+		}
+		return lead + body + trail
+	})
+}
+
+// codeSpan оборачивает текст в обратные кавычки, длина которых больше любой последовательности внутри
+func codeSpan(text string) string {
+	longest, run := 0, 0
+	for _, r := range text {
+		if r == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	fence := strings.Repeat("`", longest+1)
+	if longest > 0 {
+		return fence + " " + text + " " + fence
+	}
+	return fence + text + fence
+}
+
+// FromMarkdown разбирает подмножество Markdown: заголовки, списки, цитаты,
+// блоки кода и абзацы; в тексте - **жирный**, *курсив*, ~~зачёркнутый~~,
+// `код`, <u>подчёркнутый</u> и [ссылки](https://...). Ссылки с небезопасными
+// схемами превращаются в обычный текст
+func FromMarkdown(src string) *Document {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	return &Document{Blocks: parseMarkdownBlocks(strings.Split(src, "\n"), 0)}
+}
+
+func parseMarkdownBlocks(lines []string, depth int) []Block {
+	blocks := []Block{}
+	var paragraph []string
+
+	flush := func() {
+		if len(paragraph) > 0 {
+			if content := parseMarkdownInlines(strings.Join(paragraph, " ")); len(content) > 0 {
+				blocks = append(blocks, Block{Type: BlockParagraph, Content: content})
+			}
+			paragraph = nil
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		if m := fenceLine.FindStringSubmatch(line); m != nil {
+			flush()
+			var code []string
+			for i++; i < len(lines); i++ {
+				if strings.HasPrefix(strings.TrimSpace(lines[i]), m[1]) {
+					break
+				}
+				code = append(code, lines[i])
+			}
+			blocks = append(blocks, Block{Type: BlockCode, Language: m[2], Text: strings.Join(code, "\n")})
+			continue
+		}
+
+		if m := headingLine.FindStringSubmatch(line); m != nil {
+			flush()
+			blocks = append(blocks, Block{Type: BlockHeading, Level: len(m[1]), Content: parseMarkdownInlines(m[2])})
+			continue
+		}
+
+		if quoteLine.MatchString(line) && depth < maxQuoteDepth {
+			flush()
+			var inner []string
+			for ; i < len(lines); i++ {
+				m := quoteLine.FindStringSubmatch(lines[i])
+				if m == nil {
+					break
+				}
+				inner = append(inner, m[1])
+			}
+			i--
+			blocks = append(blocks, Block{Type: BlockQuote, Blocks: parseMarkdownBlocks(inner, depth+1)})
+			continue
+		}
+
+		if pattern, ordered := listPattern(line); pattern != nil {
+			flush()
+			list := Block{Type: BlockList, Ordered: ordered}
+			var item []string
+			addItem := func() {
+				if content := parseMarkdownInlines(strings.Join(item, " ")); len(content) > 0 {
+					list.Items = append(list.Items, content)
+				}
+				item = nil
+			}
+			for ; i < len(lines); i++ {
+				if m := pattern.FindStringSubmatch(lines[i]); m != nil {
+					addItem()
+					item = []string{m[1]}
+					continue
+				}
+				// Строка с отступом продолжает текущий пункт
+				if strings.TrimSpace(lines[i]) != "" && strings.HasPrefix(lines[i], "  ") {
+					item = append(item, strings.TrimSpace(lines[i]))
+					continue
+				}
+				break
+			}
+			addItem()
+			i--
+			if len(list.Items) > 0 {
+				blocks = append(blocks, list)
+			}
+			continue
+		}
+
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		paragraph = append(paragraph, strings.TrimSpace(line))
+	}
+	flush()
+	return blocks
+}
+
+func listPattern(line string) (*regexp.Regexp, bool) {
+	if bulletItemLine.MatchString(line) {
+		return bulletItemLine, false
+	}
+	if orderedItemLine.MatchString(line) {
+		return orderedItemLine, true
+	}
+	return nil, false
+}
+
+func parseMarkdownInlines(src string) Inlines {
+	var out Inlines
+	parseInlineInto(src, nil, &out)
+	return out.normalize()
+}
+
+func parseInlineInto(src string, marks []Mark, out *Inlines) {
+	var text strings.Builder
+	emit := func(s string, m []Mark) {
+		if s != "" {
+			*out = append(*out, Inline{Text: s, Marks: m})
+		}
+	}
+	flush := func() {
+		emit(text.String(), marks)
+		text.Reset()
+	}
+	nested := func(inner string, mark Mark) {
+		flush()
+		parseInlineInto(inner, withMark(marks, mark), out)
+	}
+
+	for i := 0; i < len(src); {
+		rest := src[i:]
+		switch {
+		case rest[0] == '\\' && len(rest) > 1 && isASCIIPunct(rest[1]):
+			text.WriteByte(rest[1])
+			i += 2
+			continue
+
+		case rest[0] == '`':
+			n := len(rest) - len(strings.TrimLeft(rest, "`"))
+			fence := rest[:n]
+			if end := strings.Index(rest[n:], fence); end >= 0 {
+				code := rest[n : n+end]
+				if len(code) > 1 && code[0] == ' ' && code[len(code)-1] == ' ' {
+					code = code[1 : len(code)-1]
+				}
+				flush()
+				emit(code, withMark(marks, Mark{Type: MarkCode}))
+				i += n + end + n
+				continue
+			}
+
+		case strings.HasPrefix(rest, "**") || strings.HasPrefix(rest, "__"):
+			if end := strings.Index(rest[2:], rest[:2]); end > 0 {
+				nested(rest[2:2+end], Mark{Type: MarkBold})
+				i += end + 4
+				continue
+			}
+
+		case strings.HasPrefix(rest, "~~"):
+			if end := strings.Index(rest[2:], "~~"); end > 0 {
+				nested(rest[2:2+end], Mark{Type: MarkStrike})
+				i += end + 4
+				continue
+			}
+
+		case rest[0] == '*' || rest[0] == '_':
+			if end := closingSingle(rest[1:], rest[0]); end > 0 {
+				nested(rest[1:1+end], Mark{Type: MarkItalic})
+				i += end + 2
+				continue
+			}
+
+		case strings.HasPrefix(strings.ToLower(rest), "<u>"):
+			if end := strings.Index(strings.ToLower(rest[3:]), "</u>"); end > 0 {
+				nested(rest[3:3+end], Mark{Type: MarkUnderline})
+				i += end + 7
+				continue
+			}
+
+		case rest[0] == '[':
+			if label, href, n, ok := parseLink(rest); ok {
+				if IsSafeURL(href) {
+					nested(label, Mark{Type: MarkLink, Href: href})
+				} else {
+					nested(label, Mark{})
+				}
+				i += n
+				continue
+			}
+		}
+
+		text.WriteByte(rest[0])
+		i++
+	}
+	flush()
+}
+
+// withMark возвращает копию marks с добавленной отметкой. Пустая отметка не добавляется
+func withMark(marks []Mark, mark Mark) []Mark {
+	out := make([]Mark, 0, len(marks)+1)
+	for _, m := range marks {
+		if m.Type != mark.Type {
+			out = append(out, m)
+		}
+	}
+	if mark.Type != "" {
+		out = append(out, mark)
+	}
+	return out
+}
+
+// closingSingle ищет одиночный закрывающий разделитель, не входящий в двойной
+func closingSingle(s string, delim byte) int {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == delim:
+			if i+1 < len(s) && s[i+1] == delim {
+				i++
+				continue
+			}
+			if i == 0 {
+				return -1
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+// parseLink разбирает [текст](адрес) и возвращает длину разобранного фрагмента
+func parseLink(s string) (label, href string, n int, ok bool) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth > 0 {
+				continue
+			}
+			if i+1 >= len(s) || s[i+1] != '(' {
+				return "", "", 0, false
+			}
+			end := closingParen(s[i+2:])
+			if end < 0 {
+				return "", "", 0, false
+			}
+			href = strings.TrimSpace(s[i+2 : i+2+end])
+			href = strings.Trim(href, "<>")
+			return s[1:i], href, i + 3 + end, s[1:i] != ""
+		}
+	}
+	return "", "", 0, false
+}
+
+// closingParen ищет скобку, закрывающую адрес ссылки, с учётом вложенных скобок
+func closingParen(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+func isASCIIPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
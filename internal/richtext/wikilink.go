@@ -0,0 +1,114 @@
+package richtext
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// wikiLink - ссылка на другую заметку вида [[Заголовок]] или [[id:123]]
+var wikiLink = regexp.MustCompile(`\[\[([^\[\]\n]+)\]\]`)
+
+// wikiLinkEscaper экранирует разметку внутри вики-ссылки, не трогая саму ссылку
+var wikiLinkEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `_`, `\_`, "`", "\\`", `~`, `\~`, `<`, `\<`)
+
+// WikiLink - ссылка на заметку из текста. Ровно одно из полей Title и ID заполнено
+type WikiLink struct {
+	Title string
+	ID    int64
+}
+
+// Ref возвращает текст ссылки без скобок в том виде, в каком его нужно записать в заметку
+func (l WikiLink) Ref() string {
+	if l.ID != 0 {
+		return "id:" + strconv.FormatInt(l.ID, 10)
+	}
+	return l.Title
+}
+
+// ParseWikiLinks находит вики-ссылки в Markdown. Повторяющиеся ссылки (заголовки без учёта регистра)
+// возвращаются один раз
+func ParseWikiLinks(markdown string) []WikiLink {
+	var links []WikiLink
+	seen := make(map[string]bool)
+	for _, loc := range wikiLink.FindAllStringSubmatchIndex(markdown, -1) {
+		if strings.HasPrefix(markdown[loc[1]:], "(") {
+			continue
+		}
+		link, ok := parseWikiRef(markdown[loc[2]:loc[3]])
+		key := strings.ToLower(link.Ref())
+		if !ok || seen[key] {
+			continue
+		}
+		seen[key] = true
+		links = append(links, link)
+	}
+	return links
+}
+
+func parseWikiRef(ref string) (WikiLink, bool) {
+	ref = strings.TrimSpace(unescapeMarkdown(ref))
+	if rest, ok := strings.CutPrefix(ref, "id:"); ok {
+		id, err := strconv.ParseInt(strings.TrimSpace(rest), 10, 64)
+		if err != nil || id <= 0 {
+			return WikiLink{}, false
+		}
+		return WikiLink{ID: id}, true
+	}
+	if ref == "" {
+		return WikiLink{}, false
+	}
+	return WikiLink{Title: ref}, true
+}
+
+// RenameWikiLinks заменяет в Markdown ссылки [[oldTitle]] на [[newTitle]]. Заголовки сравниваются без учёта регистра
+func RenameWikiLinks(markdown, oldTitle, newTitle string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range wikiLink.FindAllStringSubmatchIndex(markdown, -1) {
+		if strings.HasPrefix(markdown[loc[1]:], "(") {
+			continue
+		}
+		link, ok := parseWikiRef(markdown[loc[2]:loc[3]])
+		if !ok || link.ID != 0 || !strings.EqualFold(link.Title, oldTitle) {
+			continue
+		}
+		b.WriteString(markdown[last:loc[0]])
+		b.WriteString("[[" + wikiLinkEscaper.Replace(newTitle) + "]]")
+		last = loc[1]
+	}
+	b.WriteString(markdown[last:])
+	return b.String()
+}
+
+// escapeMarkdownText экранирует текст для Markdown, оставляя вики-ссылки читаемыми
+func escapeMarkdownText(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range wikiLink.FindAllStringIndex(text, -1) {
+		// [[a]](b) - это уже не вики-ссылка, а ссылка Markdown, поэтому экранируется целиком
+		if strings.HasPrefix(text[loc[1]:], "(") {
+			continue
+		}
+		b.WriteString(markdownEscaper.Replace(text[last:loc[0]]))
+		b.WriteString("[[" + wikiLinkEscaper.Replace(text[loc[0]+2:loc[1]-2]) + "]]")
+		last = loc[1]
+	}
+	b.WriteString(markdownEscaper.Replace(text[last:]))
+	return b.String()
+}
+
+// unescapeMarkdown убирает экранирование знаков пунктуации
+func unescapeMarkdown(text string) string {
+	if !strings.Contains(text, `\`) {
+		return text
+	}
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' && i+1 < len(text) && isASCIIPunct(text[i+1]) {
+			i++
+		}
+		b.WriteByte(text[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"log"
+	"notes-api/internal/repository"
+	"time"
+)
+
+// Сколько аккаунтов удаляется за один проход
+const accountPurgeBatchSize = 20
+
+// AccountPurger окончательно удаляет аккаунты, у которых истёк срок ожидания удаления.
+// Заметки и всё их содержимое удаляются каскадом, файлы вложений и картинок - из хранилища
+type AccountPurger struct {
+	userRepo    *repository.UserRepository
+	attachments AttachmentService
+	images      ImageService
+	interval    time.Duration
+}
+
+func NewAccountPurger(userRepo *repository.UserRepository, attachments AttachmentService, images ImageService, interval time.Duration) *AccountPurger {
+	return &AccountPurger{userRepo: userRepo, attachments: attachments, images: images, interval: interval}
+}
+
+// Start запускает удаление в отдельной горутине до отмены ctx
+func (p *AccountPurger) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			p.RunOnce(time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// RunOnce удаляет аккаунты, срок удаления которых наступил к моменту now
+func (p *AccountPurger) RunOnce(now time.Time) {
+	ids, err := p.userRepo.GetDueDeletion(now, accountPurgeBatchSize)
+	if err != nil {
+		log.Printf("Ошибка получения аккаунтов для удаления: %v", err)
+		return
+	}
+
+	for _, id := range ids {
+		if err := p.purge(id, now); err != nil {
+			log.Printf("Не удалось удалить аккаунт %d: %v", id, err)
+		}
+	}
+}
+
+func (p *AccountPurger) purge(userID int64, now time.Time) error {
+	// Ключи собираются до удаления строк: после каскада их уже не найти
+	keys, err := p.attachments.UserBlobKeys(userID)
+	if err != nil {
+		return err
+	}
+	imageKeys, err := p.images.UserBlobKeys(userID)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := p.userRepo.DeleteIfDue(userID, now)
+	if err != nil || !deleted {
+		return err
+	}
+
+	p.attachments.DeleteBlobs(append(keys, imageKeys...))
+	log.Printf("Аккаунт %d удалён", userID)
+	return nil
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"notes-api/internal/model"
+	"notes-api/internal/repository"
+	"strings"
+	"time"
+)
+
+// Сколько пользователей возвращается в списке по умолчанию и максимум
+const (
+	defaultAdminUserLimit = 50
+	maxAdminUserLimit     = 500
+)
+
+var (
+	ErrUserNotFound = errors.New("пользователь не найден")
+	ErrInvalidRole  = errors.New("неизвестная роль")
+	// ErrCannotModifySelf - администратор не может заблокировать себя или снять с себя роль,
+	// иначе сервис может остаться без администраторов
+	ErrCannotModifySelf = errors.New("нельзя заблокировать себя или изменить свою роль")
+)
+
+type AdminService interface {
+	ListUsers(search string, limit, offset int) ([]*model.AdminUser, error)
+	GetUser(id int64) (*model.AdminUser, error)
+	SetDisabled(adminID, userID int64, disabled bool) (*model.AdminUser, error)
+	SetRole(adminID, userID int64, role model.Role) (*model.AdminUser, error)
+	ResetPassword(adminID, userID int64) (*model.AdminPasswordResetResponse, error)
+	ForceLogout(adminID, userID int64) error
+	Stats() (*model.UsageStats, error)
+	PromoteAdmins(usernames []string) error
+}
+
+type adminService struct {
+	userRepo  *repository.UserRepository
+	statsRepo repository.StatsRepository
+	auth      *AuthService
+}
+
+func NewAdminService(userRepo *repository.UserRepository, statsRepo repository.StatsRepository, auth *AuthService) AdminService {
+	return &adminService{userRepo: userRepo, statsRepo: statsRepo, auth: auth}
+}
+
+func (s *adminService) ListUsers(search string, limit, offset int) ([]*model.AdminUser, error) {
+	if limit <= 0 {
+		limit = defaultAdminUserLimit
+	}
+	if limit > maxAdminUserLimit {
+		limit = maxAdminUserLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return s.userRepo.List(strings.TrimSpace(search), limit, offset)
+}
+
+func (s *adminService) GetUser(id int64) (*model.AdminUser, error) {
+	user, err := s.userRepo.GetAdminUser(id)
+	if err != nil {
+		return nil, mapUserNotFound(err)
+	}
+	return user, nil
+}
+
+// SetDisabled блокирует или разблокирует пользователя. Заблокированный пользователь не может
+// войти, его JWT и API-токены перестают действовать
+func (s *adminService) SetDisabled(adminID, userID int64, disabled bool) (*model.AdminUser, error) {
+	if adminID == userID {
+		return nil, ErrCannotModifySelf
+	}
+	if err := s.userRepo.SetDisabled(userID, disabled, time.Now()); err != nil {
+		return nil, mapUserNotFound(err)
+	}
+	if disabled {
+		log.Printf("Администратор %d заблокировал пользователя %d", adminID, userID)
+	} else {
+		log.Printf("Администратор %d разблокировал пользователя %d", adminID, userID)
+	}
+	return s.GetUser(userID)
+}
+
+// SetRole меняет роль пользователя. Выданные ему токены отзываются, так как содержат прежнюю роль
+func (s *adminService) SetRole(adminID, userID int64, role model.Role) (*model.AdminUser, error) {
+	if !role.IsValid() {
+		return nil, ErrInvalidRole
+	}
+	if adminID == userID {
+		return nil, ErrCannotModifySelf
+	}
+	if err := s.userRepo.SetRole(userID, role); err != nil {
+		return nil, mapUserNotFound(err)
+	}
+	log.Printf("Администратор %d назначил пользователю %d роль %s", adminID, userID, role)
+	return s.GetUser(userID)
+}
+
+func (s *adminService) ResetPassword(adminID, userID int64) (*model.AdminPasswordResetResponse, error) {
+	resp, err := s.auth.AdminResetPassword(adminID, userID)
+	if err != nil {
+		return nil, mapUserNotFound(err)
+	}
+	return resp, nil
+}
+
+// ForceLogout завершает все сеансы пользователя. API-токены продолжают действовать
+func (s *adminService) ForceLogout(adminID, userID int64) error {
+	if err := s.userRepo.RevokeTokens(userID); err != nil {
+		return mapUserNotFound(err)
+	}
+	log.Printf("Администратор %d завершил сеансы пользователя %d", adminID, userID)
+	return nil
+}
+
+func (s *adminService) Stats() (*model.UsageStats, error) {
+	return s.statsRepo.GetUsageStats(time.Now())
+}
+
+// PromoteAdmins назначает администраторами пользователей из настроек. Несуществующие имена
+// пропускаются: иначе любой, кто первым зарегистрирует такое имя, стал бы администратором
+func (s *adminService) PromoteAdmins(usernames []string) error {
+	if len(usernames) == 0 {
+		return nil
+	}
+	n, err := s.userRepo.PromoteAdmins(usernames)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("Назначено администраторов из ADMIN_USERNAMES: %d", n)
+	}
+	return nil
+}
+
+func mapUserNotFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUserNotFound
+	}
+	return err
+}
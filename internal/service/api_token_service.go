@@ -0,0 +1,112 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"notes-api/internal/model"
+	"notes-api/internal/repository"
+	"notes-api/internal/util"
+	"strings"
+	"time"
+)
+
+// APITokenPrefix отличает API-токены от JWT в заголовке Authorization
+const APITokenPrefix = "nat_"
+
+// Сколько токенов может создать один пользователь
+const maxAPITokensPerUser = 50
+
+type APITokenService interface {
+	Create(userID int64, req *model.CreateAPITokenRequest) (*model.CreateAPITokenResponse, error)
+	List(userID int64) ([]*model.APIToken, error)
+	Revoke(id, userID int64) error
+	Authenticate(token string) (*model.APIToken, error)
+}
+
+type apiTokenService struct {
+	repo repository.APITokenRepository
+}
+
+func NewAPITokenService(repo repository.APITokenRepository) APITokenService {
+	return &apiTokenService{repo: repo}
+}
+
+// Create выпускает токен. Значение токена возвращается один раз, в БД сохраняется только хеш
+func (s *apiTokenService) Create(userID int64, req *model.CreateAPITokenRequest) (*model.CreateAPITokenResponse, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, errors.New("название токена не может быть пустым")
+	}
+	scopes, err := normalizeScopes(req.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, errors.New("срок действия токена должен быть в будущем")
+	}
+
+	existing, err := s.repo.GetAll(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) >= maxAPITokensPerUser {
+		return nil, fmt.Errorf("нельзя создать больше %d токенов", maxAPITokensPerUser)
+	}
+
+	secret, hash, err := util.NewSecretToken()
+	if err != nil {
+		return nil, err
+	}
+	t := &model.APIToken{UserID: userID, Name: name, Scopes: scopes, ExpiresAt: req.ExpiresAt}
+	if err := s.repo.Create(t, hash); err != nil {
+		return nil, err
+	}
+	return &model.CreateAPITokenResponse{APIToken: t, Token: APITokenPrefix + secret}, nil
+}
+
+func (s *apiTokenService) List(userID int64) ([]*model.APIToken, error) {
+	return s.repo.GetAll(userID)
+}
+
+func (s *apiTokenService) Revoke(id, userID int64) error {
+	return s.repo.Delete(id, userID)
+}
+
+// Authenticate находит действующий токен и отмечает его использование
+func (s *apiTokenService) Authenticate(token string) (*model.APIToken, error) {
+	secret, ok := strings.CutPrefix(token, APITokenPrefix)
+	if !ok {
+		return nil, errors.New("неверный формат API-токена")
+	}
+
+	now := time.Now()
+	t, err := s.repo.GetActiveByHash(util.HashToken(secret), now)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.TouchLastUsed(t.ID, now); err != nil {
+		// Ошибка учёта не должна мешать запросу
+		log.Printf("Не удалось обновить время использования токена %d: %v", t.ID, err)
+	}
+	return t, nil
+}
+
+// normalizeScopes проверяет области действия и убирает повторы
+func normalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, errors.New("укажите хотя бы одну область действия: read, notes:write, tables:write")
+	}
+	result := make([]string, 0, len(scopes))
+	seen := make(map[string]bool)
+	for _, scope := range scopes {
+		if !model.IsValidScope(scope) {
+			return nil, fmt.Errorf("неизвестная область действия: %s", scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			result = append(result, scope)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,245 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"notes-api/internal/config"
+	"notes-api/internal/model"
+	"notes-api/internal/repository"
+	"notes-api/internal/storage"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	// ErrAttachmentTooLarge - файл больше допустимого размера
+	ErrAttachmentTooLarge = errors.New("файл слишком большой")
+	// ErrStorageQuotaExceeded - у пользователя закончилось место для вложений и картинок
+	ErrStorageQuotaExceeded = errors.New("превышена квота на хранение файлов")
+	// ErrNoteNotFound - заметки с таким ID нет
+	ErrNoteNotFound = errors.New("заметка не найдена")
+	// ErrNoteAccessDenied - заметка принадлежит другому пользователю
+	ErrNoteAccessDenied = errors.New("у вас нет доступа к этой заметке")
+)
+
+type AttachmentService interface {
+	Upload(noteID, userID int64, filename string, size int64, r io.ReadSeeker) (*model.Attachment, error)
+	List(noteID, userID int64) ([]*model.Attachment, error)
+	Open(noteID, attachmentID, userID int64) (*model.Attachment, io.ReadSeekCloser, error)
+	Delete(noteID, attachmentID, userID int64) error
+	NoteBlobKeys(noteID int64) ([]string, error)
+	UserBlobKeys(userID int64) ([]string, error)
+	DeleteBlobs(keys []string)
+}
+
+type attachmentService struct {
+	repo     repository.AttachmentRepository
+	noteRepo repository.NoteRepository
+	store    storage.BlobStore
+	limits   config.AttachmentConfig
+}
+
+func NewAttachmentService(repo repository.AttachmentRepository, noteRepo repository.NoteRepository, store storage.BlobStore, limits config.AttachmentConfig) AttachmentService {
+	return &attachmentService{repo: repo, noteRepo: noteRepo, store: store, limits: limits}
+}
+
+// checkNoteOwnership возвращает ErrNoteNotFound, если заметки нет, и ErrNoteAccessDenied,
+// если она принадлежит другому пользователю
+func (s *attachmentService) checkNoteOwnership(noteID, userID int64) error {
+	ownerID, found, err := s.noteRepo.GetOwnerID(noteID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrNoteNotFound
+	}
+	if ownerID != userID {
+		return ErrNoteAccessDenied
+	}
+	return nil
+}
+
+// Upload сохраняет файл. Тип содержимого определяется по первым байтам файла, а не по заголовкам клиента.
+// Метаданные записываются в той же транзакции, что и проверка квоты, и фиксируются только после
+// успешной записи содержимого в хранилище.
+func (s *attachmentService) Upload(noteID, userID int64, filename string, size int64, r io.ReadSeeker) (*model.Attachment, error) {
+	if err := s.checkNoteOwnership(noteID, userID); err != nil {
+		return nil, err
+	}
+	if size > s.limits.MaxSize {
+		return nil, fmt.Errorf("%w: максимум %d байт", ErrAttachmentTooLarge, s.limits.MaxSize)
+	}
+
+	filename = sanitizeFilename(filename)
+	contentType, err := sniffContentType(filename, r)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.repo.BeginTx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	used, err := s.repo.LockUsageTx(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if used+size > s.limits.UserQuota {
+		return nil, fmt.Errorf("%w: занято %d из %d байт", ErrStorageQuotaExceeded, used, s.limits.UserQuota)
+	}
+
+	key, err := newStorageKey("attachments", userID)
+	if err != nil {
+		return nil, err
+	}
+	attachment := &model.Attachment{
+		NoteID:      noteID,
+		UserID:      userID,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        size,
+		StorageKey:  key,
+	}
+	if err := s.repo.CreateTx(tx, attachment); err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	if err := s.store.Put(ctx, key, r, size, contentType); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		s.DeleteBlobs([]string{key})
+		return nil, err
+	}
+	return attachment, nil
+}
+
+func (s *attachmentService) List(noteID, userID int64) ([]*model.Attachment, error) {
+	if err := s.checkNoteOwnership(noteID, userID); err != nil {
+		return nil, err
+	}
+	return s.repo.GetByNoteID(noteID)
+}
+
+// Open возвращает метаданные и содержимое вложения. Содержимое нужно закрыть
+func (s *attachmentService) Open(noteID, attachmentID, userID int64) (*model.Attachment, io.ReadSeekCloser, error) {
+	if err := s.checkNoteOwnership(noteID, userID); err != nil {
+		return nil, nil, err
+	}
+	attachment, err := s.repo.GetByID(attachmentID, noteID)
+	if err != nil {
+		return nil, nil, err
+	}
+	blob, err := s.store.Open(context.Background(), attachment.StorageKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return attachment, blob, nil
+}
+
+// Delete удаляет метаданные, а затем содержимое. Если содержимое удалить не удалось,
+// файл остаётся в хранилище, но пользователю уже не виден и в квоту не входит
+func (s *attachmentService) Delete(noteID, attachmentID, userID int64) error {
+	if err := s.checkNoteOwnership(noteID, userID); err != nil {
+		return err
+	}
+	attachment, err := s.repo.GetByID(attachmentID, noteID)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.Delete(attachment.ID); err != nil {
+		return err
+	}
+	s.DeleteBlobs([]string{attachment.StorageKey})
+	return nil
+}
+
+// NoteBlobKeys возвращает ключи содержимого вложений заметки, чтобы удалить их вместе с ней
+func (s *attachmentService) NoteBlobKeys(noteID int64) ([]string, error) {
+	return s.repo.GetStorageKeysByNoteID(noteID)
+}
+
+// UserBlobKeys возвращает ключи содержимого всех вложений пользователя для удаления аккаунта
+func (s *attachmentService) UserBlobKeys(userID int64) ([]string, error) {
+	return s.repo.GetStorageKeysByUserID(userID)
+}
+
+// DeleteBlobs удаляет содержимое из хранилища. Ошибки только логируются:
+// метаданные к этому моменту уже удалены
+func (s *attachmentService) DeleteBlobs(keys []string) {
+	for _, key := range keys {
+		if err := s.store.Delete(context.Background(), key); err != nil {
+			log.Printf("Не удалось удалить файл %s из хранилища: %v", key, err)
+		}
+	}
+}
+
+// newStorageKey генерирует случайный ключ вида prefix/userID/hex. Имя файла в ключ не попадает
+func newStorageKey(prefix string, userID int64) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%d/%s", prefix, userID, hex.EncodeToString(b)), nil
+}
+
+// sniffContentType определяет тип по содержимому. Расширение файла используется только
+// для уточнения общих типов, и только если это не активное содержимое (HTML, SVG, скрипты)
+func sniffContentType(filename string, r io.ReadSeeker) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	detected := http.DetectContentType(head[:n])
+	switch detected {
+	case "application/octet-stream", "application/zip", "text/plain; charset=utf-8":
+		byExt := mime.TypeByExtension(strings.ToLower(filepath.Ext(filename)))
+		if byExt != "" && !isActiveContentType(byExt) {
+			return byExt, nil
+		}
+	}
+	return detected, nil
+}
+
+func isActiveContentType(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "text/html" || mediaType == "image/svg+xml" || strings.Contains(mediaType, "xml") ||
+		strings.Contains(mediaType, "javascript")
+}
+
+// sanitizeFilename оставляет только имя файла без пути и управляющих символов
+func sanitizeFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == "/" {
+		name = "file"
+	}
+	for len(name) > 255 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
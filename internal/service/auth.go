@@ -1,52 +1,696 @@
 package service
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
+	"log"
+	"notes-api/internal/config"
 	"notes-api/internal/model"
+	"notes-api/internal/notify"
 	"notes-api/internal/repository"
 	"notes-api/internal/util"
+	"strings"
+	"time"
 )
 
+var (
+	ErrInvalidCredentials = errors.New("неверные учетные данные")
+	ErrInvalidPassword    = errors.New("неверный пароль")
+	// ErrTokenRevoked - токен выдан до смены пароля или удаления аккаунта
+	ErrTokenRevoked = errors.New("токен отозван")
+	// ErrAccountDisabled - аккаунт заблокирован администратором
+	ErrAccountDisabled = errors.New("аккаунт заблокирован")
+	// ErrReauthenticationRequired - у аккаунта нет пароля: операцию нужно подтвердить кодом 2FA
+	// или повторным входом через провайдера (POST /auth/oidc/{provider}/reauth)
+	ErrReauthenticationRequired = errors.New("подтвердите операцию кодом 2FA или повторным входом через провайдера")
+
+	ErrInvalidTOTPCode    = errors.New("неверный код подтверждения")
+	ErrTOTPAlreadyEnabled = errors.New("двухфакторная аутентификация уже включена")
+	ErrTOTPNotEnabled     = errors.New("двухфакторная аутентификация не включена")
+	ErrTOTPNotConfigured  = errors.New("сначала получите секрет через /auth/2fa/setup")
+)
+
+// Сколько кодов восстановления выдаётся при включении 2FA
+const recoveryCodeCount = 10
+
+// Длиннее User-Agent в сеансе не сохраняется
+const maxUserAgentLength = 512
+
+// ClientInfo - откуда выполняется вход: адрес клиента и его User-Agent. Сохраняется в сеансе
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
 type AuthService struct {
-	userRepo *repository.UserRepository
+	userRepo  *repository.UserRepository
+	apiTokens APITokenService
+	sessions  repository.SessionRepository
+	jwt       *util.JWTManager
+	mailer    notify.Mailer
+	throttle  *LoginThrottle
+	passwords *PasswordPolicy
+	cfg       config.AuthConfig
+	// dummyHash проверяется вместо пароля несуществующего пользователя, чтобы
+	// по времени ответа нельзя было узнать, есть ли такой аккаунт
+	dummyHash string
+}
+
+func NewAuthService(userRepo *repository.UserRepository, apiTokens APITokenService, sessions repository.SessionRepository, jwt *util.JWTManager, mailer notify.Mailer, cfg config.AuthConfig) (*AuthService, error) {
+	passwords, err := NewPasswordPolicy(cfg.PasswordPolicy)
+	if err != nil {
+		return nil, err
+	}
+	dummy, _, err := util.NewSecretToken()
+	if err != nil {
+		return nil, err
+	}
+	dummyHash, err := util.HashPassword(dummy)
+	if err != nil {
+		return nil, err
+	}
+	return &AuthService{
+		userRepo:  userRepo,
+		apiTokens: apiTokens,
+		sessions:  sessions,
+		jwt:       jwt,
+		mailer:    mailer,
+		throttle:  NewLoginThrottle(cfg.LoginThrottle),
+		passwords: passwords,
+		cfg:       cfg,
+		dummyHash: dummyHash,
+	}, nil
+}
+
+// JWKS возвращает открытые ключи, которыми другие сервисы могут проверять токены
+func (s *AuthService) JWKS() util.JWKS {
+	return s.jwt.JWKS()
 }
 
-func NewAuthService(userRepo *repository.UserRepository) *AuthService {
-	return &AuthService{userRepo: userRepo}
+// Identity - кто выполняет запрос: пользователь по JWT или скрипт по API-токену
+type Identity struct {
+	UserID int64
+	// SessionID - сеанс, к которому привязан JWT. Для API-токенов 0
+	SessionID int64
+	// Role берётся из JWT. У API-токенов роли нет: административные операции по ним недоступны
+	Role model.Role
+	// APIToken заполнен, если запрос авторизован API-токеном, а не входом пользователя
+	APIToken *model.APIToken
 }
 
+// HasScope проверяет область действия. Вход по JWT даёт полный доступ
+func (i *Identity) HasScope(scope string) bool {
+	return i.APIToken == nil || i.APIToken.HasScope(scope)
+}
+
+// Register создаёт пользователя после проверки имени, email и пароля.
+// Ошибки полей возвращаются как *ValidationError, занятое имя или email - как
+// repository.ErrUsernameTaken/ErrEmailTaken
 func (s *AuthService) Register(user *model.User) error {
-	// Логируем перед хешированием
-	fmt.Printf("Registering user: %s with password: %s\n", user.Username, user.Password)
+	user.Username = strings.TrimSpace(user.Username)
+	user.Email = strings.TrimSpace(user.Email)
+
+	errs := validationErrors{}
+	errs.add("username", validateUsername(user.Username))
+	if user.Email != "" {
+		errs.add("email", validateEmail(user.Email))
+	}
+	errs.add("password", s.passwords.Check(user.Password, user.Username))
+	if err := errs.err(); err != nil {
+		return err
+	}
 
 	hashed, err := util.HashPassword(user.Password)
 	if err != nil {
-		fmt.Printf("Hashing error: %v\n", err)
 		return err
 	}
-
 	user.Password = hashed
-	fmt.Printf("Storing hash: %s\n", user.Password)
 
-	return s.userRepo.Create(user)
+	if err := s.userRepo.Create(user); err != nil {
+		return err
+	}
+	log.Printf("Зарегистрирован пользователь %d (%s)", user.ID, user.Username)
+	return nil
 }
 
-func (s *AuthService) Login(req *model.LoginRequest) (string, error) {
+// Login проверяет пароль и возвращает JWT. Если включена двухфакторная аутентификация,
+// возвращается challenge-токен: JWT выдаёт LoginTwoFactor после проверки кода.
+// Неудачные попытки ограничиваются по имени пользователя и по ip (см. LoginThrottle)
+func (s *AuthService) Login(req *model.LoginRequest, client ClientInfo) (*model.LoginResponse, error) {
+	ip := client.IP
+	if err := s.throttle.Attempt(req.Username, ip, time.Now()); err != nil {
+		return nil, err
+	}
+
 	user, err := s.userRepo.GetByUsername(req.Username)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	// Хеш проверяется всегда, даже если пользователя нет или он входит только через провайдера
+	hash := s.dummyHash
+	if user != nil && user.Password != "" {
+		hash = user.Password
+	}
+	if !util.CheckPasswordHash(req.Password, hash) || hash == s.dummyHash {
+		log.Printf("Неудачная попытка входа: пользователь %q, IP %s", req.Username, ip)
+		return nil, ErrInvalidCredentials
+	}
+
+	if user.TOTPEnabled {
+		// Счётчик пользователя сбросится только после кода 2FA, иначе повторный
+		// вход по паролю позволял бы перебирать коды без ограничений
+		s.throttle.Succeeded("", ip)
+	} else {
+		s.throttle.Succeeded(user.Username, ip)
+	}
+	return s.IssueLogin(user, client)
+}
+
+// IssueLogin завершает первый шаг входа (по паролю или через внешнего провайдера):
+// выдаёт JWT нового сеанса или, если включена 2FA, challenge-токен
+func (s *AuthService) IssueLogin(user *model.User, client ClientInfo) (*model.LoginResponse, error) {
+	if user.DisabledAt != nil {
+		log.Printf("Вход в заблокированный аккаунт %d отклонён", user.ID)
+		return nil, ErrAccountDisabled
+	}
+	if user.TOTPEnabled {
+		challenge, err := s.jwt.GenerateChallengeToken(user.ID, user.TokenVersion)
+		if err != nil {
+			return nil, err
+		}
+		return &model.LoginResponse{TwoFactor: true, ChallengeToken: challenge}, nil
+	}
+
+	token, err := s.completeLogin(user, client)
+	if err != nil {
+		return nil, err
+	}
+	return &model.LoginResponse{Token: token}, nil
+}
+
+// LoginTwoFactor обменивает challenge-токен и код из приложения (или код восстановления) на JWT.
+// Неверные коды учитываются вместе с неверными паролями того же пользователя
+func (s *AuthService) LoginTwoFactor(req *model.TwoFactorLoginRequest, client ClientInfo) (string, error) {
+	claims, err := s.jwt.ParseChallengeToken(req.ChallengeToken)
+	if err != nil {
+		return "", err
+	}
+
+	user, err := s.userRepo.GetByID(claims.UserID)
+	if err != nil {
+		return "", err
+	}
+	// После смены пароля или отключения 2FA старый challenge-токен недействителен
+	if user.TokenVersion != claims.TokenVersion || !user.TOTPEnabled {
+		return "", ErrTokenRevoked
+	}
+
+	if err := s.throttle.Attempt(user.Username, client.IP, time.Now()); err != nil {
+		return "", err
+	}
+	if err := s.verifySecondFactor(user, req.Code); err != nil {
+		log.Printf("Неверный код 2FA при входе: пользователь %q, IP %s", user.Username, client.IP)
+		return "", err
+	}
+	s.throttle.Succeeded(user.Username, client.IP)
+	return s.completeLogin(user, client)
+}
+
+// completeLogin выдаёт JWT после успешной проверки всех факторов
+func (s *AuthService) completeLogin(user *model.User, client ClientInfo) (string, error) {
+	// Вход в течение срока ожидания отменяет удаление аккаунта
+	if user.DeleteAfter != nil {
+		if err := s.userRepo.CancelDeletion(user.ID); err != nil {
+			return "", err
+		}
+		log.Printf("Удаление аккаунта %d отменено входом пользователя", user.ID)
+	}
+
+	return s.startSession(user, client)
+}
+
+// startSession выдаёт JWT и сохраняет сеанс, к которому он привязан
+func (s *AuthService) startSession(user *model.User, client ClientInfo) (string, error) {
+	signed, err := s.jwt.GenerateJWT(user.ID, user.TokenVersion, string(user.Role))
+	if err != nil {
+		return "", err
+	}
+
+	userAgent := client.UserAgent
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = strings.ToValidUTF8(userAgent[:maxUserAgentLength], "")
+	}
+	session := &model.Session{
+		UserID:    user.ID,
+		UserAgent: userAgent,
+		IP:        client.IP,
+		CreatedAt: time.Now(),
+		ExpiresAt: signed.ExpiresAt,
+	}
+	if err := s.sessions.Create(session, signed.ID, user.TokenVersion); err != nil {
+		return "", err
+	}
+	return signed.Token, nil
+}
+
+// Authenticate проверяет заголовок Authorization с JWT или API-токеном. JWT старой версии
+// (после смены пароля или роли), JWT завершённых сеансов и токены заблокированных аккаунтов
+// и аккаунтов, ожидающих удаления, не принимаются
+func (s *AuthService) Authenticate(authHeader string) (*Identity, error) {
+	parts := strings.SplitN(authHeader, " ", 2)
+	if len(parts) == 2 && strings.EqualFold(parts[0], "bearer") && strings.HasPrefix(parts[1], APITokenPrefix) {
+		token, err := s.apiTokens.Authenticate(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		return &Identity{UserID: token.UserID, APIToken: token}, nil
+	}
+
+	claims, err := s.jwt.ParseJWT(authHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetByID(claims.UserID)
+	if err != nil {
+		return nil, ErrTokenRevoked
+	}
+	if user.TokenVersion != claims.TokenVersion || user.DeleteAfter != nil || user.DisabledAt != nil {
+		return nil, ErrTokenRevoked
+	}
+
+	now := time.Now()
+	session, err := s.sessions.GetActiveByTokenID(claims.ID, now)
+	if err != nil || session.UserID != user.ID {
+		return nil, ErrTokenRevoked
+	}
+	if err := s.sessions.TouchLastSeen(session.ID, now); err != nil {
+		log.Printf("Не удалось обновить время активности сеанса %d: %v", session.ID, err)
+	}
+	return &Identity{UserID: user.ID, SessionID: session.ID, Role: model.Role(claims.Role)}, nil
+}
+
+// ChangePassword меняет пароль после проверки текущего. Все выданные ранее токены
+// перестают действовать и все сеансы завершаются, поэтому для текущего клиента начинается новый сеанс
+func (s *AuthService) ChangePassword(userID, sessionID int64, req *model.ChangePasswordRequest, client ClientInfo) (string, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return "", err
+	}
+	if err := s.ConfirmIdentity(user, sessionID, req.CurrentPassword, req.Code, client); err != nil {
+		return "", err
+	}
+	errs := validationErrors{}
+	errs.add("new_password", s.passwords.Check(req.NewPassword, user.Username))
+	if err := errs.err(); err != nil {
+		return "", err
+	}
+
+	hashed, err := util.HashPassword(req.NewPassword)
+	if err != nil {
+		return "", err
+	}
+	version, err := s.userRepo.UpdatePassword(userID, hashed)
+	if err != nil {
+		return "", err
+	}
+	user.TokenVersion = version
+	return s.startSession(user, client)
+}
+
+// ConfirmIdentity подтверждает операцию с аккаунтом паролем; неверные пароли учитываются в LoginThrottle,
+// как при входе. У пользователей, созданных при входе через OpenID Connect, пароля нет: действующего JWT
+// мало, поэтому нужен код 2FA или повторный вход через провайдера в этом сеансе не раньше ReauthMaxAge назад
+func (s *AuthService) ConfirmIdentity(user *model.User, sessionID int64, password, code string, client ClientInfo) error {
+	if user.Password != "" {
+		return s.confirmAttempt(user, client, func() error {
+			if !util.CheckPasswordHash(password, user.Password) {
+				return ErrInvalidPassword
+			}
+			return nil
+		})
+	}
+	if user.TOTPEnabled && code != "" {
+		return s.confirmAttempt(user, client, func() error {
+			return s.verifySecondFactor(user, code)
+		})
+	}
+	if sessionID == 0 {
+		return ErrReauthenticationRequired
+	}
+	ok, err := s.sessions.IsReauthenticated(sessionID, user.ID, time.Now().Add(-s.cfg.ReauthMaxAge))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrReauthenticationRequired
+	}
+	return nil
+}
+
+// confirmAttempt выполняет проверку пароля или кода 2FA для операции с аккаунтом. Неудачные попытки
+// учитываются в LoginThrottle вместе с неудачными входами пользователя: иначе по украденному JWT
+// можно было бы перебирать код без ограничений
+func (s *AuthService) confirmAttempt(user *model.User, client ClientInfo, check func() error) error {
+	if err := s.throttle.Attempt(user.Username, client.IP, time.Now()); err != nil {
+		return err
+	}
+	if err := check(); err != nil {
+		log.Printf("Неудачное подтверждение операции с аккаунтом: пользователь %q, IP %s", user.Username, client.IP)
+		return err
+	}
+	s.throttle.Succeeded(user.Username, client.IP)
+	return nil
+}
+
+// markReauthenticated отмечает повторный вход через провайдера и возвращает, до какого времени
+// сеанс может подтверждать им операции с аккаунтом
+func (s *AuthService) markReauthenticated(userID, sessionID int64) (time.Time, error) {
+	now := time.Now()
+	if err := s.sessions.MarkReauthenticated(sessionID, userID, now); err != nil {
+		return time.Time{}, err
+	}
+	return now.Add(s.cfg.ReauthMaxAge), nil
+}
+
+// ForgotPassword отправляет письмо со ссылкой для сброса пароля. Если пользователь
+// не найден или у него нет email, ошибка не возвращается, чтобы по ответу нельзя было
+// проверить существование аккаунта
+func (s *AuthService) ForgotPassword(login string) error {
+	login = strings.TrimSpace(login)
+	if login == "" {
+		return nil
+	}
+
+	user, err := s.userRepo.GetByLogin(login)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.Email == "" || user.DeleteAfter != nil {
+		return nil
+	}
+
+	token, hash, err := util.NewSecretToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(s.cfg.PasswordResetTTL)
+	if err := s.userRepo.CreateResetToken(user.ID, hash, expiresAt); err != nil {
+		return err
+	}
+
+	// Письмо отправляется в фоне: время ответа не зависит от того, найден ли пользователь
+	go func() {
+		if err := s.mailer.Send(context.Background(), user.Email, "Сброс пароля", s.resetMailBody(user, token)); err != nil {
+			log.Printf("Не удалось отправить письмо для сброса пароля пользователю %d: %v", user.ID, err)
+		}
+	}()
+	return nil
+}
+
+func (s *AuthService) resetMailBody(user *model.User, token string) string {
+	return fmt.Sprintf("Здравствуйте, %s!\n\nДля сброса пароля перейдите по ссылке:\n%s\n\n"+
+		"Ссылка действует %s и может быть использована один раз.\n"+
+		"Если вы не запрашивали сброс пароля, просто проигнорируйте это письмо.",
+		user.Username, s.resetLink(token), s.cfg.PasswordResetTTL)
+}
+
+// resetLink подставляет токен в PASSWORD_RESET_URL. Если адрес не задан, возвращается сам токен
+func (s *AuthService) resetLink(token string) string {
+	if s.cfg.PasswordResetURL == "" {
+		return token
+	}
+	return strings.ReplaceAll(s.cfg.PasswordResetURL, "%s", token)
+}
+
+// ResetPassword устанавливает новый пароль по токену из письма и отзывает все выданные токены
+func (s *AuthService) ResetPassword(req *model.ResetPasswordRequest) error {
+	// Имя пользователя до проверки токена неизвестно, поэтому совпадение с ним здесь не проверяется
+	errs := validationErrors{}
+	errs.add("new_password", s.passwords.Check(req.NewPassword, ""))
+	if err := errs.err(); err != nil {
+		return err
+	}
+	hashed, err := util.HashPassword(req.NewPassword)
+	if err != nil {
+		return err
+	}
+
+	userID, err := s.userRepo.ResetPassword(util.HashToken(req.Token), hashed, time.Now())
+	if err != nil {
+		return err
+	}
+	log.Printf("Пароль пользователя %d сброшен по ссылке из письма", userID)
+	return nil
+}
+
+// Sessions возвращает действующие сеансы пользователя и отмечает текущий
+func (s *AuthService) Sessions(userID, currentSessionID int64) ([]*model.Session, error) {
+	sessions, err := s.sessions.GetActive(userID, time.Now())
 	if err != nil {
-		return "", fmt.Errorf("user not found: %v", err) // Добавим логирование
+		return nil, err
 	}
+	for _, session := range sessions {
+		session.Current = session.ID == currentSessionID
+	}
+	return sessions, nil
+}
+
+// RevokeSession завершает сеанс пользователя, в том числе текущий
+func (s *AuthService) RevokeSession(userID, sessionID int64) error {
+	if err := s.sessions.Revoke(sessionID, userID, time.Now()); err != nil {
+		return err
+	}
+	log.Printf("Пользователь %d завершил сеанс %d", userID, sessionID)
+	return nil
+}
+
+// AdminResetPassword сбрасывает пароль по решению администратора: прежний пароль перестаёт
+// действовать, все сеансы завершаются, а пользователь задаёт новый пароль по одноразовому токену.
+// Токен отправляется пользователю письмом; администратору он возвращается, только если письмо
+// отправить не удалось, иначе администратор мог бы задать пароль и войти под чужим аккаунтом
+func (s *AuthService) AdminResetPassword(adminID, userID int64) (*model.AdminPasswordResetResponse, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Случайный пароль, который никто не знает: войти можно будет только после сброса
+	unusable, _, err := util.NewSecretToken()
+	if err != nil {
+		return nil, err
+	}
+	hashed, err := util.HashPassword(unusable)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.userRepo.UpdatePassword(userID, hashed); err != nil {
+		return nil, err
+	}
+
+	token, hash, err := util.NewSecretToken()
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().Add(s.cfg.PasswordResetTTL)
+	if err := s.userRepo.CreateResetToken(userID, hash, expiresAt); err != nil {
+		return nil, err
+	}
+	log.Printf("Администратор %d сбросил пароль пользователя %d и выдал токен сброса до %s",
+		adminID, userID, expiresAt.Format(time.RFC3339))
+
+	resp := &model.AdminPasswordResetResponse{ExpiresAt: expiresAt}
+	if user.Email != "" {
+		body := fmt.Sprintf("Здравствуйте, %s!\n\nАдминистратор сбросил пароль вашего аккаунта. "+
+			"Чтобы задать новый пароль, перейдите по ссылке:\n%s\n\nСсылка действует %s и может быть использована один раз.",
+			user.Username, s.resetLink(token), s.cfg.PasswordResetTTL)
+		if err := s.mailer.Send(context.Background(), user.Email, "Сброс пароля", body); err != nil {
+			log.Printf("Не удалось отправить письмо для сброса пароля пользователю %d: %v", userID, err)
+		} else {
+			resp.EmailSent = true
+		}
+	}
+	if !resp.EmailSent {
+		log.Printf("Токен сброса пароля пользователя %d передан администратору %d", userID, adminID)
+		resp.ResetToken = token
+	}
+	return resp, nil
+}
 
-	fmt.Printf("Input password: %s\n", req.Password)
-	fmt.Printf("Stored hash: %s\n", user.Password)
+// DeleteAccount помечает аккаунт на удаление после подтверждения (см. ConfirmIdentity). Все токены
+// отзываются; до окончания срока ожидания удаление можно отменить, войдя в аккаунт
+func (s *AuthService) DeleteAccount(userID, sessionID int64, req *model.DeleteAccountRequest, client ClientInfo) (time.Time, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := s.ConfirmIdentity(user, sessionID, req.Password, req.Code, client); err != nil {
+		return time.Time{}, err
+	}
 
-	match := util.CheckPasswordHash(req.Password, user.Password)
-	fmt.Printf("Password match: %v\n", match)
+	deleteAfter := time.Now().Add(s.cfg.AccountDeletionGrace)
+	if err := s.userRepo.ScheduleDeletion(userID, deleteAfter); err != nil {
+		return time.Time{}, err
+	}
 
-	if !match {
-		return "", errors.New("invalid credentials (password mismatch)")
+	if user.Email != "" {
+		body := fmt.Sprintf("Здравствуйте, %s!\n\nВаш аккаунт и все заметки будут удалены %s.\n"+
+			"Чтобы отменить удаление, войдите в аккаунт до этого времени.",
+			user.Username, deleteAfter.Format("2006-01-02 15:04 MST"))
+		go func() {
+			if err := s.mailer.Send(context.Background(), user.Email, "Удаление аккаунта", body); err != nil {
+				log.Printf("Не удалось отправить письмо об удалении аккаунта %d: %v", userID, err)
+			}
+		}()
 	}
+	return deleteAfter, nil
+}
 
-	return util.GenerateJWT(user.ID)
+// SetupTOTP генерирует новый секрет TOTP. 2FA включается только после подтверждения кодом в ConfirmTOTP
+func (s *AuthService) SetupTOTP(userID int64) (*model.TOTPSetupResponse, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	secret, err := util.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	ok, err := s.userRepo.SetTOTPSecret(userID, secret)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	return &model.TOTPSetupResponse{
+		Secret:     secret,
+		OTPAuthURI: util.TOTPURI(s.cfg.TOTPIssuer, user.Username, secret),
+	}, nil
+}
+
+// ConfirmTOTP включает 2FA, если код из приложения совпал, и возвращает коды восстановления
+func (s *AuthService) ConfirmTOTP(userID int64, code string) ([]string, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTOTPNotConfigured
+	}
+
+	step, ok := util.ValidateTOTP(user.TOTPSecret, code, time.Now(), 0)
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.userRepo.EnableTOTP(userID, step, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP отключает 2FA после проверки пароля и кода. У аккаунта без пароля
+// операцию подтверждает сам код. Неудачные попытки ограничиваются так же, как вход
+func (s *AuthService) DisableTOTP(userID int64, req *model.DisableTOTPRequest, client ClientInfo) error {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return ErrTOTPNotEnabled
+	}
+	err = s.confirmAttempt(user, client, func() error {
+		if user.Password != "" && !util.CheckPasswordHash(req.Password, user.Password) {
+			return ErrInvalidPassword
+		}
+		return s.verifySecondFactor(user, req.Code)
+	})
+	if err != nil {
+		return err
+	}
+	return s.userRepo.DisableTOTP(userID)
+}
+
+// RegenerateRecoveryCodes заменяет коды восстановления новыми. Старые перестают действовать.
+// Неверные коды ограничиваются так же, как вход: иначе по украденному JWT можно подобрать код
+// и получить коды восстановления
+func (s *AuthService) RegenerateRecoveryCodes(userID int64, code string, client ClientInfo) ([]string, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.TOTPEnabled {
+		return nil, ErrTOTPNotEnabled
+	}
+	err = s.confirmAttempt(user, client, func() error {
+		return s.verifySecondFactor(user, code)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.userRepo.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// verifySecondFactor принимает код TOTP или неиспользованный код восстановления.
+// Каждый код принимается только один раз
+func (s *AuthService) verifySecondFactor(user *model.User, code string) error {
+	if step, ok := util.ValidateTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastStep); ok {
+		used, err := s.userRepo.UseTOTPStep(user.ID, step)
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInvalidTOTPCode
+		}
+		return nil
+	}
+
+	used, err := s.userRepo.UseRecoveryCode(user.ID, util.HashToken(util.NormalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidTOTPCode
+	}
+	log.Printf("Пользователь %d использовал код восстановления", user.ID)
+	return nil
+}
+
+// newRecoveryCodes возвращает коды для пользователя и их хеши для хранения
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := util.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = util.HashToken(util.NormalizeRecoveryCode(code))
+	}
+	return codes, hashes, nil
 }
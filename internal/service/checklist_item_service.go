@@ -4,12 +4,24 @@ import (
 	"errors"
 	"notes-api/internal/model"
 	"notes-api/internal/repository"
+	"notes-api/internal/richtext"
+	"notes-api/internal/util"
+	"time"
 )
 
 type ChecklistItemService interface {
+	List(noteID int64, userID int64) ([]*model.ChecklistItem, error)
 	Create(item *model.ChecklistItem, userID int64) error
-	Update(item *model.ChecklistItem, itemID int64, userID int64) error
+	Update(req *model.UpdateChecklistItemRequest, itemID int64, userID int64, cascade bool) error
 	Delete(itemID int64, userID int64) error
+	Reorder(req *model.ReorderChecklistRequest, noteID int64, userID int64) error
+	Indent(itemID int64, userID int64) (*model.ChecklistItem, error)
+	Outdent(itemID int64, userID int64) (*model.ChecklistItem, error)
+	Due(userID int64, within time.Duration) (*model.DueChecklistItems, error)
+	SetAllCompleted(noteID int64, userID int64, completed bool) (int64, error)
+	DeleteCompleted(noteID int64, userID int64) (int64, error)
+	MoveItems(req *model.MoveChecklistItemsRequest, noteID int64, userID int64) (int64, error)
+	SetStyle(req *model.StyleChecklistItemsRequest, noteID int64, userID int64) (int64, error)
 }
 type checklistItemService struct {
 	itemRepo repository.ChecklistItemRepository
@@ -25,21 +37,86 @@ func NewChecklistItemService(itemRepo repository.ChecklistItemRepository, noteRe
 
 // Проверка, что пользователь владеет заметкой, к которой относится чек-лист
 func (s *checklistItemService) checkNoteOwnership(noteID, userID int64) error {
-	note, err := s.noteRepo.GetByID(noteID, userID) // Используем GetByID, который уже проверяет userID
-	if err != nil || note == nil {
+	exists, err := s.noteRepo.Exists(noteID, userID)
+	if err != nil || !exists {
 		return errors.New("заметка не найдена или у вас нет к ней доступа")
 	}
 	return nil
 }
 
+// List возвращает чек-лист заметки в виде дерева: вложенные пункты находятся в Children
+func (s *checklistItemService) List(noteID, userID int64) ([]*model.ChecklistItem, error) {
+	if err := s.checkNoteOwnership(noteID, userID); err != nil {
+		return nil, err
+	}
+
+	items, err := s.itemRepo.GetByNoteID(noteID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Элементы приходят в порядке документа, поэтому родитель всегда встречается раньше детей
+	byID := make(map[int64]*model.ChecklistItem, len(items))
+	roots := make([]*model.ChecklistItem, 0)
+	for _, item := range items {
+		byID[item.ID] = item
+		if item.ParentID == nil {
+			roots = append(roots, item)
+			continue
+		}
+		if parent, ok := byID[*item.ParentID]; ok {
+			parent.Children = append(parent.Children, item)
+		}
+	}
+	return roots, nil
+}
+
+// checkAssignee проверяет, что исполнителю видна заметка.
+// Пока заметки не расшариваются, это может быть только её владелец.
+func (s *checklistItemService) checkAssignee(noteID int64, assigneeID *int64) error {
+	if assigneeID == nil {
+		return nil
+	}
+	exists, err := s.noteRepo.Exists(noteID, *assigneeID)
+	if err != nil || !exists {
+		return errors.New("исполнитель не имеет доступа к заметке")
+	}
+	return nil
+}
+
 func (s *checklistItemService) Create(item *model.ChecklistItem, userID int64) error {
 	if err := s.checkNoteOwnership(item.NoteID, userID); err != nil {
 		return err
 	}
+
+	if err := s.checkAssignee(item.NoteID, item.AssigneeID); err != nil {
+		return err
+	}
+	if err := prepareItemRecurrence(item, time.Now()); err != nil {
+		return err
+	}
+	if err := prepareItemRichText(item); err != nil {
+		return err
+	}
+
+	if item.ParentID != nil {
+		parent, err := s.itemRepo.GetByID(*item.ParentID)
+		if err != nil {
+			return err
+		}
+		if parent.NoteID != item.NoteID {
+			return errors.New("родительский пункт относится к другой заметке")
+		}
+	}
 	return s.itemRepo.Create(item)
 }
 
-func (s *checklistItemService) Update(itemData *model.ChecklistItem, itemID, userID int64) error {
+// Update обновляет пункт. Если cascade = true, статус выполнения переносится на все вложенные пункты.
+// При выполнении повторяющегося пункта создаётся его следующее повторение, к которому переходит правило.
+// Правило меняется, только если оно передано в запросе: иначе пункт, отмеченный выполненным
+// без recurrence, потерял бы правило и не повторился
+func (s *checklistItemService) Update(req *model.UpdateChecklistItemRequest, itemID, userID int64, cascade bool) error {
+	itemData := &req.ChecklistItem
 	existingItem, err := s.itemRepo.GetByID(itemID)
 	if err != nil {
 		return err
@@ -49,11 +126,141 @@ func (s *checklistItemService) Update(itemData *model.ChecklistItem, itemID, use
 	if err := s.checkNoteOwnership(existingItem.NoteID, userID); err != nil {
 		return err
 	}
+	// Старые клиенты присылают только текст и статус: не переданные поля остаются прежними
+	itemData.DueAt = req.DueAt.Or(existingItem.DueAt)
+	itemData.RemindAt = req.RemindAt.Or(existingItem.RemindAt)
+	itemData.AssigneeID = req.AssigneeID.Or(existingItem.AssigneeID)
+	if req.AssigneeID.Set {
+		if err := s.checkAssignee(existingItem.NoteID, itemData.AssigneeID); err != nil {
+			return err
+		}
+	}
+	if req.Recurrence != nil {
+		itemData.Recurrence = *req.Recurrence
+		if err := prepareItemRecurrence(itemData, time.Now()); err != nil {
+			return err
+		}
+	} else {
+		itemData.Recurrence = existingItem.Recurrence
+	}
+	if err := prepareItemRichText(itemData); err != nil {
+		return err
+	}
 
 	// 3. Обновить поля и сохранить
+	justCompleted := !existingItem.Completed && itemData.Completed
 	existingItem.Text = itemData.Text
+	existingItem.Style = itemData.Style
+	existingItem.RichText = itemData.RichText
 	existingItem.Completed = itemData.Completed
-	return s.itemRepo.Update(existingItem)
+	existingItem.DueAt = itemData.DueAt
+	existingItem.RemindAt = itemData.RemindAt
+	existingItem.AssigneeID = itemData.AssigneeID
+	existingItem.Recurrence = itemData.Recurrence
+
+	var next *model.ChecklistItem
+	if justCompleted && existingItem.Recurrence != "" {
+		next, err = nextOccurrence(existingItem, time.Now())
+		if err != nil {
+			return err
+		}
+		existingItem.Recurrence = ""
+	}
+
+	tx, err := s.itemRepo.BeginTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.itemRepo.UpdateTx(tx, existingItem); err != nil {
+		return err
+	}
+	if cascade {
+		if err := s.itemRepo.SetChildrenCompletedTx(tx, existingItem.ID, existingItem.Completed); err != nil {
+			return err
+		}
+	}
+	if next != nil {
+		if err := s.itemRepo.CreateTx(tx, next); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	*itemData = *existingItem
+	return nil
+}
+
+// prepareItemRichText согласует форматированный текст пункта с полями text и style.
+// Если клиент прислал rich_text, text и style вычисляются из него; иначе
+// форматированный текст строится из text и style (так работают старые клиенты)
+func prepareItemRichText(item *model.ChecklistItem) error {
+	if item.RichText == nil {
+		if item.Style != "" && !item.Style.IsValid() {
+			return errors.New("неизвестный стиль текста")
+		}
+		item.RichText = richtext.InlinesFromLegacy(item.Text, string(item.Style))
+		return nil
+	}
+	if err := item.RichText.Validate(); err != nil {
+		return err
+	}
+	item.Text = item.RichText.PlainText()
+	item.Style = model.TextStyle(item.RichText.LegacyStyle())
+	return nil
+}
+
+// prepareItemRecurrence проверяет правило повторения пункта и приводит его к каноническому виду
+func prepareItemRecurrence(item *model.ChecklistItem, now time.Time) error {
+	if item.Recurrence == "" {
+		return nil
+	}
+	rule, err := util.ParseRRule(item.Recurrence)
+	if err != nil {
+		return err
+	}
+	if item.DueAt != nil {
+		rule.Anchor(*item.DueAt)
+	} else {
+		rule.Anchor(now)
+	}
+	item.Recurrence = rule.String()
+	return nil
+}
+
+// nextOccurrence создаёт следующее повторение выполненного пункта.
+// Срок отсчитывается от текущего срока (или от now, если его не было) и всегда
+// оказывается в будущем; напоминание сохраняет прежний отступ от срока.
+func nextOccurrence(item *model.ChecklistItem, now time.Time) (*model.ChecklistItem, error) {
+	rule, err := util.ParseRRule(item.Recurrence)
+	if err != nil {
+		return nil, err
+	}
+
+	from := now
+	if item.DueAt != nil {
+		from = *item.DueAt
+	}
+	due := rule.NextAfter(from, now)
+
+	next := &model.ChecklistItem{
+		Text:       item.Text,
+		Style:      item.Style,
+		RichText:   item.RichText,
+		NoteID:     item.NoteID,
+		ParentID:   item.ParentID,
+		AssigneeID: item.AssigneeID,
+		Recurrence: item.Recurrence,
+		DueAt:      &due,
+	}
+	if item.RemindAt != nil && item.DueAt != nil {
+		remind := due.Add(item.RemindAt.Sub(*item.DueAt))
+		next.RemindAt = &remind
+	}
+	return next, nil
 }
 
 func (s *checklistItemService) Delete(itemID, userID int64) error {
@@ -71,3 +278,196 @@ func (s *checklistItemService) Delete(itemID, userID int64) error {
 	// 3. Удалить
 	return s.itemRepo.Delete(itemID)
 }
+
+// Due возвращает просроченные пункты и пункты со сроком в ближайшие within по всем заметкам пользователя
+func (s *checklistItemService) Due(userID int64, within time.Duration) (*model.DueChecklistItems, error) {
+	now := time.Now()
+	items, err := s.itemRepo.GetDue(userID, now.Add(within))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &model.DueChecklistItems{
+		Overdue:  make([]*model.ChecklistItem, 0),
+		Upcoming: make([]*model.ChecklistItem, 0),
+	}
+	for _, item := range items {
+		if item.DueAt.Before(now) {
+			result.Overdue = append(result.Overdue, item)
+		} else {
+			result.Upcoming = append(result.Upcoming, item)
+		}
+	}
+	return result, nil
+}
+
+func (s *checklistItemService) Reorder(req *model.ReorderChecklistRequest, noteID, userID int64) error {
+	if err := s.checkNoteOwnership(noteID, userID); err != nil {
+		return err
+	}
+	return s.itemRepo.Reorder(noteID, req.ParentID, req.ItemIDs)
+}
+
+// Indent делает пункт последним вложенным пунктом предыдущего соседа
+func (s *checklistItemService) Indent(itemID, userID int64) (*model.ChecklistItem, error) {
+	item, siblings, err := s.loadWithSiblings(itemID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var prev *model.ChecklistItem
+	for _, sibling := range siblings {
+		if sibling.ID == item.ID {
+			break
+		}
+		prev = sibling
+	}
+	if prev == nil {
+		return nil, errors.New("первый пункт уровня нельзя сдвинуть вправо")
+	}
+
+	items, err := s.itemRepo.GetByNoteID(item.NoteID)
+	if err != nil {
+		return nil, err
+	}
+	position := len(childrenOf(items, &prev.ID))
+
+	if err := s.itemRepo.Move(item.ID, &prev.ID, position); err != nil {
+		return nil, err
+	}
+	return s.itemRepo.GetByID(item.ID)
+}
+
+// Outdent переносит пункт на уровень выше, сразу после его текущего родителя
+func (s *checklistItemService) Outdent(itemID, userID int64) (*model.ChecklistItem, error) {
+	item, err := s.itemRepo.GetByID(itemID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkNoteOwnership(item.NoteID, userID); err != nil {
+		return nil, err
+	}
+	if item.ParentID == nil {
+		return nil, errors.New("пункт уже находится на верхнем уровне")
+	}
+
+	parent, err := s.itemRepo.GetByID(*item.ParentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.itemRepo.Move(item.ID, parent.ParentID, parent.Position+1); err != nil {
+		return nil, err
+	}
+	return s.itemRepo.GetByID(item.ID)
+}
+
+// loadWithSiblings возвращает пункт и все пункты его уровня по порядку
+func (s *checklistItemService) loadWithSiblings(itemID, userID int64) (*model.ChecklistItem, []*model.ChecklistItem, error) {
+	item, err := s.itemRepo.GetByID(itemID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.checkNoteOwnership(item.NoteID, userID); err != nil {
+		return nil, nil, err
+	}
+
+	items, err := s.itemRepo.GetByNoteID(item.NoteID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return item, childrenOf(items, item.ParentID), nil
+}
+
+// childrenOf выбирает из плоского списка пункты с указанным родителем, сохраняя порядок
+func childrenOf(items []*model.ChecklistItem, parentID *int64) []*model.ChecklistItem {
+	var result []*model.ChecklistItem
+	for _, item := range items {
+		switch {
+		case parentID == nil && item.ParentID == nil,
+			parentID != nil && item.ParentID != nil && *item.ParentID == *parentID:
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// SetAllCompleted отмечает все пункты заметки одной транзакцией.
+// Для выполняемых повторяющихся пунктов, как и при обычном обновлении, создаются следующие повторения.
+func (s *checklistItemService) SetAllCompleted(noteID, userID int64, completed bool) (int64, error) {
+	if err := s.checkNoteOwnership(noteID, userID); err != nil {
+		return 0, err
+	}
+
+	var recurring []*model.ChecklistItem
+	if completed {
+		items, err := s.itemRepo.GetByNoteID(noteID)
+		if err != nil {
+			return 0, err
+		}
+		for _, item := range items {
+			if !item.Completed && item.Recurrence != "" {
+				recurring = append(recurring, item)
+			}
+		}
+	}
+
+	tx, err := s.itemRepo.BeginTx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	affected, err := s.itemRepo.SetCompletedForNoteTx(tx, noteID, completed)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	for _, item := range recurring {
+		next, err := nextOccurrence(item, now)
+		if err != nil {
+			return 0, err
+		}
+		item.Completed = true
+		item.Recurrence = ""
+		if err := s.itemRepo.UpdateTx(tx, item); err != nil {
+			return 0, err
+		}
+		if err := s.itemRepo.CreateTx(tx, next); err != nil {
+			return 0, err
+		}
+	}
+
+	return affected, tx.Commit()
+}
+
+func (s *checklistItemService) DeleteCompleted(noteID, userID int64) (int64, error) {
+	if err := s.checkNoteOwnership(noteID, userID); err != nil {
+		return 0, err
+	}
+	return s.itemRepo.DeleteCompleted(noteID)
+}
+
+func (s *checklistItemService) MoveItems(req *model.MoveChecklistItemsRequest, noteID, userID int64) (int64, error) {
+	if req.TargetNoteID == noteID {
+		return 0, errors.New("пункты уже находятся в этой заметке")
+	}
+	if err := s.checkNoteOwnership(noteID, userID); err != nil {
+		return 0, err
+	}
+	if err := s.checkNoteOwnership(req.TargetNoteID, userID); err != nil {
+		return 0, err
+	}
+	return s.itemRepo.MoveToNote(noteID, req.TargetNoteID, req.ItemIDs)
+}
+
+func (s *checklistItemService) SetStyle(req *model.StyleChecklistItemsRequest, noteID, userID int64) (int64, error) {
+	if !req.Style.IsValid() {
+		return 0, errors.New("неизвестный стиль текста")
+	}
+	if err := s.checkNoteOwnership(noteID, userID); err != nil {
+		return 0, err
+	}
+	return s.itemRepo.SetStyle(noteID, req.ItemIDs, req.Style)
+}
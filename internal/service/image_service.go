@@ -0,0 +1,328 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"notes-api/internal/model"
+	"notes-api/internal/repository"
+	"notes-api/internal/storage"
+	"notes-api/internal/util"
+	"regexp"
+	"strconv"
+)
+
+// ImageURLPrefix - адрес, которым data URI заменяется в содержимом заметки
+const ImageURLPrefix = "/api/v1/images/"
+
+// ThumbnailSizes - размеры миниатюр по большей стороне
+var ThumbnailSizes = []int{128, 512, 1024}
+
+// Ограничения на встроенные картинки
+const (
+	maxImageBytes  = 10 << 20
+	maxImagePixels = 40_000_000
+)
+
+// ErrUnsupportedThumbnailSize - запрошен размер миниатюры не из ThumbnailSizes
+var ErrUnsupportedThumbnailSize = fmt.Errorf("доступные размеры миниатюр: %v", ThumbnailSizes)
+
+var dataURIImage = regexp.MustCompile(`data:image/(?:png|jpeg|jpg|gif);base64,([A-Za-z0-9+/]+={0,2})`)
+
+var imageURL = regexp.MustCompile(regexp.QuoteMeta(ImageURLPrefix) + `([0-9]+)`)
+
+type ImageService interface {
+	ExtractInline(content string, userID int64) (string, []int64, error)
+	Open(id, userID int64, size int) (*model.Image, string, io.ReadSeekCloser, error)
+	UserBlobKeys(userID int64) ([]string, error)
+	DeleteUnreferenced(userID int64, previousContent string)
+	Discard(userID int64, ids []int64)
+}
+
+type imageService struct {
+	repo  repository.ImageRepository
+	store storage.BlobStore
+	// quota - общая с вложениями квота на пользователя (USER_STORAGE_QUOTA)
+	quota int64
+}
+
+func NewImageService(repo repository.ImageRepository, store storage.BlobStore, quota int64) ImageService {
+	return &imageService{repo: repo, store: store, quota: quota}
+}
+
+// ExtractInline сохраняет картинки, вставленные в content как data URI (PNG, JPEG, GIF),
+// и заменяет их ссылками вида /api/v1/images/{id}. Возвращает ID сохранённых картинок, чтобы вызывающий
+// мог удалить их через Discard, если заметка не сохранится. При ошибке уже сохранённые картинки удаляются сразу
+func (s *imageService) ExtractInline(content string, userID int64) (string, []int64, error) {
+	var created []int64
+	var extractErr error
+	result := dataURIImage.ReplaceAllStringFunc(content, func(match string) string {
+		if extractErr != nil {
+			return match
+		}
+		data, err := base64.StdEncoding.DecodeString(dataURIImage.FindStringSubmatch(match)[1])
+		if err != nil {
+			extractErr = errors.New("некорректная картинка в base64")
+			return match
+		}
+		img, err := s.save(data, userID)
+		if err != nil {
+			extractErr = err
+			return match
+		}
+		created = append(created, img.ID)
+		return ImageURLPrefix + strconv.FormatInt(img.ID, 10)
+	})
+	if extractErr != nil {
+		s.Discard(userID, created)
+		return "", nil, extractErr
+	}
+	return result, created, nil
+}
+
+// save удаляет из картинки метаданные с координатами, сохраняет её вместе с миниатюрами
+// и записывает метаданные в БД. Размер оригинала учитывается в квоте пользователя вместе
+// с вложениями; миниатюры в квоту не входят
+func (s *imageService) save(data []byte, userID int64) (*model.Image, error) {
+	if len(data) > maxImageBytes {
+		return nil, fmt.Errorf("картинка больше %d байт", maxImageBytes)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("не удалось распознать картинку")
+	}
+	// Проверяем размер до декодирования, чтобы маленький файл не развернулся в гигабайты пикселей
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, errors.New("картинка слишком большая")
+	}
+
+	var contentType string
+	switch format {
+	case "jpeg":
+		contentType = "image/jpeg"
+		stripped, orientation, err := util.StripJPEGMetadata(data)
+		if err != nil {
+			return nil, err
+		}
+		data = stripped
+		// Без EXIF ориентация потеряется, поэтому повёрнутые снимки перекодируются
+		if orientation > 1 {
+			decoded, err := jpeg.Decode(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, util.ApplyOrientation(decoded, orientation), &jpeg.Options{Quality: 90}); err != nil {
+				return nil, err
+			}
+			data = buf.Bytes()
+		}
+	case "png":
+		contentType = "image/png"
+		if data, err = util.StripPNGMetadata(data); err != nil {
+			return nil, err
+		}
+	case "gif":
+		contentType = "image/gif"
+	default:
+		return nil, errors.New("поддерживаются только PNG, JPEG и GIF")
+	}
+
+	// Для GIF декодируется первый кадр: он же используется для миниатюр
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("не удалось декодировать картинку")
+	}
+
+	key, err := newStorageKey("images", userID)
+	if err != nil {
+		return nil, err
+	}
+	img := &model.Image{
+		UserID:      userID,
+		ContentType: contentType,
+		Width:       decoded.Bounds().Dx(),
+		Height:      decoded.Bounds().Dy(),
+		Size:        int64(len(data)),
+		StorageKey:  key,
+	}
+
+	tx, err := s.repo.BeginTx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	used, err := s.repo.LockUsageTx(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if used+img.Size > s.quota {
+		return nil, fmt.Errorf("%w: занято %d из %d байт", ErrStorageQuotaExceeded, used, s.quota)
+	}
+	if err := s.repo.CreateTx(tx, img); err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	keys := []string{key}
+	if err := s.store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), contentType); err != nil {
+		return nil, err
+	}
+	for _, size := range thumbnailSizesFor(img) {
+		thumb, thumbType, err := encodeThumbnail(decoded, size, contentType)
+		if err != nil {
+			s.deleteBlobs(keys)
+			return nil, err
+		}
+		thumbKey := thumbnailKey(key, size)
+		keys = append(keys, thumbKey)
+		if err := s.store.Put(ctx, thumbKey, bytes.NewReader(thumb), int64(len(thumb)), thumbType); err != nil {
+			s.deleteBlobs(keys)
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.deleteBlobs(keys)
+		return nil, err
+	}
+	return img, nil
+}
+
+// Open возвращает картинку или её миниатюру. size = 0 означает оригинал.
+// Если картинка не больше запрошенного размера, отдаётся оригинал.
+func (s *imageService) Open(id, userID int64, size int) (*model.Image, string, io.ReadSeekCloser, error) {
+	img, err := s.repo.GetByID(id, userID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	key, contentType := img.StorageKey, img.ContentType
+	if size != 0 {
+		if !isThumbnailSize(size) {
+			return nil, "", nil, ErrUnsupportedThumbnailSize
+		}
+		if size < max(img.Width, img.Height) {
+			key = thumbnailKey(img.StorageKey, size)
+			contentType = thumbnailContentType(img.ContentType)
+		}
+	}
+
+	blob, err := s.store.Open(context.Background(), key)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return img, contentType, blob, nil
+}
+
+// UserBlobKeys возвращает ключи всех картинок пользователя вместе с миниатюрами.
+// Ключи миниатюр, которые не создавались, хранилище при удалении пропускает
+func (s *imageService) UserBlobKeys(userID int64) ([]string, error) {
+	originals, err := s.repo.GetStorageKeysByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(originals)*(len(ThumbnailSizes)+1))
+	for _, key := range originals {
+		keys = append(keys, key)
+		for _, size := range ThumbnailSizes {
+			keys = append(keys, thumbnailKey(key, size))
+		}
+	}
+	return keys, nil
+}
+
+// DeleteUnreferenced удаляет картинки, на которые ссылалось прежнее содержимое заметки,
+// если на них больше не ссылается ни одна заметка или шаблон пользователя. Вызывается после
+// сохранения нового содержимого; ошибки только записываются в лог - заметка уже сохранена
+func (s *imageService) DeleteUnreferenced(userID int64, previousContent string) {
+	var ids []int64
+	for _, m := range imageURL.FindAllStringSubmatch(previousContent, -1) {
+		if id, err := strconv.ParseInt(m[1], 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	s.Discard(userID, ids)
+}
+
+// Discard удаляет картинки из ids, на которые не ссылается ни одна заметка или шаблон пользователя.
+// Нужен для картинок, сохранённых для заметки, которую так и не удалось записать
+func (s *imageService) Discard(userID int64, ids []int64) {
+	if len(ids) == 0 {
+		return
+	}
+
+	originals, err := s.repo.DeleteUnreferenced(userID, ids, ImageURLPrefix)
+	if err != nil {
+		log.Printf("Не удалось удалить неиспользуемые картинки пользователя %d: %v", userID, err)
+		return
+	}
+	for _, key := range originals {
+		keys := []string{key}
+		for _, size := range ThumbnailSizes {
+			keys = append(keys, thumbnailKey(key, size))
+		}
+		s.deleteBlobs(keys)
+	}
+}
+
+func (s *imageService) deleteBlobs(keys []string) {
+	for _, key := range keys {
+		s.store.Delete(context.Background(), key)
+	}
+}
+
+// thumbnailSizesFor возвращает размеры, для которых нужна миниатюра: только меньше оригинала
+func thumbnailSizesFor(img *model.Image) []int {
+	var sizes []int
+	for _, size := range ThumbnailSizes {
+		if size < max(img.Width, img.Height) {
+			sizes = append(sizes, size)
+		}
+	}
+	return sizes
+}
+
+func isThumbnailSize(size int) bool {
+	for _, s := range ThumbnailSizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// thumbnailContentType - миниатюры JPEG остаются JPEG, остальные сохраняются в PNG
+func thumbnailContentType(contentType string) string {
+	if contentType == "image/jpeg" {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+func encodeThumbnail(img image.Image, size int, contentType string) ([]byte, string, error) {
+	thumb := util.Thumbnail(img, size)
+	thumbType := thumbnailContentType(contentType)
+
+	var buf bytes.Buffer
+	var err error
+	if thumbType == "image/jpeg" {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, thumb)
+	}
+	return buf.Bytes(), thumbType, err
+}
+
+func thumbnailKey(key string, size int) string {
+	return key + "_" + strconv.Itoa(size)
+}
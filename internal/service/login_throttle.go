@@ -0,0 +1,140 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"notes-api/internal/config"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoginThrottledError - вход временно запрещён из-за неудачных попыток
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return fmt.Sprintf("слишком много попыток входа, повторите через %v", e.RetryAfter.Round(time.Second))
+}
+
+type loginAttempts struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// LoginThrottle считает неудачные попытки входа по имени пользователя и по IP.
+// Счётчики хранятся в памяти процесса и сбрасываются при перезапуске.
+//
+// Попытка считается неудачной сразу при начале проверки и прощается только после успешного входа:
+// так параллельные запросы не успевают перебрать пароли, пока идёт медленная проверка хеша
+type LoginThrottle struct {
+	cfg config.LoginThrottleConfig
+
+	mu        sync.Mutex
+	attempts  map[string]*loginAttempts
+	lastSweep time.Time
+}
+
+func NewLoginThrottle(cfg config.LoginThrottleConfig) *LoginThrottle {
+	return &LoginThrottle{cfg: cfg, attempts: make(map[string]*loginAttempts)}
+}
+
+// Attempt проверяет, можно ли сейчас входить под username с адреса ip, и засчитывает попытку.
+// Имена несуществующих пользователей учитываются так же, чтобы блокировка не выдавала, какие аккаунты есть
+func (t *LoginThrottle) Attempt(username, ip string, now time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sweep(now)
+
+	userKey, ipKey := usernameKey(username), "ip:"+ip
+	var retryAfter time.Duration
+	for _, key := range []string{userKey, ipKey} {
+		if a := t.attempts[key]; a != nil && now.Before(a.blockedUntil) {
+			if d := a.blockedUntil.Sub(now); d > retryAfter {
+				retryAfter = d
+			}
+		}
+	}
+	if retryAfter > 0 {
+		log.Printf("Попытка входа отклонена: пользователь %q, IP %s, повтор через %v", username, ip, retryAfter.Round(time.Second))
+		return &LoginThrottledError{RetryAfter: retryAfter}
+	}
+
+	t.fail(userKey, t.cfg.FreeAttempts, t.cfg.LockoutThreshold, now)
+	t.fail(ipKey, t.cfg.IPFreeAttempts, t.cfg.IPLockoutThreshold, now)
+	return nil
+}
+
+// Succeeded прощает попытку после успешного входа: счётчик пользователя сбрасывается, а для IP
+// снимается только эта попытка. Пустой username оставляет счётчик пользователя - так делается
+// после верного пароля, когда ещё нужен код 2FA
+func (t *LoginThrottle) Succeeded(username, ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if username != "" {
+		delete(t.attempts, usernameKey(username))
+	}
+	if a := t.attempts["ip:"+ip]; a != nil {
+		a.failures--
+		if a.failures < t.cfg.IPFreeAttempts {
+			a.blockedUntil = time.Time{}
+		}
+	}
+}
+
+func (t *LoginThrottle) fail(key string, free, lockoutAt int, now time.Time) {
+	a := t.attempts[key]
+	if a == nil || expired(a, t.cfg.LockoutDuration, now) {
+		a = &loginAttempts{}
+		t.attempts[key] = a
+	}
+	a.failures++
+	a.lastFailure = now
+
+	switch {
+	case a.failures >= lockoutAt:
+		a.blockedUntil = now.Add(t.cfg.LockoutDuration)
+		if a.failures == lockoutAt {
+			log.Printf("Вход для %s заблокирован на %v после %d неудачных попыток", key, t.cfg.LockoutDuration, a.failures)
+		}
+	case a.failures >= free:
+		a.blockedUntil = now.Add(t.backoff(a.failures - free))
+	}
+}
+
+// backoff удваивает задержку с каждой попыткой сверх бесплатных
+func (t *LoginThrottle) backoff(extra int) time.Duration {
+	delay := t.cfg.BaseDelay
+	for i := 0; i < extra && delay < t.cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > t.cfg.MaxDelay {
+		delay = t.cfg.MaxDelay
+	}
+	return delay
+}
+
+// sweep забывает попытки, после которых прошло больше LockoutDuration, чтобы перебор
+// случайных имён не занимал память бесконечно
+func (t *LoginThrottle) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < t.cfg.LockoutDuration {
+		return
+	}
+	t.lastSweep = now
+	for key, a := range t.attempts {
+		if expired(a, t.cfg.LockoutDuration, now) {
+			delete(t.attempts, key)
+		}
+	}
+}
+
+func expired(a *loginAttempts, window time.Duration, now time.Time) bool {
+	return now.Sub(a.lastFailure) > window && !now.Before(a.blockedUntil)
+}
+
+func usernameKey(username string) string {
+	return "user:" + strings.ToLower(strings.TrimSpace(username))
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"html"
+	"notes-api/internal/model"
+	"regexp"
+	"sync"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	goldmarkhtml "github.com/yuin/goldmark/renderer/html"
+)
+
+// maxRenderCacheSize - сколько отрендеренных заметок держать в памяти
+const maxRenderCacheSize = 1000
+
+// noteRenderer превращает заметку в HTML: content разбирается как CommonMark с расширениями GFM,
+// после него выводятся чек-лист и таблицы заметки. Результат всегда проходит через
+// bluemonday, поэтому сырой HTML внутри Markdown допускается только из белого списка.
+type noteRenderer struct {
+	markdown goldmark.Markdown
+	policy   *bluemonday.Policy
+
+	mu    sync.Mutex
+	cache map[int64]renderedNote
+}
+
+type renderedNote struct {
+	fingerprint [sha256.Size]byte
+	html        string
+}
+
+func newNoteRenderer() *noteRenderer {
+	policy := bluemonday.UGCPolicy()
+	// Флажки списков задач GFM и чек-листа заметки
+	policy.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	policy.AllowAttrs("checked", "disabled").OnElements("input")
+	policy.AllowAttrs("class").Matching(regexp.MustCompile(`^(checklist|checklist-item( completed)?|note-table)$`)).OnElements("ul", "li", "table")
+
+	return &noteRenderer{
+		markdown: goldmark.New(
+			goldmark.WithExtensions(extension.GFM),
+			// Сырой HTML пропускается дальше и очищается политикой, иначе потерялся бы <u>
+			goldmark.WithRendererOptions(goldmarkhtml.WithUnsafe()),
+		),
+		policy: policy,
+		cache:  make(map[int64]renderedNote),
+	}
+}
+
+// Render возвращает HTML заметки. Заметка должна быть загружена вместе с чек-листом и таблицами.
+// Кэш проверяется по отпечатку содержимого, поэтому изменения пунктов и таблиц тоже учитываются.
+func (r *noteRenderer) Render(note *model.Note) (string, error) {
+	fingerprint, err := noteFingerprint(note)
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	cached, ok := r.cache[note.ID]
+	r.mu.Unlock()
+	if ok && cached.fingerprint == fingerprint {
+		return cached.html, nil
+	}
+
+	var buf bytes.Buffer
+	if err := r.markdown.Convert([]byte(note.Content), &buf); err != nil {
+		return "", err
+	}
+	writeChecklistHTML(&buf, note.ChecklistItems)
+	for _, table := range note.Tables {
+		writeTableHTML(&buf, table)
+	}
+	rendered := r.policy.Sanitize(buf.String())
+
+	r.mu.Lock()
+	if len(r.cache) >= maxRenderCacheSize {
+		r.cache = make(map[int64]renderedNote)
+	}
+	r.cache[note.ID] = renderedNote{fingerprint: fingerprint, html: rendered}
+	r.mu.Unlock()
+	return rendered, nil
+}
+
+// Invalidate удаляет заметку из кэша
+func (r *noteRenderer) Invalidate(noteID int64) {
+	r.mu.Lock()
+	delete(r.cache, noteID)
+	r.mu.Unlock()
+}
+
+// noteFingerprint считает хеш всего, что попадает в HTML
+func noteFingerprint(note *model.Note) ([sha256.Size]byte, error) {
+	data, err := json.Marshal(struct {
+		Content string
+		Items   []*model.ChecklistItem
+		Tables  []*model.NoteTable
+	}{note.Content, note.ChecklistItems, note.Tables})
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(data), nil
+}
+
+// writeChecklistHTML выводит чек-лист вложенными списками.
+// Пункты приходят в порядке документа, поэтому достаточно сгруппировать их по родителю
+func writeChecklistHTML(buf *bytes.Buffer, items []*model.ChecklistItem) {
+	var roots []*model.ChecklistItem
+	children := make(map[int64][]*model.ChecklistItem)
+	for _, item := range items {
+		if item.ParentID == nil {
+			roots = append(roots, item)
+		} else {
+			children[*item.ParentID] = append(children[*item.ParentID], item)
+		}
+	}
+	writeChecklistLevel(buf, roots, children)
+}
+
+func writeChecklistLevel(buf *bytes.Buffer, items []*model.ChecklistItem, children map[int64][]*model.ChecklistItem) {
+	if len(items) == 0 {
+		return
+	}
+	buf.WriteString(`<ul class="checklist">` + "\n")
+	for _, item := range items {
+		if item.Completed {
+			buf.WriteString(`<li class="checklist-item completed"><input type="checkbox" checked disabled> `)
+		} else {
+			buf.WriteString(`<li class="checklist-item"><input type="checkbox" disabled> `)
+		}
+		buf.WriteString(item.RichText.ToHTML())
+		writeChecklistLevel(buf, children[item.ID], children)
+		buf.WriteString("</li>\n")
+	}
+	buf.WriteString("</ul>\n")
+}
+
+func writeTableHTML(buf *bytes.Buffer, table *model.NoteTable) {
+	buf.WriteString(`<table class="note-table">` + "\n")
+	if table.Title != "" {
+		buf.WriteString("<caption>" + html.EscapeString(table.Title) + "</caption>\n")
+	}
+
+	buf.WriteString("<thead><tr>")
+	for _, column := range table.Columns {
+		buf.WriteString("<th>" + html.EscapeString(column.Name) + "</th>")
+	}
+	buf.WriteString("</tr></thead>\n<tbody>\n")
+
+	for _, row := range table.Rows {
+		byColumn := make(map[int64]string, len(row.Cells))
+		for _, cell := range row.Cells {
+			byColumn[cell.ColumnID] = cell.Content
+		}
+		buf.WriteString("<tr>")
+		for _, column := range table.Columns {
+			buf.WriteString("<td>" + html.EscapeString(byColumn[column.ID]) + "</td>")
+		}
+		buf.WriteString("</tr>\n")
+	}
+	buf.WriteString("</tbody>\n</table>\n")
+}
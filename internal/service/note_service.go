@@ -1,42 +1,416 @@
 package service
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"notes-api/internal/model"
 	"notes-api/internal/repository"
+	"notes-api/internal/richtext"
+	"notes-api/internal/util"
+	"strings"
+	"time"
 )
 
+// ErrInvalidNoteSort - неизвестный порядок сортировки заметок
+var ErrInvalidNoteSort = errors.New("неизвестный порядок сортировки: допустимы updated, created, title")
+
 type NoteService interface {
 	CreateNote(note *model.Note) error
 	GetNoteByID(id int64, userID int64) (*model.Note, error)
-	GetAllNotes(userID int64) ([]*model.Note, error)
-	UpdateNote(note *model.Note, userID int64) error
+	GetAllNotes(userID int64, includeArchived bool, sort model.NoteSort) ([]*model.Note, error)
+	UpdateNote(req *model.UpdateNoteRequest, userID int64) error
 	DeleteNote(id int64, userID int64) error
+	GetContent(id int64, userID int64, format model.ContentFormat) (string, error)
+	SetContent(id int64, userID int64, format model.ContentFormat, body []byte) (*model.Note, error)
+	RenderNote(id int64, userID int64) (string, error)
+	GetLinks(id int64, userID int64) ([]*model.NoteLink, error)
+	GetBacklinks(id int64, userID int64) ([]*model.NoteLink, error)
+	DuplicateNote(id int64, userID int64, title string) (*model.Note, error)
+	SetPinned(id int64, userID int64, pinned bool) (*model.Note, error)
+	SetArchived(id int64, userID int64, archived bool) (*model.Note, error)
+	SetColor(id int64, userID int64, color model.NoteColor) (*model.Note, error)
 }
 
 type noteService struct {
-	repo repository.NoteRepository
+	repo        repository.NoteRepository
+	links       repository.NoteLinkRepository
+	users       *repository.UserRepository
+	attachments AttachmentService
+	images      ImageService
+	renderer    *noteRenderer
 }
 
-func NewNoteService(repo repository.NoteRepository) NoteService {
-	return &noteService{repo: repo}
+func NewNoteService(repo repository.NoteRepository, links repository.NoteLinkRepository, users *repository.UserRepository, attachments AttachmentService, images ImageService) NoteService {
+	return &noteService{repo: repo, links: links, users: users, attachments: attachments, images: images, renderer: newNoteRenderer()}
 }
 
+// CreateNote создаёт заметку. Если стиль текста не указан, берётся стиль по умолчанию из настроек пользователя
 func (s *noteService) CreateNote(note *model.Note) error {
-	return s.repo.Create(note)
+	if !note.Color.IsValid() {
+		return errors.New("неизвестный цвет заметки")
+	}
+	user, err := s.users.GetByID(note.UserID)
+	if err != nil {
+		return err
+	}
+	if note.Style == "" && note.RichContent == nil {
+		note.Style = user.DefaultStyle
+	}
+	if note.Archived {
+		note.Pinned = false
+	}
+	if err := prepareNoteRecurrence(note, time.Now().In(user.Location())); err != nil {
+		return err
+	}
+	created, err := s.extractImages(note, note.UserID)
+	if err != nil {
+		return err
+	}
+	if err := prepareNoteRichContent(note); err != nil {
+		s.images.Discard(note.UserID, created)
+		return err
+	}
+	if err := s.repo.Create(note); err != nil {
+		s.images.Discard(note.UserID, created)
+		return err
+	}
+	if err := s.links.Replace(note.ID, note.UserID, richtext.ParseWikiLinks(note.Content)); err != nil {
+		return err
+	}
+	return s.links.ResolveDangling(note.UserID, note.ID, note.Title)
 }
 
 func (s *noteService) GetNoteByID(id int64, userID int64) (*model.Note, error) {
 	return s.repo.GetByID(id, userID)
 }
 
-func (s *noteService) GetAllNotes(userID int64) ([]*model.Note, error) {
-	return s.repo.GetAll(userID)
+// GetAllNotes возвращает заметки в порядке sort, а если он не задан - в порядке из настроек пользователя
+func (s *noteService) GetAllNotes(userID int64, includeArchived bool, sort model.NoteSort) ([]*model.Note, error) {
+	if sort == "" {
+		user, err := s.users.GetByID(userID)
+		if err != nil {
+			return nil, err
+		}
+		sort = user.NoteSort
+	}
+	if !sort.IsValid() {
+		return nil, ErrInvalidNoteSort
+	}
+	return s.repo.GetAll(userID, includeArchived, sort)
+}
+
+// UpdateNote сохраняет заметку. Правило повторения меняется, только если передано в запросе:
+// иначе обычное редактирование превращало бы повторяющуюся заметку в обычную.
+// Картинки, на которые ссылался прежний текст и больше не ссылается ничто, удаляются
+func (s *noteService) UpdateNote(req *model.UpdateNoteRequest, userID int64) error {
+	note := &req.Note
+	// Владелец проверяется до извлечения картинок, чтобы запрос к чужой заметке ничего не записал в хранилище
+	previous, err := s.repo.GetContent(note.ID, userID)
+	if err != nil {
+		return err
+	}
+	if req.Recurrence != nil {
+		user, err := s.users.GetByID(userID)
+		if err != nil {
+			return err
+		}
+		note.Recurrence = *req.Recurrence
+		if err := prepareNoteRecurrence(note, time.Now().In(user.Location())); err != nil {
+			return err
+		}
+	} else {
+		recurrence, next, err := s.repo.GetRecurrence(note.ID, userID)
+		if err != nil {
+			return err
+		}
+		note.Recurrence, note.NextOccurrenceAt = recurrence, next
+	}
+	created, err := s.extractImages(note, userID)
+	if err != nil {
+		return err
+	}
+	if err := prepareNoteRichContent(note); err != nil {
+		s.images.Discard(userID, created)
+		return err
+	}
+	if err := s.repo.Update(note, userID); err != nil {
+		s.images.Discard(userID, created)
+		return err
+	}
+	s.renderer.Invalidate(note.ID)
+	s.images.DeleteUnreferenced(userID, previous)
+	if err := s.links.Replace(note.ID, userID, richtext.ParseWikiLinks(note.Content)); err != nil {
+		return err
+	}
+	return s.renameBacklinks(note, userID)
+}
+
+// renameBacklinks обновляет текст ссылок [[Заголовок]] на заметку после её переименования
+// и направляет на неё висячие ссылки с новым заголовком. Ссылки вида [[id:123]] не меняются
+func (s *noteService) renameBacklinks(note *model.Note, userID int64) error {
+	backlinks, err := s.links.GetBacklinks(note.ID)
+	if err != nil {
+		return err
+	}
+	for _, link := range backlinks {
+		if strings.HasPrefix(link.Ref, "id:") || strings.EqualFold(link.Ref, note.Title) {
+			continue
+		}
+		source := note
+		if *link.NoteID != note.ID {
+			if source, err = s.repo.GetByID(*link.NoteID, userID); err != nil {
+				return err
+			}
+		}
+		source.Content = richtext.RenameWikiLinks(source.Content, link.Ref, note.Title)
+		source.RichContent = nil
+		if err := prepareNoteRichContent(source); err != nil {
+			return err
+		}
+		if err := s.repo.Update(source, userID); err != nil {
+			return err
+		}
+		s.renderer.Invalidate(source.ID)
+		if err := s.links.Replace(source.ID, userID, richtext.ParseWikiLinks(source.Content)); err != nil {
+			return err
+		}
+	}
+	return s.links.ResolveDangling(userID, note.ID, note.Title)
+}
+
+// DuplicateNote делает полную копию заметки в одной транзакции: чек-лист с вложенностью и состоянием
+// выполнения, таблицы со строками и ячейками, вики-ссылки. Вложения не копируются
+func (s *noteService) DuplicateNote(id int64, userID int64, title string) (*model.Note, error) {
+	note, err := s.repo.GetByID(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if title == "" {
+		title = note.Title + " (копия)"
+	}
+
+	tx, err := s.repo.BeginTx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	newID, err := s.repo.CopyTx(tx, id, userID, title, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.repo.GetByID(newID, userID)
+}
+
+func (s *noteService) SetPinned(id int64, userID int64, pinned bool) (*model.Note, error) {
+	if err := s.repo.SetPinned(id, userID, pinned); err != nil {
+		return nil, err
+	}
+	return s.repo.GetByID(id, userID)
+}
+
+func (s *noteService) SetArchived(id int64, userID int64, archived bool) (*model.Note, error) {
+	if err := s.repo.SetArchived(id, userID, archived); err != nil {
+		return nil, err
+	}
+	return s.repo.GetByID(id, userID)
+}
+
+func (s *noteService) SetColor(id int64, userID int64, color model.NoteColor) (*model.Note, error) {
+	if !color.IsValid() {
+		return nil, errors.New("неизвестный цвет заметки")
+	}
+	if err := s.repo.SetColor(id, userID, color); err != nil {
+		return nil, err
+	}
+	return s.repo.GetByID(id, userID)
+}
+
+// GetLinks возвращает ссылки из заметки на другие заметки, в том числе висячие
+func (s *noteService) GetLinks(id int64, userID int64) ([]*model.NoteLink, error) {
+	if err := s.checkOwnership(id, userID); err != nil {
+		return nil, err
+	}
+	return s.links.GetOutgoing(id)
 }
 
-func (s *noteService) UpdateNote(note *model.Note, userID int64) error {
-	return s.repo.Update(note, userID)
+// GetBacklinks возвращает заметки, которые ссылаются на эту заметку
+func (s *noteService) GetBacklinks(id int64, userID int64) ([]*model.NoteLink, error) {
+	if err := s.checkOwnership(id, userID); err != nil {
+		return nil, err
+	}
+	return s.links.GetBacklinks(id)
 }
 
+func (s *noteService) checkOwnership(id int64, userID int64) error {
+	exists, err := s.repo.Exists(id, userID)
+	if err != nil || !exists {
+		return errors.New("заметка не найдена или у вас нет к ней доступа")
+	}
+	return nil
+}
+
+// prepareNoteRecurrence проверяет правило повторения и вычисляет дату следующей копии.
+// Клиент может сам указать next_occurrence_at в будущем, чтобы задать первое повторение.
+// now передаётся в часовом поясе пользователя: по нему определяются день недели и день месяца
+func prepareNoteRecurrence(note *model.Note, now time.Time) error {
+	if note.Recurrence == "" {
+		note.NextOccurrenceAt = nil
+		return nil
+	}
+
+	rule, err := util.ParseRRule(note.Recurrence)
+	if err != nil {
+		return err
+	}
+
+	if note.NextOccurrenceAt == nil || !note.NextOccurrenceAt.After(now) {
+		rule.Anchor(now)
+		next := rule.Next(now)
+		note.NextOccurrenceAt = &next
+	} else {
+		rule.Anchor(note.NextOccurrenceAt.In(now.Location()))
+	}
+	note.Recurrence = rule.String()
+	return nil
+}
+
+// DeleteNote удаляет заметку. Метаданные вложений удаляются каскадно,
+// а их содержимое - из хранилища после успешного удаления заметки. Картинки из текста
+// удаляются, если на них не ссылаются другие заметки и шаблоны
 func (s *noteService) DeleteNote(id int64, userID int64) error {
-	return s.repo.Delete(id, userID)
+	previous, err := s.repo.GetContent(id, userID)
+	if err != nil {
+		return err
+	}
+	keys, err := s.attachments.NoteBlobKeys(id)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.Delete(id, userID); err != nil {
+		return err
+	}
+	s.renderer.Invalidate(id)
+	s.attachments.DeleteBlobs(keys)
+	s.images.DeleteUnreferenced(userID, previous)
+	return nil
+}
+
+// GetContent возвращает содержимое заметки в формате format
+func (s *noteService) GetContent(id int64, userID int64, format model.ContentFormat) (string, error) {
+	note, err := s.repo.GetByID(id, userID)
+	if err != nil {
+		return "", err
+	}
+
+	switch format {
+	case model.ContentFormatJSON:
+		data, err := json.Marshal(note.RichContent)
+		return string(data), err
+	case model.ContentFormatMarkdown:
+		return note.RichContent.ToMarkdown(), nil
+	case model.ContentFormatHTML:
+		return note.RichContent.ToHTML(), nil
+	}
+	return "", fmt.Errorf("неподдерживаемый формат: %s", format)
+}
+
+// SetContent заменяет содержимое заметки документом, разобранным из body в формате format
+func (s *noteService) SetContent(id int64, userID int64, format model.ContentFormat, body []byte) (*model.Note, error) {
+	note, err := s.repo.GetByID(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc *richtext.Document
+	var created []int64
+	switch format {
+	case model.ContentFormatJSON:
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("некорректный документ: %w", err)
+		}
+		if doc == nil {
+			return nil, errors.New("документ не передан")
+		}
+	case model.ContentFormatMarkdown:
+		text, ids, err := s.images.ExtractInline(string(body), userID)
+		if err != nil {
+			return nil, err
+		}
+		created = ids
+		doc = richtext.FromMarkdown(text)
+	case model.ContentFormatHTML:
+		if doc, err = richtext.FromHTML(string(body)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("неподдерживаемый формат: %s", format)
+	}
+
+	previous := note.Content
+	note.RichContent = doc
+	if err := prepareNoteRichContent(note); err != nil {
+		s.images.Discard(userID, created)
+		return nil, err
+	}
+	if err := s.repo.Update(note, userID); err != nil {
+		s.images.Discard(userID, created)
+		return nil, err
+	}
+	s.renderer.Invalidate(note.ID)
+	s.images.DeleteUnreferenced(userID, previous)
+	if err := s.links.Replace(note.ID, userID, richtext.ParseWikiLinks(note.Content)); err != nil {
+		return nil, err
+	}
+	return note, nil
+}
+
+// RenderNote возвращает очищенный HTML заметки вместе с чек-листом и таблицами
+func (s *noteService) RenderNote(id int64, userID int64) (string, error) {
+	note, err := s.repo.GetByID(id, userID)
+	if err != nil {
+		return "", err
+	}
+	return s.renderer.Render(note)
+}
+
+// extractImages заменяет картинки, вставленные в content как data URI, ссылками на сохранённые картинки.
+// Если клиент прислал rich_content, content всё равно будет построен из него заново.
+// Возвращает ID сохранённых картинок, которые нужно удалить, если заметка не сохранится
+func (s *noteService) extractImages(note *model.Note, userID int64) ([]int64, error) {
+	if note.RichContent != nil {
+		return nil, nil
+	}
+	content, created, err := s.images.ExtractInline(note.Content, userID)
+	if err != nil {
+		return nil, err
+	}
+	note.Content = content
+	return created, nil
+}
+
+// prepareNoteRichContent согласует форматированный текст заметки с полями content и style.
+// Если клиент прислал rich_content, content (Markdown) и style вычисляются из него,
+// чтобы старые клиенты продолжали видеть заметку; иначе документ строится из content и style.
+func prepareNoteRichContent(note *model.Note) error {
+	if note.RichContent == nil {
+		if note.Style != "" && !note.Style.IsValid() {
+			return errors.New("неизвестный стиль текста")
+		}
+		note.RichContent = richtext.FromLegacy(note.Content, string(note.Style))
+		return nil
+	}
+	if err := note.RichContent.Validate(); err != nil {
+		return err
+	}
+	note.Content = note.RichContent.ToMarkdown()
+	note.Style = model.TextStyle(note.RichContent.LegacyStyle())
+	return nil
 }
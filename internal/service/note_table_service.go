@@ -2,15 +2,30 @@ package service
 
 import (
 	"errors"
+	"fmt"
+	"io"
 	"notes-api/internal/model"
 	"notes-api/internal/repository"
+	"notes-api/internal/util"
+	"strings"
 )
 
 type NoteTableService interface {
 	CreateTable(req *model.CreateNoteTableRequest, noteID, userID int64) (*model.NoteTable, error)
 	AddRow(req *model.AddTableRowRequest, tableID, userID int64) (*model.TableRow, error)
+	AddRows(req *model.AddTableRowsRequest, noteID, tableID, userID int64) ([]*model.TableRow, error)
+	UpdateCells(req *model.UpdateTableCellsRequest, noteID, tableID, userID int64) ([]*model.TableRow, error)
+	ExportTable(noteID, tableID, userID int64, format model.TableFormat, w io.Writer) (*model.NoteTable, error)
+	ImportTable(noteID, userID int64, title string, format model.TableFormat, r io.Reader) (*model.NoteTable, error)
+	MoveTable(req *model.MoveNoteTableRequest, noteID, tableID, userID int64) (*model.NoteTable, error)
 }
 
+// Ограничения на размер пакетных операций с таблицами
+const (
+	maxBatchRows  = 5000
+	maxBatchCells = 20000
+)
+
 type noteTableServiceImpl struct {
 	tableRepo repository.NoteTableRepository
 	noteRepo  repository.NoteRepository
@@ -21,13 +36,28 @@ func NewNoteTableService(tableRepo repository.NoteTableRepository, noteRepo repo
 }
 
 func (s *noteTableServiceImpl) checkNoteOwnership(noteID, userID int64) error {
-	note, err := s.noteRepo.GetByID(noteID, userID)
-	if err != nil || note == nil {
+	exists, err := s.noteRepo.Exists(noteID, userID)
+	if err != nil || !exists {
 		return errors.New("заметка не найдена или у вас нет к ней доступа")
 	}
 	return nil
 }
 
+// checkTableOwnership проверяет, что таблица относится к заметке пользователя
+func (s *noteTableServiceImpl) checkTableOwnership(noteID, tableID, userID int64) error {
+	if err := s.checkNoteOwnership(noteID, userID); err != nil {
+		return err
+	}
+	tableNoteID, err := s.tableRepo.GetNoteIDByTableID(tableID)
+	if err != nil {
+		return err
+	}
+	if tableNoteID != noteID {
+		return errors.New("таблица не найдена")
+	}
+	return nil
+}
+
 func (s *noteTableServiceImpl) CreateTable(req *model.CreateNoteTableRequest, noteID, userID int64) (*model.NoteTable, error) {
 	if err := s.checkNoteOwnership(noteID, userID); err != nil {
 		return nil, err
@@ -70,3 +100,205 @@ func (s *noteTableServiceImpl) CreateTable(req *model.CreateNoteTableRequest, no
 func (s *noteTableServiceImpl) AddRow(req *model.AddTableRowRequest, tableID, userID int64) (*model.TableRow, error) {
 	return s.tableRepo.AddRow(tableID, req.Cells)
 }
+
+func (s *noteTableServiceImpl) AddRows(req *model.AddTableRowsRequest, noteID, tableID, userID int64) ([]*model.TableRow, error) {
+	if len(req.Rows) == 0 {
+		return nil, errors.New("не передано ни одной строки")
+	}
+	if len(req.Rows) > maxBatchRows {
+		return nil, fmt.Errorf("за один запрос можно добавить не более %d строк", maxBatchRows)
+	}
+
+	if err := s.checkTableOwnership(noteID, tableID, userID); err != nil {
+		return nil, err
+	}
+	return s.tableRepo.AddRows(tableID, req.Rows)
+}
+
+func (s *noteTableServiceImpl) UpdateCells(req *model.UpdateTableCellsRequest, noteID, tableID, userID int64) ([]*model.TableRow, error) {
+	if len(req.Cells) == 0 {
+		return nil, errors.New("не передано ни одной ячейки")
+	}
+	if len(req.Cells) > maxBatchCells {
+		return nil, fmt.Errorf("за один запрос можно изменить не более %d ячеек", maxBatchCells)
+	}
+
+	if err := s.checkTableOwnership(noteID, tableID, userID); err != nil {
+		return nil, err
+	}
+	return s.tableRepo.UpdateCells(tableID, req.Cells)
+}
+
+func (s *noteTableServiceImpl) ExportTable(noteID, tableID, userID int64, format model.TableFormat, w io.Writer) (*model.NoteTable, error) {
+	if err := s.checkNoteOwnership(noteID, userID); err != nil {
+		return nil, err
+	}
+
+	table, err := s.tableRepo.GetTableByID(tableID)
+	if err != nil {
+		return nil, err
+	}
+	if table.NoteID != noteID {
+		return nil, errors.New("таблица не найдена")
+	}
+
+	// Первая строка - названия колонок, далее ячейки в порядке колонок
+	header := make([]string, len(table.Columns))
+	columnIndex := make(map[int64]int, len(table.Columns))
+	for i, col := range table.Columns {
+		header[i] = col.Name
+		columnIndex[col.ID] = i
+	}
+
+	records := [][]string{header}
+	for _, row := range table.Rows {
+		record := make([]string, len(table.Columns))
+		for _, cell := range row.Cells {
+			if i, ok := columnIndex[cell.ColumnID]; ok {
+				record[i] = cell.Content
+			}
+		}
+		records = append(records, record)
+	}
+
+	switch format {
+	case model.TableFormatCSV:
+		err = util.WriteCSV(w, records)
+	case model.TableFormatXLSX:
+		err = util.WriteXLSX(w, table.Title, records)
+	default:
+		err = fmt.Errorf("неподдерживаемый формат: %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+func (s *noteTableServiceImpl) ImportTable(noteID, userID int64, title string, format model.TableFormat, r io.Reader) (*model.NoteTable, error) {
+	if err := s.checkNoteOwnership(noteID, userID); err != nil {
+		return nil, err
+	}
+
+	var records [][]string
+	var err error
+	switch format {
+	case model.TableFormatCSV:
+		records, err = util.ReadCSV(r)
+	case model.TableFormatXLSX:
+		records, err = util.ReadXLSX(r)
+	default:
+		err = fmt.Errorf("неподдерживаемый формат: %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns, rows, err := normalizeImportedRecords(records)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.tableRepo.BeginTx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	table := &model.NoteTable{
+		NoteID: noteID,
+		Title:  title,
+	}
+
+	if err := s.tableRepo.Create(tx, table); err != nil {
+		return nil, err
+	}
+
+	if err := s.tableRepo.CreateColumns(tx, table.ID, columns); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.tableRepo.AddRowsTx(tx, table.ID, rows); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.tableRepo.GetTableByID(table.ID)
+}
+
+// normalizeImportedRecords отделяет заголовок от данных: пропускает пустые строки,
+// дополняет короткие строки пустыми ячейками и отбрасывает пустые ячейки за пределами колонок
+func normalizeImportedRecords(records [][]string) ([]string, [][]string, error) {
+	var nonEmpty [][]string
+	for _, record := range records {
+		if !isEmptyRecord(record) {
+			nonEmpty = append(nonEmpty, record)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return nil, nil, errors.New("файл не содержит данных")
+	}
+
+	header := nonEmpty[0]
+	for len(header) > 0 && strings.TrimSpace(header[len(header)-1]) == "" {
+		header = header[:len(header)-1]
+	}
+
+	columns := make([]string, len(header))
+	seen := make(map[string]bool, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			name = fmt.Sprintf("Колонка %d", i+1)
+		}
+		if seen[name] {
+			return nil, nil, fmt.Errorf("повторяющееся название колонки: %s", name)
+		}
+		seen[name] = true
+		columns[i] = name
+	}
+
+	rows := make([][]string, 0, len(nonEmpty)-1)
+	for i, record := range nonEmpty[1:] {
+		if len(record) > len(columns) {
+			if !isEmptyRecord(record[len(columns):]) {
+				return nil, nil, fmt.Errorf("строка %d: количество ячеек (%d) больше количества колонок (%d)", i+2, len(record), len(columns))
+			}
+			record = record[:len(columns)]
+		}
+		cells := make([]string, len(columns))
+		copy(cells, record)
+		rows = append(rows, cells)
+	}
+	return columns, rows, nil
+}
+
+func isEmptyRecord(record []string) bool {
+	for _, v := range record {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// MoveTable переносит таблицу в другую заметку того же пользователя
+func (s *noteTableServiceImpl) MoveTable(req *model.MoveNoteTableRequest, noteID, tableID, userID int64) (*model.NoteTable, error) {
+	if err := s.checkTableOwnership(noteID, tableID, userID); err != nil {
+		return nil, err
+	}
+	if req.TargetNoteID == noteID {
+		return nil, errors.New("таблица уже находится в этой заметке")
+	}
+	if err := s.checkNoteOwnership(req.TargetNoteID, userID); err != nil {
+		return nil, errors.New("целевая заметка не найдена или у вас нет к ней доступа")
+	}
+
+	if err := s.tableRepo.MoveToNote(tableID, noteID, req.TargetNoteID); err != nil {
+		return nil, err
+	}
+	return s.tableRepo.GetTableByID(tableID)
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"notes-api/internal/model"
+	"notes-api/internal/repository"
+	"notes-api/internal/richtext"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// templateVariable - переменная шаблона вида {{name}}
+var templateVariable = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+type NoteTemplateService interface {
+	CreateFromNote(req *model.CreateTemplateRequest, userID int64) (*model.NoteTemplate, error)
+	List(userID int64) ([]*model.NoteTemplate, error)
+	Get(id, userID int64) (*model.NoteTemplate, error)
+	Delete(id, userID int64) error
+	CreateNote(templateID, userID int64, req *model.CreateNoteFromTemplateRequest) (*model.Note, error)
+}
+
+type noteTemplateService struct {
+	repo      repository.NoteTemplateRepository
+	noteRepo  repository.NoteRepository
+	itemRepo  repository.ChecklistItemRepository
+	tableRepo repository.NoteTableRepository
+	linkRepo  repository.NoteLinkRepository
+	userRepo  *repository.UserRepository
+}
+
+func NewNoteTemplateService(
+	repo repository.NoteTemplateRepository,
+	noteRepo repository.NoteRepository,
+	itemRepo repository.ChecklistItemRepository,
+	tableRepo repository.NoteTableRepository,
+	linkRepo repository.NoteLinkRepository,
+	userRepo *repository.UserRepository,
+) NoteTemplateService {
+	return &noteTemplateService{repo: repo, noteRepo: noteRepo, itemRepo: itemRepo, tableRepo: tableRepo, linkRepo: linkRepo, userRepo: userRepo}
+}
+
+// CreateFromNote сохраняет заметку как шаблон: текст, дерево пунктов чек-листа и колонки таблиц.
+// Строки таблиц, выполненность, сроки и исполнители пунктов в шаблон не попадают
+func (s *noteTemplateService) CreateFromNote(req *model.CreateTemplateRequest, userID int64) (*model.NoteTemplate, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, errors.New("название шаблона не может быть пустым")
+	}
+	note, err := s.noteRepo.GetByID(req.NoteID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &model.NoteTemplate{
+		UserID:    userID,
+		Name:      name,
+		Title:     note.Title,
+		Content:   note.Content,
+		Style:     note.Style,
+		Checklist: templateChecklist(note.ChecklistItems),
+		Tables:    make([]*model.TemplateTable, 0, len(note.Tables)),
+	}
+	for _, table := range note.Tables {
+		columns := make([]string, len(table.Columns))
+		for i, column := range table.Columns {
+			columns[i] = column.Name
+		}
+		t.Tables = append(t.Tables, &model.TemplateTable{Title: table.Title, Columns: columns})
+	}
+
+	if err := s.repo.Create(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// templateChecklist строит дерево пунктов шаблона. Пункты приходят в порядке документа,
+// поэтому родитель всегда встречается раньше своих вложенных пунктов
+func templateChecklist(items []*model.ChecklistItem) []*model.TemplateChecklistItem {
+	roots := make([]*model.TemplateChecklistItem, 0)
+	byID := make(map[int64]*model.TemplateChecklistItem, len(items))
+	for _, item := range items {
+		ti := &model.TemplateChecklistItem{Text: item.Text, Style: item.Style, RichText: item.RichText}
+		byID[item.ID] = ti
+		if item.ParentID == nil {
+			roots = append(roots, ti)
+		} else if parent, ok := byID[*item.ParentID]; ok {
+			parent.Children = append(parent.Children, ti)
+		}
+	}
+	return roots
+}
+
+func (s *noteTemplateService) List(userID int64) ([]*model.NoteTemplate, error) {
+	return s.repo.GetAll(userID)
+}
+
+func (s *noteTemplateService) Get(id, userID int64) (*model.NoteTemplate, error) {
+	return s.repo.GetByID(id, userID)
+}
+
+func (s *noteTemplateService) Delete(id, userID int64) error {
+	return s.repo.Delete(id, userID)
+}
+
+// CreateNote создаёт заметку по шаблону в одной транзакции: заметку, пункты чек-листа и таблицы.
+// Неизвестные переменные остаются в тексте как есть
+func (s *noteTemplateService) CreateNote(templateID, userID int64, req *model.CreateNoteFromTemplateRequest) (*model.Note, error) {
+	t, err := s.repo.GetByID(templateID, userID)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	// Дата и время подставляются в часовом поясе пользователя, а не сервера
+	vars := templateVariables(time.Now().In(user.Location()), user.Username, req.Variables)
+
+	title := t.Title
+	if req.Title != "" {
+		title = req.Title
+	}
+	note := &model.Note{
+		UserID: userID,
+		Title:  substituteVariables(title, vars, false),
+		// Значения подставляются в Markdown, поэтому экранируются
+		Content: substituteVariables(t.Content, vars, true),
+		Style:   t.Style,
+	}
+	if strings.TrimSpace(note.Title) == "" {
+		return nil, errors.New("заголовок заметки не может быть пустым")
+	}
+	if err := prepareNoteRichContent(note); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.noteRepo.BeginTx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := s.noteRepo.CreateTx(tx, note); err != nil {
+		return nil, err
+	}
+	if err := s.createItemsTx(tx, note.ID, nil, t.Checklist, vars); err != nil {
+		return nil, err
+	}
+	for _, tt := range t.Tables {
+		table := &model.NoteTable{NoteID: note.ID, Title: substituteVariables(tt.Title, vars, false)}
+		if err := s.tableRepo.Create(tx, table); err != nil {
+			return nil, err
+		}
+		columns := make([]string, len(tt.Columns))
+		for i, column := range tt.Columns {
+			columns[i] = substituteVariables(column, vars, false)
+		}
+		if err := s.tableRepo.CreateColumns(tx, table.ID, columns); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if err := s.linkRepo.Replace(note.ID, userID, richtext.ParseWikiLinks(note.Content)); err != nil {
+		return nil, err
+	}
+	if err := s.linkRepo.ResolveDangling(userID, note.ID, note.Title); err != nil {
+		return nil, err
+	}
+	return s.noteRepo.GetByID(note.ID, userID)
+}
+
+func (s *noteTemplateService) createItemsTx(tx *sql.Tx, noteID int64, parentID *int64, items []*model.TemplateChecklistItem, vars map[string]string) error {
+	for _, ti := range items {
+		item := &model.ChecklistItem{
+			NoteID:   noteID,
+			ParentID: parentID,
+			Text:     substituteVariables(ti.Text, vars, false),
+			Style:    ti.Style,
+			RichText: substituteInlines(ti.RichText, vars),
+		}
+		if err := prepareItemRichText(item); err != nil {
+			return err
+		}
+		if err := s.itemRepo.CreateTx(tx, item); err != nil {
+			return err
+		}
+		if err := s.createItemsTx(tx, noteID, &item.ID, ti.Children, vars); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// templateVariables возвращает встроенные переменные, перекрытые переменными из запроса
+func templateVariables(now time.Time, username string, custom map[string]string) map[string]string {
+	vars := map[string]string{
+		"date":     now.Format("2006-01-02"),
+		"time":     now.Format("15:04"),
+		"datetime": now.Format("2006-01-02 15:04"),
+		"user":     username,
+	}
+	for name, value := range custom {
+		vars[name] = value
+	}
+	return vars
+}
+
+func substituteVariables(text string, vars map[string]string, markdown bool) string {
+	return templateVariable.ReplaceAllStringFunc(text, func(match string) string {
+		value, ok := vars[templateVariable.FindStringSubmatch(match)[1]]
+		if !ok {
+			return match
+		}
+		if markdown {
+			return richtext.EscapeMarkdown(value)
+		}
+		return value
+	})
+}
+
+func substituteInlines(inlines richtext.Inlines, vars map[string]string) richtext.Inlines {
+	if inlines == nil {
+		return nil
+	}
+	result := make(richtext.Inlines, len(inlines))
+	for i, in := range inlines {
+		in.Text = substituteVariables(in.Text, vars, false)
+		result[i] = in
+	}
+	return result
+}
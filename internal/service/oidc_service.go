@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"notes-api/internal/model"
+	"notes-api/internal/oidc"
+	"notes-api/internal/repository"
+	"notes-api/internal/util"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Сколько времени есть у пользователя, чтобы войти на стороне провайдера
+const oidcStateTTL = 10 * time.Minute
+
+// Допустимое расхождение часов с провайдером при проверке auth_time
+const oidcClockSkew = time.Minute
+
+var (
+	ErrUnknownProvider = errors.New("неизвестный провайдер входа")
+	// ErrIdentityNotLinked - учётная запись провайдера не привязана, а автосоздание пользователей выключено
+	ErrIdentityNotLinked = errors.New("учётная запись не привязана ни к одному пользователю")
+	ErrLastLoginMethod   = errors.New("нельзя отвязать единственный способ входа: сначала задайте пароль")
+	// ErrReauthMismatch - повторный вход выполнен другой учётной записью провайдера
+	// или провайдер не подтвердил, что учётные данные вводились заново
+	ErrReauthMismatch = errors.New("повторный вход не подтверждён: войдите заново привязанной учётной записью")
+)
+
+// OIDCCallbackResult - итог возврата от провайдера: вход, привязка учётной записи
+// или повторный вход для подтверждения операций с аккаунтом
+type OIDCCallbackResult struct {
+	Login           *model.LoginResponse
+	Linked          *model.UserIdentity
+	Reauthenticated *model.ReauthenticationResponse
+}
+
+type OIDCService interface {
+	Providers() []string
+	LoginURL(ctx context.Context, provider string) (authURL, state string, err error)
+	LinkURL(ctx context.Context, provider string, userID int64) (authURL, state string, err error)
+	ReauthURL(ctx context.Context, provider string, userID, sessionID int64) (authURL, state string, err error)
+	Callback(ctx context.Context, provider, code, state string, client ClientInfo) (*OIDCCallbackResult, error)
+	Identities(userID int64) ([]*model.UserIdentity, error)
+	Unlink(id, userID int64) error
+}
+
+type oidcService struct {
+	providers map[string]*oidc.Provider
+	repo      repository.UserIdentityRepository
+	userRepo  *repository.UserRepository
+	auth      *AuthService
+}
+
+func NewOIDCService(providers []*oidc.Provider, repo repository.UserIdentityRepository, userRepo *repository.UserRepository, auth *AuthService) OIDCService {
+	byName := make(map[string]*oidc.Provider, len(providers))
+	for _, p := range providers {
+		byName[p.Name()] = p
+	}
+	return &oidcService{providers: byName, repo: repo, userRepo: userRepo, auth: auth}
+}
+
+func (s *oidcService) Providers() []string {
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoginURL начинает вход через провайдера: сохраняет state, nonce и PKCE code_verifier
+func (s *oidcService) LoginURL(ctx context.Context, provider string) (string, string, error) {
+	return s.start(ctx, provider, &model.OIDCState{})
+}
+
+// LinkURL начинает привязку учётной записи провайдера к пользователю userID
+func (s *oidcService) LinkURL(ctx context.Context, provider string, userID int64) (string, string, error) {
+	return s.start(ctx, provider, &model.OIDCState{UserID: userID})
+}
+
+// ReauthURL начинает повторный вход, которым пользователь без пароля подтверждает операции
+// с аккаунтом в сеансе sessionID. Провайдер запрашивает учётные данные заново
+func (s *oidcService) ReauthURL(ctx context.Context, provider string, userID, sessionID int64) (string, string, error) {
+	return s.start(ctx, provider, &model.OIDCState{UserID: userID, SessionID: sessionID})
+}
+
+// start сохраняет state вместе с nonce и PKCE code_verifier и возвращает адрес страницы провайдера.
+// В st заполняются только UserID и SessionID
+func (s *oidcService) start(ctx context.Context, provider string, st *model.OIDCState) (string, string, error) {
+	p, ok := s.providers[provider]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+
+	var values [3]string
+	for i := range values {
+		v, err := oidc.RandomString()
+		if err != nil {
+			return "", "", err
+		}
+		values[i] = v
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	authURL, err := p.AuthCodeURL(ctx, state, nonce, verifier, st.SessionID != 0)
+	if err != nil {
+		return "", "", err
+	}
+	st.Provider = provider
+	st.Nonce = nonce
+	st.CodeVerifier = verifier
+	st.ExpiresAt = time.Now().Add(oidcStateTTL)
+	if err := s.repo.CreateState(util.HashToken(state), st); err != nil {
+		return "", "", err
+	}
+	return authURL, state, nil
+}
+
+// Callback завершает вход: обменивает код на ID-токен, находит или создаёт пользователя
+// и выдаёт JWT (или challenge-токен, если включена 2FA)
+func (s *oidcService) Callback(ctx context.Context, provider, code, state string, client ClientInfo) (*OIDCCallbackResult, error) {
+	p, ok := s.providers[provider]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	st, err := s.repo.ConsumeState(util.HashToken(state), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if st.Provider != provider {
+		return nil, errors.New("вход начат через другого провайдера")
+	}
+
+	claims, err := p.Exchange(ctx, code, st.CodeVerifier, st.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	// Неподтверждённому email провайдера доверять нельзя
+	email := ""
+	if claims.EmailVerified {
+		email = claims.Email
+	}
+
+	if st.SessionID != 0 {
+		return s.reauthenticate(st, provider, claims)
+	}
+	if st.UserID != 0 {
+		identity, err := s.repo.Link(st.UserID, provider, claims.Subject, email)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Пользователь %d привязал учётную запись %s", st.UserID, provider)
+		return &OIDCCallbackResult{Linked: identity}, nil
+	}
+
+	userID, found, err := s.repo.FindUserID(provider, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *model.User
+	if found {
+		if err := s.repo.TouchLogin(provider, claims.Subject, time.Now()); err != nil {
+			return nil, err
+		}
+		if user, err = s.userRepo.GetByID(userID); err != nil {
+			return nil, err
+		}
+	} else {
+		if !p.AutoProvision() {
+			return nil, ErrIdentityNotLinked
+		}
+		// Пароль пустой: входить можно только через провайдера, пока пользователь не задаст пароль
+		user = &model.User{Username: usernameFromClaims(claims), Email: email}
+		if err := s.repo.CreateUserWithIdentity(user, provider, claims.Subject); err != nil {
+			return nil, err
+		}
+		log.Printf("Создан пользователь %d (%s) при первом входе через %s", user.ID, user.Username, provider)
+	}
+
+	login, err := s.auth.IssueLogin(user, client)
+	if err != nil {
+		return nil, err
+	}
+	return &OIDCCallbackResult{Login: login}, nil
+}
+
+// reauthenticate принимает повторный вход, только если пользователь ввёл учётные данные
+// после начала входа и вошёл той же учётной записью, что привязана к аккаунту
+func (s *oidcService) reauthenticate(st *model.OIDCState, provider string, claims *oidc.Claims) (*OIDCCallbackResult, error) {
+	startedAt := st.ExpiresAt.Add(-oidcStateTTL)
+	if claims.AuthTime.IsZero() || claims.AuthTime.Before(startedAt.Add(-oidcClockSkew)) {
+		return nil, ErrReauthMismatch
+	}
+	userID, found, err := s.repo.FindUserID(provider, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if !found || userID != st.UserID {
+		return nil, ErrReauthMismatch
+	}
+
+	expiresAt, err := s.auth.markReauthenticated(st.UserID, st.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Пользователь %d подтвердил вход через %s в сеансе %d", st.UserID, provider, st.SessionID)
+	return &OIDCCallbackResult{Reauthenticated: &model.ReauthenticationResponse{ExpiresAt: expiresAt}}, nil
+}
+
+func (s *oidcService) Identities(userID int64) ([]*model.UserIdentity, error) {
+	return s.repo.GetByUserID(userID)
+}
+
+// Unlink отвязывает учётную запись провайдера. Последний способ входа отвязать нельзя
+func (s *oidcService) Unlink(id, userID int64) error {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.Password == "" {
+		identities, err := s.repo.GetByUserID(userID)
+		if err != nil {
+			return err
+		}
+		if len(identities) <= 1 {
+			return ErrLastLoginMethod
+		}
+	}
+	return s.repo.Delete(id, userID)
+}
+
+// usernameFromClaims подбирает имя для нового пользователя: preferred_username
+// или начало email. Свободность имени проверяет репозиторий
+func usernameFromClaims(c *oidc.Claims) string {
+	candidate := c.PreferredUsername
+	if candidate == "" {
+		candidate, _, _ = strings.Cut(c.Email, "@")
+	}
+
+	var b strings.Builder
+	for _, r := range strings.ToLower(candidate) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '.' || r == '_' || r == '-' {
+			b.WriteRune(r)
+		}
+		if b.Len() >= 50 {
+			break
+		}
+	}
+	if b.Len() == 0 {
+		return "user"
+	}
+	return b.String()
+}
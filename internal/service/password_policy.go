@@ -0,0 +1,100 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"notes-api/internal/config"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// bcrypt учитывает только первые 72 байта пароля, более длинные пароли он отвергает
+const maxPasswordBytes = 72
+
+// PasswordPolicy проверяет новые пароли: длину, совпадение с именем пользователя
+// и наличие в локальном списке утёкших паролей
+type PasswordPolicy struct {
+	minLength int
+	// breached - отсортированные SHA-1 хеши утёкших паролей
+	breached [][sha1.Size]byte
+}
+
+func NewPasswordPolicy(cfg config.PasswordPolicyConfig) (*PasswordPolicy, error) {
+	p := &PasswordPolicy{minLength: cfg.MinLength}
+	if cfg.BreachedHashesFile == "" {
+		return p, nil
+	}
+
+	breached, err := loadBreachedHashes(cfg.BreachedHashesFile)
+	if err != nil {
+		return nil, err
+	}
+	p.breached = breached
+	log.Printf("Загружено %d хешей утёкших паролей", len(breached))
+	return p, nil
+}
+
+// Check возвращает сообщение об ошибке для пользователя или пустую строку, если пароль подходит
+func (p *PasswordPolicy) Check(password, username string) string {
+	switch {
+	case utf8.RuneCountInString(password) < p.minLength:
+		return fmt.Sprintf("пароль должен быть не короче %d символов", p.minLength)
+	case len(password) > maxPasswordBytes:
+		return fmt.Sprintf("пароль должен быть не длиннее %d байт", maxPasswordBytes)
+	case username != "" && strings.EqualFold(password, username):
+		return "пароль не должен совпадать с именем пользователя"
+	case p.isBreached(password):
+		return "этот пароль есть в базах утёкших паролей, выберите другой"
+	}
+	return ""
+}
+
+func (p *PasswordPolicy) isBreached(password string) bool {
+	if len(p.breached) == 0 {
+		return false
+	}
+	sum := sha1.Sum([]byte(password))
+	i := sort.Search(len(p.breached), func(i int) bool {
+		return bytes.Compare(p.breached[i][:], sum[:]) >= 0
+	})
+	return i < len(p.breached) && p.breached[i] == sum
+}
+
+// loadBreachedHashes читает строки вида "HASH" или "HASH:count". Пустые строки и строки с # пропускаются
+func loadBreachedHashes(path string) ([][sha1.Size]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var hashes [][sha1.Size]byte
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		hexHash, _, _ := strings.Cut(text, ":")
+		var h [sha1.Size]byte
+		if len(hexHash) != hex.EncodedLen(sha1.Size) {
+			return nil, fmt.Errorf("%s:%d: ожидается SHA-1 хеш в hex", path, line)
+		}
+		if _, err := hex.Decode(h[:], []byte(hexHash)); err != nil {
+			return nil, fmt.Errorf("%s:%d: ожидается SHA-1 хеш в hex", path, line)
+		}
+		hashes = append(hashes, h)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	sort.Slice(hashes, func(i, j int) bool { return bytes.Compare(hashes[i][:], hashes[j][:]) < 0 })
+	return hashes, nil
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"fmt"
+	"notes-api/internal/model"
+	"notes-api/internal/repository"
+	"strings"
+	"time"
+	// Встроенная база часовых поясов: в минимальных контейнерах нет /usr/share/zoneinfo
+	_ "time/tzdata"
+	"unicode/utf8"
+)
+
+const maxDisplayNameLength = 100
+
+type ProfileService interface {
+	Get(userID int64) (*model.Profile, error)
+	Update(userID, sessionID int64, req model.UpdateProfileRequest, client ClientInfo) (*model.Profile, error)
+}
+
+type profileService struct {
+	userRepo *repository.UserRepository
+	auth     *AuthService
+}
+
+func NewProfileService(userRepo *repository.UserRepository, auth *AuthService) ProfileService {
+	return &profileService{userRepo: userRepo, auth: auth}
+}
+
+func (s *profileService) Get(userID int64) (*model.Profile, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	return newProfile(user), nil
+}
+
+// Update меняет переданные поля профиля. Смена email подтверждается текущим паролем
+// (см. AuthService.ConfirmIdentity), так как на email приходят ссылки для сброса пароля
+func (s *profileService) Update(userID, sessionID int64, req model.UpdateProfileRequest, client ClientInfo) (*model.Profile, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	invalid := validationErrors{}
+	if req.DisplayName != nil {
+		user.DisplayName = strings.TrimSpace(*req.DisplayName)
+		if utf8.RuneCountInString(user.DisplayName) > maxDisplayNameLength {
+			invalid.add("display_name", fmt.Sprintf("имя должно быть не длиннее %d символов", maxDisplayNameLength))
+		}
+	}
+	emailChanged := false
+	if req.Email != nil {
+		email := strings.TrimSpace(*req.Email)
+		if email != "" {
+			invalid.add("email", validateEmail(email))
+		}
+		emailChanged = email != user.Email
+		user.Email = email
+	}
+	if req.Locale != nil {
+		if !req.Locale.IsValid() {
+			invalid.add("locale", "допустимые языки: ru, en")
+		}
+		user.Locale = *req.Locale
+	}
+	if req.Timezone != nil {
+		if _, err := time.LoadLocation(*req.Timezone); err != nil || *req.Timezone == "" {
+			invalid.add("timezone", "неизвестный часовой пояс, ожидается имя из базы IANA, например Europe/Moscow")
+		}
+		user.Timezone = *req.Timezone
+	}
+	if req.DefaultStyle != nil {
+		if !req.DefaultStyle.IsValid() {
+			invalid.add("default_style", "допустимые стили: normal, bold, italic")
+		}
+		user.DefaultStyle = *req.DefaultStyle
+	}
+	if req.NoteSort != nil {
+		if !req.NoteSort.IsValid() {
+			invalid.add("note_sort", "допустимые значения: updated, created, title")
+		}
+		user.NoteSort = *req.NoteSort
+	}
+	if err := invalid.err(); err != nil {
+		return nil, err
+	}
+
+	if emailChanged {
+		if err := s.auth.ConfirmIdentity(user, sessionID, req.CurrentPassword, req.Code, client); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.userRepo.UpdateProfile(user); err != nil {
+		return nil, err
+	}
+	return newProfile(user), nil
+}
+
+func newProfile(user *model.User) *model.Profile {
+	return &model.Profile{
+		ID:           user.ID,
+		Username:     user.Username,
+		DisplayName:  user.DisplayName,
+		Email:        user.Email,
+		Locale:       user.Locale,
+		Timezone:     user.Timezone,
+		DefaultStyle: user.DefaultStyle,
+		NoteSort:     user.NoteSort,
+		Role:         user.Role,
+		TOTPEnabled:  user.TOTPEnabled,
+		CreatedAt:    user.CreatedAt,
+	}
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"notes-api/internal/model"
+	"notes-api/internal/repository"
+	"notes-api/internal/util"
+	"time"
+)
+
+// Сколько повторяющихся заметок обрабатывается за один проход планировщика
+const recurringNoteBatchSize = 50
+
+// RecurringNoteScheduler создаёт копии повторяющихся заметок,
+// когда наступает дата их следующего повторения
+type RecurringNoteScheduler struct {
+	noteRepo repository.NoteRepository
+	userRepo *repository.UserRepository
+	interval time.Duration
+}
+
+func NewRecurringNoteScheduler(noteRepo repository.NoteRepository, userRepo *repository.UserRepository, interval time.Duration) *RecurringNoteScheduler {
+	return &RecurringNoteScheduler{noteRepo: noteRepo, userRepo: userRepo, interval: interval}
+}
+
+// Start запускает планировщик в отдельной горутине до отмены ctx
+func (s *RecurringNoteScheduler) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			s.RunOnce(time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// RunOnce создаёт копии всех заметок, повторение которых наступило к моменту now
+func (s *RecurringNoteScheduler) RunOnce(now time.Time) {
+	notes, err := s.noteRepo.GetDueRecurring(now, recurringNoteBatchSize)
+	if err != nil {
+		log.Printf("Ошибка получения повторяющихся заметок: %v", err)
+		return
+	}
+
+	for _, note := range notes {
+		newID, err := s.materialize(note, now)
+		if err != nil {
+			log.Printf("Не удалось создать повторение заметки %d: %v", note.ID, err)
+			continue
+		}
+		if newID != 0 {
+			log.Printf("Создано повторение заметки %d: новая заметка %d", note.ID, newID)
+		}
+	}
+}
+
+// materialize копирует заметку на дату её повторения и сдвигает дату следующего.
+// Пропущенные повторения (например, пока сервер был выключен) не создаются.
+// Повторения считаются в часовом поясе владельца заметки
+func (s *RecurringNoteScheduler) materialize(note *model.Note, now time.Time) (int64, error) {
+	rule, err := util.ParseRRule(note.Recurrence)
+	if err != nil {
+		return 0, err
+	}
+	user, err := s.userRepo.GetByID(note.UserID)
+	if err != nil {
+		return 0, err
+	}
+	occurrence := note.NextOccurrenceAt.In(user.Location())
+	next := rule.NextAfter(occurrence, now)
+
+	tx, err := s.noteRepo.BeginTx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Сдвиг даты блокирует заметку: параллельный проход не создаст вторую копию
+	updated, err := s.noteRepo.SetNextOccurrenceTx(tx, note.ID, occurrence, next)
+	if err != nil {
+		return 0, err
+	}
+	if !updated {
+		return 0, nil
+	}
+
+	title := fmt.Sprintf("%s (%s)", note.Title, occurrence.Format("2006-01-02"))
+	newID, err := s.noteRepo.CopyTx(tx, note.ID, note.UserID, title, true)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, tx.Commit()
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"notes-api/internal/notify"
+	"notes-api/internal/repository"
+	"time"
+)
+
+// Сколько напоминаний обрабатывается за один проход планировщика
+const reminderBatchSize = 100
+
+// ReminderScheduler периодически находит наступившие напоминания
+// по пунктам чек-листов и отправляет их через Notifier
+type ReminderScheduler struct {
+	itemRepo repository.ChecklistItemRepository
+	notifier notify.Notifier
+	interval time.Duration
+}
+
+func NewReminderScheduler(itemRepo repository.ChecklistItemRepository, notifier notify.Notifier, interval time.Duration) *ReminderScheduler {
+	return &ReminderScheduler{
+		itemRepo: itemRepo,
+		notifier: notifier,
+		interval: interval,
+	}
+}
+
+// Start запускает планировщик в отдельной горутине до отмены ctx
+func (s *ReminderScheduler) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			s.RunOnce(ctx, time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// RunOnce отправляет все напоминания, время которых наступило к моменту now
+func (s *ReminderScheduler) RunOnce(ctx context.Context, now time.Time) {
+	for {
+		reminders, err := s.itemRepo.GetPendingReminders(now, reminderBatchSize)
+		if err != nil {
+			log.Printf("Ошибка получения напоминаний: %v", err)
+			return
+		}
+
+		sent := 0
+		for _, rem := range reminders {
+			body := fmt.Sprintf("Заметка «%s»: %s", rem.NoteTitle, rem.Text)
+			if rem.DueAt != nil {
+				body += fmt.Sprintf("\nСрок: %s", rem.DueAt.Format(time.RFC3339))
+			}
+
+			err := s.notifier.Notify(ctx, &notify.Notification{
+				Event:    "checklist.reminder",
+				UserID:   rem.UserID,
+				Username: rem.Username,
+				Email:    rem.Email,
+				Subject:  "Напоминание: " + rem.Text,
+				Body:     body,
+				Data:     rem,
+			})
+			if err != nil {
+				// Напоминание останется неотправленным и будет повторено на следующем проходе
+				log.Printf("Не удалось отправить напоминание по пункту %d: %v", rem.ItemID, err)
+				continue
+			}
+
+			if err := s.itemRepo.MarkReminded(rem.ItemID, now); err != nil {
+				log.Printf("Не удалось отметить напоминание по пункту %d: %v", rem.ItemID, err)
+				continue
+			}
+			sent++
+		}
+
+		// Продолжаем, только если вся пачка была отправлена и могут остаться ещё напоминания
+		if len(reminders) < reminderBatchSize || sent < len(reminders) {
+			return
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"unicode/utf8"
+)
+
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 50
+)
+
+// Латиница, цифры, точка, подчёркивание и дефис; первый символ - буква или цифра
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ValidationError перечисляет поля запроса, которые не прошли проверку
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("некорректные данные: %v", e.Fields)
+}
+
+// validationErrors собирает ошибки по полям и возвращает nil, если ошибок нет
+type validationErrors map[string]string
+
+func (v validationErrors) add(field, message string) {
+	if message != "" {
+		v[field] = message
+	}
+}
+
+func (v validationErrors) err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: v}
+}
+
+func validateUsername(username string) string {
+	n := utf8.RuneCountInString(username)
+	switch {
+	case n < minUsernameLength || n > maxUsernameLength:
+		return fmt.Sprintf("имя пользователя должно быть от %d до %d символов", minUsernameLength, maxUsernameLength)
+	case !usernamePattern.MatchString(username):
+		return "имя пользователя может содержать только латинские буквы, цифры, точку, дефис и подчёркивание и должно начинаться с буквы или цифры"
+	}
+	return ""
+}
+
+// validateEmail проверяет, что строка - один адрес без имени ("user@example.com")
+func validateEmail(email string) string {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return "некорректный email"
+	}
+	return ""
+}
@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"notes-api/internal/config"
+)
+
+// ErrNotFound возвращается, если объекта с таким ключом нет
+var ErrNotFound = errors.New("объект не найден")
+
+// BlobStore хранит содержимое файлов по ключу. Метаданные хранятся отдельно, в Postgres
+type BlobStore interface {
+	// Put сохраняет size байт из r под ключом key
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Open открывает объект для чтения. Поддержка Seek нужна для отдачи диапазонов (Range)
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Delete удаляет объект; отсутствие объекта ошибкой не считается
+	Delete(ctx context.Context, key string) error
+}
+
+// New создает BlobStore по настройкам: local или s3
+func New(cfg config.StorageConfig) (BlobStore, error) {
+	switch cfg.Type {
+	case "", "local":
+		return NewLocalStore(cfg.LocalDir)
+	case "s3":
+		return NewS3Store(cfg.S3)
+	default:
+		return nil, fmt.Errorf("неизвестный тип хранилища: %s", cfg.Type)
+	}
+}
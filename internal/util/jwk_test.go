@@ -0,0 +1,145 @@
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestJWKThumbprint(t *testing.T) {
+	// Примеры из RFC 7638 (раздел 3.1) и RFC 8037 (приложение A.3)
+	tests := []struct {
+		name string
+		key  JWK
+		want string
+	}{
+		{
+			name: "RSA из RFC 7638",
+			key: JWK{
+				Kty: "RSA",
+				N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+				E:   "AQAB",
+				// Необязательные поля в отпечаток не входят
+				Alg: "RS256",
+				Kid: "2011-04-29",
+			},
+			want: "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs",
+		},
+		{
+			name: "Ed25519 из RFC 8037",
+			key:  JWK{Kty: "OKP", Crv: "Ed25519", X: "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"},
+			want: "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.key.Thumbprint(); got != tt.want {
+				t.Errorf("Thumbprint() = %s, ожидалось %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewJWK(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		pub     interface{}
+		wantKty string
+		wantAlg string
+		wantErr bool
+	}{
+		{name: "RSA", pub: &rsaKey.PublicKey, wantKty: "RSA", wantAlg: "RS256"},
+		{name: "Ed25519", pub: edPub, wantKty: "OKP", wantAlg: "EdDSA"},
+		{name: "ECDSA не подписывает токены", pub: &ecKey.PublicKey, wantErr: true},
+		{name: "закрытый ключ вместо открытого", pub: rsaKey, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jwk, err := NewJWK(tt.pub)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("NewJWK() = %+v, ожидалась ошибка", jwk)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if jwk.Kty != tt.wantKty || jwk.Alg != tt.wantAlg || jwk.Use != "sig" {
+				t.Errorf("NewJWK() = %+v", jwk)
+			}
+			if jwk.Kid != jwk.Thumbprint() {
+				t.Errorf("kid %s не совпадает с отпечатком %s", jwk.Kid, jwk.Thumbprint())
+			}
+			pub, err := jwk.PublicKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(pub, tt.pub) {
+				t.Errorf("PublicKey() = %v, ожидался исходный ключ", pub)
+			}
+		})
+	}
+}
+
+func TestJWKPublicKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	ecX, ecY := b64(ecKey.X.Bytes()), b64(ecKey.Y.Bytes())
+	hugeExponent := b64(new(big.Int).Lsh(big.NewInt(1), 40).Bytes())
+
+	tests := []struct {
+		name    string
+		key     JWK
+		wantErr bool
+	}{
+		{name: "EC P-256", key: JWK{Kty: "EC", Crv: "P-256", X: ecX, Y: ecY}},
+		{name: "EC точка не на кривой", key: JWK{Kty: "EC", Crv: "P-256", X: ecX, Y: ecX}, wantErr: true},
+		{name: "EC неизвестная кривая", key: JWK{Kty: "EC", Crv: "secp256k1", X: ecX, Y: ecY}, wantErr: true},
+		{name: "RSA пустой модуль", key: JWK{Kty: "RSA", N: "", E: "AQAB"}, wantErr: true},
+		{name: "RSA слишком большая экспонента", key: JWK{Kty: "RSA", N: "AQAB", E: hugeExponent}, wantErr: true},
+		{name: "RSA модуль не в base64url", key: JWK{Kty: "RSA", N: "не base64", E: "AQAB"}, wantErr: true},
+		{name: "OKP неверная длина", key: JWK{Kty: "OKP", Crv: "Ed25519", X: b64([]byte("short"))}, wantErr: true},
+		{name: "OKP другая кривая", key: JWK{Kty: "OKP", Crv: "X25519", X: b64(make([]byte, 32))}, wantErr: true},
+		{name: "неизвестный тип", key: JWK{Kty: "oct"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub, err := tt.key.PublicKey()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("PublicKey() = %v, ожидалась ошибка", pub)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ecKey.PublicKey.Equal(pub) {
+				t.Errorf("PublicKey() = %v, ожидался исходный ключ", pub)
+			}
+		})
+	}
+}
@@ -1,56 +1,306 @@
 package util
 
 import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"log"
+	"notes-api/internal/config"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtSecret = []byte("your-strong-secret-key")
+// TokenClaims - данные пользователя из JWT
+type TokenClaims struct {
+	UserID int64
+	// TokenVersion сравнивается с users.token_version: после смены пароля старые токены не принимаются
+	TokenVersion int
+	// ID - уникальный идентификатор токена (jti)
+	ID string
+	// Role - роль пользователя на момент выдачи токена. При смене роли версия токенов увеличивается
+	Role string
+}
+
+// SignedToken - выданный JWT с его идентификатором (jti) и сроком действия
+type SignedToken struct {
+	Token     string
+	ID        string
+	ExpiresAt time.Time
+}
+
+// Время жизни токена второго шага входа
+const challengeTTL = 5 * time.Minute
+
+// challengePurpose помечает токен второго шага входа: он не даёт доступа к API
+const challengePurpose = "2fa"
+
+// Допустимое расхождение часов между сервисами, проверяющими токены
+const jwtLeeway = 30 * time.Second
+
+// verificationKey - открытый ключ, которым проверяются токены с заданным kid
+type verificationKey struct {
+	key    crypto.PublicKey
+	method jwt.SigningMethod
+	jwk    JWK
+}
+
+// JWTManager подписывает токены доступа ключом RS256 или EdDSA и проверяет их всеми
+// действующими ключами. Другие сервисы могут проверять токены по открытым ключам из JWKS
+type JWTManager struct {
+	signingKey crypto.Signer
+	signingKid string
+	method     jwt.SigningMethod
+	keys       map[string]*verificationKey
+	issuer     string
+	audience   string
+	ttl        time.Duration
+}
+
+// NewJWTManager загружает ключи из настроек
+func NewJWTManager(cfg config.JWTConfig) (*JWTManager, error) {
+	m := &JWTManager{
+		keys:     make(map[string]*verificationKey),
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+		ttl:      cfg.TTL,
+	}
+
+	var signer crypto.Signer
+	if cfg.SigningKeyFile == "" {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		log.Println("JWT_SIGNING_KEY_FILE не задан: токены подписываются временным ключом и перестанут действовать после перезапуска")
+		signer = key
+	} else {
+		key, err := loadPEMKey(cfg.SigningKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if signer, ok = key.(crypto.Signer); !ok {
+			return nil, fmt.Errorf("%s: для подписи нужен закрытый ключ", cfg.SigningKeyFile)
+		}
+	}
+
+	vk, err := m.addKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	m.signingKey, m.signingKid, m.method = signer, vk.jwk.Kid, vk.method
+
+	for _, file := range cfg.PreviousKeyFiles {
+		key, err := loadPEMKey(strings.TrimSpace(file))
+		if err != nil {
+			return nil, err
+		}
+		if signer, ok := key.(crypto.Signer); ok {
+			key = signer.Public()
+		}
+		if _, err := m.addKey(key); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return m, nil
+}
+
+func (m *JWTManager) addKey(pub crypto.PublicKey) (*verificationKey, error) {
+	jwk, err := NewJWK(pub)
+	if err != nil {
+		return nil, err
+	}
+	vk := &verificationKey{key: pub, jwk: jwk}
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		vk.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		vk.method = jwt.SigningMethodEdDSA
+	}
+	m.keys[jwk.Kid] = vk
+	return vk, nil
+}
+
+// JWKS возвращает открытые ключи для /.well-known/jwks.json: ключ подписи и предыдущие
+func (m *JWTManager) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{m.keys[m.signingKid].jwk}}
+	for kid, vk := range m.keys {
+		if kid != m.signingKid {
+			set.Keys = append(set.Keys, vk.jwk)
+		}
+	}
+	return set
+}
+
+// GenerateJWT выдаёт токен доступа. По jti токен привязывается к сеансу
+func (m *JWTManager) GenerateJWT(userID int64, tokenVersion int, role string) (*SignedToken, error) {
+	return m.sign(userID, tokenVersion, m.ttl, jwt.MapClaims{"role": role})
+}
 
-func GenerateJWT(userID int64) (string, error) {
+// GenerateChallengeToken выдаёт короткоживущий токен, который обменивается на JWT после проверки кода 2FA
+func (m *JWTManager) GenerateChallengeToken(userID int64, tokenVersion int) (string, error) {
+	signed, err := m.sign(userID, tokenVersion, challengeTTL, jwt.MapClaims{"purpose": challengePurpose})
+	if err != nil {
+		return "", err
+	}
+	return signed.Token, nil
+}
+
+func (m *JWTManager) sign(userID int64, tokenVersion int, ttl time.Duration, extra jwt.MapClaims) (*SignedToken, error) {
+	jtiBytes := make([]byte, 16)
+	if _, err := rand.Read(jtiBytes); err != nil {
+		return nil, err
+	}
+	jti := base64.RawURLEncoding.EncodeToString(jtiBytes)
+	now := time.Now()
+	expiresAt := now.Add(ttl)
 	claims := jwt.MapClaims{
+		"iss":     m.issuer,
+		"aud":     m.audience,
+		"sub":     strconv.FormatInt(userID, 10),
 		"user_id": userID,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
+		"ver":     tokenVersion,
+		"iat":     now.Unix(),
+		"exp":     expiresAt.Unix(),
+		"jti":     jti,
+	}
+	for k, v := range extra {
+		claims[k] = v
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	token := jwt.NewWithClaims(m.method, claims)
+	token.Header["kid"] = m.signingKid
+	signed, err := token.SignedString(m.signingKey)
+	if err != nil {
+		return nil, err
+	}
+	return &SignedToken{Token: signed, ID: jti, ExpiresAt: expiresAt}, nil
 }
 
-func ParseJWT(authHeader string) (int64, error) {
-	log.Printf("Попытка разбора токена: %s", authHeader)
+// ParseChallengeToken проверяет токен второго шага входа
+func (m *JWTManager) ParseChallengeToken(tokenString string) (*TokenClaims, error) {
+	claims, err := m.parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims["purpose"] != challengePurpose {
+		return nil, fmt.Errorf("невалидный токен")
+	}
+	return tokenClaims(claims)
+}
 
+// ParseJWT проверяет заголовок "Bearer <token>": подпись ключом с указанным kid, iss, aud, iat, exp и jti
+func (m *JWTManager) ParseJWT(authHeader string) (*TokenClaims, error) {
 	// 1. Разделяем строку по пробелу, чтобы отделить "Bearer" от токена
 	parts := strings.SplitN(authHeader, " ", 2)
 	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-		return 0, fmt.Errorf("неверный формат заголовка авторизации")
+		return nil, fmt.Errorf("неверный формат заголовка авторизации")
 	}
 	tokenString := parts[1]
 
 	// 2. Парсим сам токен
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+	claims, err := m.parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	// 3. Токен второго шага входа не даёт доступа к API
+	if _, ok := claims["purpose"]; ok {
+		return nil, fmt.Errorf("невалидный токен")
+	}
+	return tokenClaims(claims)
+}
+
+func (m *JWTManager) parseToken(tokenString string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		vk, ok := m.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("неизвестный ключ подписи %q", kid)
+		}
+		// Алгоритм определяется ключом, а не заголовком токена
+		if token.Method.Alg() != vk.method.Alg() {
 			return nil, fmt.Errorf("неожиданный метод подписи: %v", token.Header["alg"])
 		}
-		return jwtSecret, nil
-	})
-
+		return vk.key, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithIssuer(m.issuer),
+		jwt.WithAudience(m.audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(jwtLeeway),
+	)
 	if err != nil {
 		log.Printf("Ошибка разбора токена: %v", err)
-		return 0, err
+		return nil, err
 	}
 
-	// 3. Проверяем, что токен валиден и извлекаем claims
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		// Claims["user_id"] приходит как float64, нужно конвертировать
-		if userIDFloat, ok := claims["user_id"].(float64); ok {
-			return int64(userIDFloat), nil
-		}
+	if _, ok := claims["iat"]; !ok {
+		return nil, fmt.Errorf("невалидный токен: нет iat")
+	}
+	if jti, _ := claims["jti"].(string); jti == "" {
+		return nil, fmt.Errorf("невалидный токен: нет jti")
+	}
+	return claims, nil
+}
+
+func tokenClaims(claims jwt.MapClaims) (*TokenClaims, error) {
+	// Claims["user_id"] приходит как float64, нужно конвертировать
+	if userIDFloat, ok := claims["user_id"].(float64); ok {
+		version, _ := claims["ver"].(float64)
+		jti, _ := claims["jti"].(string)
+		role, _ := claims["role"].(string)
+		return &TokenClaims{UserID: int64(userIDFloat), TokenVersion: int(version), ID: jti, Role: role}, nil
+	}
+	return nil, fmt.Errorf("невалидный токен")
+}
+
+// loadPEMKey читает закрытый (PKCS#8, PKCS#1) или открытый (PKIX) ключ из PEM-файла
+func loadPEMKey(path string) (interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: не найден PEM-блок", path)
 	}
 
-	return 0, fmt.Errorf("невалидный токен")
+	var key interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: неподдерживаемый тип PEM-блока %s", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return nil, errors.New(path + ": ключ RSA должен быть не короче 2048 бит")
+		}
+	case *rsa.PublicKey, ed25519.PrivateKey, ed25519.PublicKey:
+	default:
+		return nil, fmt.Errorf("%s: поддерживаются только ключи RSA и Ed25519", path)
+	}
+	return key, nil
 }
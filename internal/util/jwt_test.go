@@ -0,0 +1,430 @@
+package util
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"notes-api/internal/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testIssuer   = "notes-api"
+	testAudience = "notes-api-clients"
+)
+
+// testKeys - ключ подписи RSA и предыдущий ключ Ed25519, токены которого ещё принимаются
+type testKeys struct {
+	rsa      *rsa.PrivateKey
+	previous ed25519.PrivateKey
+	// other - посторонний ключ, которого менеджер не знает
+	other ed25519.PrivateKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, previous, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKeys{rsa: rsaKey, previous: previous, other: other}
+}
+
+// writePEMKey сохраняет закрытый ключ в PKCS#8 во временный файл и возвращает путь к нему
+func writePEMKey(t *testing.T, key crypto.Signer) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writePEMPublicKey сохраняет открытый ключ в PKIX во временный файл и возвращает путь к нему
+func writePEMPublicKey(t *testing.T, key crypto.PublicKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.pub.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestManager(t *testing.T, keys testKeys) *JWTManager {
+	t.Helper()
+	m, err := NewJWTManager(config.JWTConfig{
+		SigningKeyFile:   writePEMKey(t, keys.rsa),
+		PreviousKeyFiles: []string{writePEMPublicKey(t, keys.previous.Public())},
+		Issuer:           testIssuer,
+		Audience:         testAudience,
+		TTL:              time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func kidOf(t *testing.T, pub crypto.PublicKey) string {
+	t.Helper()
+	jwk, err := NewJWK(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jwk.Kid
+}
+
+// validClaims возвращает утверждения, которые менеджер принимает
+func validClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":     testIssuer,
+		"aud":     testAudience,
+		"sub":     "42",
+		"user_id": 42,
+		"ver":     3,
+		"iat":     now.Unix(),
+		"exp":     now.Add(time.Hour).Unix(),
+		"jti":     "test-jti",
+		"role":    "user",
+	}
+}
+
+// forgeToken подписывает claims методом method ключом key с заголовком kid (пустой kid не ставится)
+func forgeToken(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestParseJWT(t *testing.T) {
+	keys := newTestKeys(t)
+	m := newTestManager(t, keys)
+	rsaKid := m.signingKid
+	previousKid := kidOf(t, keys.previous.Public())
+
+	issued, err := m.GenerateJWT(42, 3, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := m.GenerateChallengeToken(42, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// with возвращает validClaims с изменениями change
+	with := func(change func(jwt.MapClaims)) jwt.MapClaims {
+		claims := validClaims()
+		change(claims)
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		header  string
+		want    *TokenClaims
+		wantErr bool
+	}{
+		{
+			name:   "выданный токен",
+			header: "Bearer " + issued.Token,
+			want:   &TokenClaims{UserID: 42, TokenVersion: 3, ID: issued.ID, Role: "admin"},
+		},
+		{
+			name:   "схема в нижнем регистре",
+			header: "bearer " + issued.Token,
+			want:   &TokenClaims{UserID: 42, TokenVersion: 3, ID: issued.ID, Role: "admin"},
+		},
+		{
+			name:   "предыдущий ключ выбирается по kid",
+			header: "Bearer " + forgeToken(t, jwt.SigningMethodEdDSA, keys.previous, previousKid, validClaims()),
+			want:   &TokenClaims{UserID: 42, TokenVersion: 3, ID: "test-jti", Role: "user"},
+		},
+		{
+			name:    "неизвестный kid",
+			header:  "Bearer " + forgeToken(t, jwt.SigningMethodEdDSA, keys.other, kidOf(t, keys.other.Public()), validClaims()),
+			wantErr: true,
+		},
+		{
+			name:    "нет kid",
+			header:  "Bearer " + forgeToken(t, jwt.SigningMethodRS256, keys.rsa, "", validClaims()),
+			wantErr: true,
+		},
+		{
+			name:    "чужой ключ под известным kid",
+			header:  "Bearer " + forgeToken(t, jwt.SigningMethodEdDSA, keys.other, previousKid, validClaims()),
+			wantErr: true,
+		},
+		{
+			name:    "алгоритм не совпадает с ключом kid",
+			header:  "Bearer " + forgeToken(t, jwt.SigningMethodEdDSA, keys.previous, rsaKid, validClaims()),
+			wantErr: true,
+		},
+		{
+			name:    "HS256 с открытым ключом как секретом",
+			header:  "Bearer " + forgeToken(t, jwt.SigningMethodHS256, keys.rsa.PublicKey.N.Bytes(), rsaKid, validClaims()),
+			wantErr: true,
+		},
+		{
+			name:    "alg none",
+			header:  "Bearer " + forgeToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, rsaKid, validClaims()),
+			wantErr: true,
+		},
+		{
+			name:    "другой iss",
+			header:  "Bearer " + forgeToken(t, jwt.SigningMethodRS256, keys.rsa, rsaKid, with(func(c jwt.MapClaims) { c["iss"] = "other" })),
+			wantErr: true,
+		},
+		{
+			name:    "нет iss",
+			header:  "Bearer " + forgeToken(t, jwt.SigningMethodRS256, keys.rsa, rsaKid, with(func(c jwt.MapClaims) { delete(c, "iss") })),
+			wantErr: true,
+		},
+		{
+			name:    "другой aud",
+			header:  "Bearer " + forgeToken(t, jwt.SigningMethodRS256, keys.rsa, rsaKid, with(func(c jwt.MapClaims) { c["aud"] = "other" })),
+			wantErr: true,
+		},
+		{
+			name:   "aud списком",
+			header: "Bearer " + forgeToken(t, jwt.SigningMethodRS256, keys.rsa, rsaKid, with(func(c jwt.MapClaims) { c["aud"] = []string{"other", testAudience} })),
+			want:   &TokenClaims{UserID: 42, TokenVersion: 3, ID: "test-jti", Role: "user"},
+		},
+		{
+			name:    "нет iat",
+			header:  "Bearer " + forgeToken(t, jwt.SigningMethodRS256, keys.rsa, rsaKid, with(func(c jwt.MapClaims) { delete(c, "iat") })),
+			wantErr: true,
+		},
+		{
+			name:    "iat в будущем",
+			header:  "Bearer " + forgeToken(t, jwt.SigningMethodRS256, keys.rsa, rsaKid, with(func(c jwt.MapClaims) { c["iat"] = time.Now().Add(time.Hour).Unix() })),
+			wantErr: true,
+		},
+		{
+			name:   "iat в пределах допуска часов",
+			header: "Bearer " + forgeToken(t, jwt.SigningMethodRS256, keys.rsa, rsaKid, with(func(c jwt.MapClaims) { c["iat"] = time.Now().Add(jwtLeeway / 2).Unix() })),
+			want:   &TokenClaims{UserID: 42, TokenVersion: 3, ID: "test-jti", Role: "user"},
+		},
+		{
+			name:    "нет exp",
+			header:  "Bearer " + forgeToken(t, jwt.SigningMethodRS256, keys.rsa, rsaKid, with(func(c jwt.MapClaims) { delete(c, "exp") })),
+			wantErr: true,
+		},
+		{
+			name:    "истёк",
+			header:  "Bearer " + forgeToken(t, jwt.SigningMethodRS256, keys.rsa, rsaKid, with(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() })),
+			wantErr: true,
+		},
+		{
+			name:    "нет jti",
+			header:  "Bearer " + forgeToken(t, jwt.SigningMethodRS256, keys.rsa, rsaKid, with(func(c jwt.MapClaims) { delete(c, "jti") })),
+			wantErr: true,
+		},
+		{
+			name:    "пустой jti",
+			header:  "Bearer " + forgeToken(t, jwt.SigningMethodRS256, keys.rsa, rsaKid, with(func(c jwt.MapClaims) { c["jti"] = "" })),
+			wantErr: true,
+		},
+		{
+			name:    "нет user_id",
+			header:  "Bearer " + forgeToken(t, jwt.SigningMethodRS256, keys.rsa, rsaKid, with(func(c jwt.MapClaims) { delete(c, "user_id") })),
+			wantErr: true,
+		},
+		{
+			name:    "токен второго шага входа",
+			header:  "Bearer " + challenge,
+			wantErr: true,
+		},
+		{
+			name:    "любое значение purpose",
+			header:  "Bearer " + forgeToken(t, jwt.SigningMethodRS256, keys.rsa, rsaKid, with(func(c jwt.MapClaims) { c["purpose"] = "other" })),
+			wantErr: true,
+		},
+		{
+			name:    "другая схема авторизации",
+			header:  "Basic " + issued.Token,
+			wantErr: true,
+		},
+		{
+			name:    "нет токена",
+			header:  "Bearer",
+			wantErr: true,
+		},
+		{
+			name:    "испорченная подпись",
+			header:  "Bearer " + issued.Token[:strings.LastIndex(issued.Token, ".")+1] + "AAAA",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.ParseJWT(tt.header)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseJWT() = %+v, ожидалась ошибка", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseJWT() ошибка: %v", err)
+			}
+			if *got != *tt.want {
+				t.Errorf("ParseJWT() = %+v, ожидалось %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseChallengeToken(t *testing.T) {
+	keys := newTestKeys(t)
+	m := newTestManager(t, keys)
+
+	challenge, err := m.GenerateChallengeToken(7, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	access, err := m.GenerateJWT(7, 2, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPurpose := validClaims()
+	otherPurpose["purpose"] = "reset"
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{name: "токен второго шага", token: challenge},
+		{name: "токен доступа", token: access.Token, wantErr: true},
+		{name: "другое значение purpose", token: forgeToken(t, jwt.SigningMethodRS256, keys.rsa, m.signingKid, otherPurpose), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.ParseChallengeToken(tt.token)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseChallengeToken() = %+v, ожидалась ошибка", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseChallengeToken() ошибка: %v", err)
+			}
+			if got.UserID != 7 || got.TokenVersion != 2 {
+				t.Errorf("ParseChallengeToken() = %+v", got)
+			}
+		})
+	}
+}
+
+func TestGenerateJWTUsesSigningKey(t *testing.T) {
+	keys := newTestKeys(t)
+	m := newTestManager(t, keys)
+
+	issued, err := m.GenerateJWT(1, 1, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := jwt.NewParser().ParseUnverified(issued.Token, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := token.Header["kid"]; kid != kidOf(t, &keys.rsa.PublicKey) {
+		t.Errorf("kid = %v, ожидался отпечаток ключа подписи", kid)
+	}
+	if token.Method.Alg() != "RS256" {
+		t.Errorf("alg = %s, ожидался RS256", token.Method.Alg())
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	keys := newTestKeys(t)
+	m := newTestManager(t, keys)
+
+	set := m.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("в JWKS %d ключей, ожидалось 2", len(set.Keys))
+	}
+	tests := []struct {
+		name    string
+		key     JWK
+		wantKid string
+		wantAlg string
+	}{
+		{name: "первым идёт ключ подписи", key: set.Keys[0], wantKid: kidOf(t, &keys.rsa.PublicKey), wantAlg: "RS256"},
+		{name: "предыдущий ключ", key: set.Keys[1], wantKid: kidOf(t, keys.previous.Public()), wantAlg: "EdDSA"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.key.Kid != tt.wantKid || tt.key.Alg != tt.wantAlg || tt.key.Use != "sig" {
+				t.Errorf("ключ %+v, ожидались kid %s и alg %s", tt.key, tt.wantKid, tt.wantAlg)
+			}
+		})
+	}
+}
+
+func TestNewJWTManagerRejectsKeys(t *testing.T) {
+	keys := newTestKeys(t)
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  config.JWTConfig
+	}{
+		{name: "ключ подписи открытый", cfg: config.JWTConfig{SigningKeyFile: writePEMPublicKey(t, keys.previous.Public())}},
+		{name: "RSA короче 2048 бит", cfg: config.JWTConfig{SigningKeyFile: writePEMKey(t, weak)}},
+		{name: "нет файла", cfg: config.JWTConfig{SigningKeyFile: filepath.Join(t.TempDir(), "missing.pem")}},
+		{
+			name: "предыдущий ключ не найден",
+			cfg: config.JWTConfig{
+				SigningKeyFile:   writePEMKey(t, keys.rsa),
+				PreviousKeyFiles: []string{filepath.Join(t.TempDir(), "missing.pem")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewJWTManager(tt.cfg); err == nil {
+				t.Error("NewJWTManager() без ошибки")
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Поддерживаемые значения FREQ
+const (
+	FreqDaily   = "DAILY"
+	FreqWeekly  = "WEEKLY"
+	FreqMonthly = "MONTHLY"
+)
+
+var weekdayCodes = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// RRule - подмножество правил повторения RFC 5545:
+// FREQ=DAILY|WEEKLY|MONTHLY, INTERVAL, BYDAY (для WEEKLY) и BYMONTHDAY (для MONTHLY).
+// Следующее повторение всегда отсчитывается от предыдущего, поэтому DTSTART не нужен.
+type RRule struct {
+	Freq     string
+	Interval int
+	// ByDay - дни недели для WEEKLY
+	ByDay []time.Weekday
+	// ByMonthDay - день месяца для MONTHLY, -1 означает последний день.
+	// Если в месяце нет такого дня, используется последний день месяца.
+	ByMonthDay int
+}
+
+// ParseRRule разбирает строку вида "FREQ=WEEKLY;BYDAY=MO,WE,FR" (префикс "RRULE:" допускается)
+func ParseRRule(s string) (*RRule, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	if s == "" {
+		return nil, fmt.Errorf("пустое правило повторения")
+	}
+
+	rule := &RRule{Interval: 1}
+	for _, part := range strings.Split(s, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("некорректная часть правила: %q", part)
+		}
+		key, value = strings.ToUpper(strings.TrimSpace(key)), strings.ToUpper(strings.TrimSpace(value))
+
+		switch key {
+		case "FREQ":
+			if value != FreqDaily && value != FreqWeekly && value != FreqMonthly {
+				return nil, fmt.Errorf("поддерживаются только FREQ=DAILY, WEEKLY и MONTHLY")
+			}
+			rule.Freq = value
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > 366 {
+				return nil, fmt.Errorf("некорректный INTERVAL: %s", value)
+			}
+			rule.Interval = n
+		case "BYDAY":
+			seen := make(map[time.Weekday]bool)
+			for _, code := range strings.Split(value, ",") {
+				day, ok := weekdayCodes[code]
+				if !ok {
+					return nil, fmt.Errorf("некорректный день недели в BYDAY: %s", code)
+				}
+				if !seen[day] {
+					seen[day] = true
+					rule.ByDay = append(rule.ByDay, day)
+				}
+			}
+		case "BYMONTHDAY":
+			n, err := strconv.Atoi(value)
+			if err != nil || n == 0 || n < -1 || n > 31 {
+				return nil, fmt.Errorf("некорректный BYMONTHDAY: %s", value)
+			}
+			rule.ByMonthDay = n
+		default:
+			return nil, fmt.Errorf("параметр %s не поддерживается", key)
+		}
+	}
+
+	if rule.Freq == "" {
+		return nil, fmt.Errorf("в правиле не указан FREQ")
+	}
+	if len(rule.ByDay) > 0 && rule.Freq != FreqWeekly {
+		return nil, fmt.Errorf("BYDAY поддерживается только для FREQ=WEEKLY")
+	}
+	if rule.ByMonthDay != 0 && rule.Freq != FreqMonthly {
+		return nil, fmt.Errorf("BYMONTHDAY поддерживается только для FREQ=MONTHLY")
+	}
+	return rule, nil
+}
+
+// Anchor фиксирует день месяца для MONTHLY без BYMONTHDAY по первому повторению.
+// Иначе после короткого месяца (31 -> 29 февраля) день бы "съезжал".
+func (r *RRule) Anchor(first time.Time) {
+	if r.Freq == FreqMonthly && r.ByMonthDay == 0 {
+		r.ByMonthDay = first.Day()
+	}
+}
+
+// String возвращает правило в каноническом виде
+func (r *RRule) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		codes := make([]string, 0, len(r.ByDay))
+		for _, code := range []string{"MO", "TU", "WE", "TH", "FR", "SA", "SU"} {
+			if r.hasDay(weekdayCodes[code]) {
+				codes = append(codes, code)
+			}
+		}
+		parts = append(parts, "BYDAY="+strings.Join(codes, ","))
+	}
+	if r.ByMonthDay != 0 {
+		parts = append(parts, "BYMONTHDAY="+strconv.Itoa(r.ByMonthDay))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Next возвращает первое повторение строго после after, сохраняя время суток
+func (r *RRule) Next(after time.Time) time.Time {
+	switch r.Freq {
+	case FreqDaily:
+		return after.AddDate(0, 0, r.Interval)
+	case FreqWeekly:
+		if len(r.ByDay) == 0 {
+			return after.AddDate(0, 0, 7*r.Interval)
+		}
+		for d := 1; d <= 7; d++ {
+			candidate := after.AddDate(0, 0, d)
+			if !r.hasDay(candidate.Weekday()) {
+				continue
+			}
+			// При переходе на следующую неделю пропускаем INTERVAL-1 недель
+			if weekStart(candidate).After(weekStart(after)) {
+				candidate = candidate.AddDate(0, 0, 7*(r.Interval-1))
+			}
+			return candidate
+		}
+		return after.AddDate(0, 0, 7*r.Interval)
+	case FreqMonthly:
+		day := r.ByMonthDay
+		if day == 0 {
+			day = after.Day()
+		}
+		if candidate := monthDay(after, 0, day); candidate.After(after) {
+			return candidate
+		}
+		return monthDay(after, r.Interval, day)
+	}
+	return after
+}
+
+// NextAfter возвращает первое повторение после from, которое наступает позже now.
+// Нужен, чтобы после долгого перерыва не создавать пропущенные повторения.
+func (r *RRule) NextAfter(from, now time.Time) time.Time {
+	next := r.Next(from)
+	for i := 0; !next.After(now) && i < 10000; i++ {
+		next = r.Next(next)
+	}
+	return next
+}
+
+func (r *RRule) hasDay(day time.Weekday) bool {
+	for _, d := range r.ByDay {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// weekStart возвращает начало недели (понедельник) для t
+func weekStart(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7
+	y, m, d := t.AddDate(0, 0, -offset).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// monthDay возвращает день day месяца, отстоящего от t на months, с временем суток из t
+func monthDay(t time.Time, months, day int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	last := first.AddDate(0, 1, -1).Day()
+	if day == -1 || day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
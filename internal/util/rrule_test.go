@@ -0,0 +1,179 @@
+package util
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseRRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{name: "ежедневно", input: "FREQ=DAILY", want: "FREQ=DAILY"},
+		{name: "префикс RRULE и регистр", input: " RRULE:freq=weekly;byday=fr,mo,fr ", want: "FREQ=WEEKLY;BYDAY=MO,FR"},
+		{name: "INTERVAL=1 не выводится", input: "FREQ=DAILY;INTERVAL=1", want: "FREQ=DAILY"},
+		{name: "интервал", input: "FREQ=MONTHLY;INTERVAL=3;BYMONTHDAY=-1", want: "FREQ=MONTHLY;INTERVAL=3;BYMONTHDAY=-1"},
+		{name: "пустое правило", input: "", wantErr: true},
+		{name: "нет FREQ", input: "INTERVAL=2", wantErr: true},
+		{name: "YEARLY не поддерживается", input: "FREQ=YEARLY", wantErr: true},
+		{name: "часть без =", input: "FREQ=DAILY;COUNT", wantErr: true},
+		{name: "неизвестный параметр", input: "FREQ=DAILY;COUNT=5", wantErr: true},
+		{name: "INTERVAL=0", input: "FREQ=DAILY;INTERVAL=0", wantErr: true},
+		{name: "INTERVAL больше года", input: "FREQ=DAILY;INTERVAL=367", wantErr: true},
+		{name: "неверный день недели", input: "FREQ=WEEKLY;BYDAY=XX", wantErr: true},
+		{name: "BYDAY с номером недели", input: "FREQ=WEEKLY;BYDAY=1MO", wantErr: true},
+		{name: "BYDAY не для WEEKLY", input: "FREQ=DAILY;BYDAY=MO", wantErr: true},
+		{name: "BYMONTHDAY=0", input: "FREQ=MONTHLY;BYMONTHDAY=0", wantErr: true},
+		{name: "BYMONTHDAY=-2", input: "FREQ=MONTHLY;BYMONTHDAY=-2", wantErr: true},
+		{name: "BYMONTHDAY=32", input: "FREQ=MONTHLY;BYMONTHDAY=32", wantErr: true},
+		{name: "BYMONTHDAY не для MONTHLY", input: "FREQ=WEEKLY;BYMONTHDAY=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := ParseRRule(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseRRule(%q) = %s, ожидалась ошибка", tt.input, rule)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rule.String(); got != tt.want {
+				t.Errorf("ParseRRule(%q).String() = %s, ожидалось %s", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRRuleNext(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 9, 30, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name  string
+		rule  string
+		after time.Time
+		want  time.Time
+	}{
+		{name: "каждые два дня", rule: "FREQ=DAILY;INTERVAL=2", after: date(2024, 1, 1), want: date(2024, 1, 3)},
+		{name: "еженедельно без BYDAY", rule: "FREQ=WEEKLY", after: date(2024, 1, 1), want: date(2024, 1, 8)},
+		{name: "BYDAY в той же неделе", rule: "FREQ=WEEKLY;BYDAY=MO,WE,FR", after: date(2024, 1, 1), want: date(2024, 1, 3)},
+		{name: "BYDAY на следующей неделе", rule: "FREQ=WEEKLY;BYDAY=MO,WE,FR", after: date(2024, 1, 5), want: date(2024, 1, 8)},
+		{name: "раз в две недели в той же неделе", rule: "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR", after: date(2024, 1, 1), want: date(2024, 1, 5)},
+		{name: "раз в две недели через неделю", rule: "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR", after: date(2024, 1, 5), want: date(2024, 1, 15)},
+		{name: "день месяца ещё впереди", rule: "FREQ=MONTHLY;BYMONTHDAY=15", after: date(2024, 1, 10), want: date(2024, 1, 15)},
+		{name: "31 число в феврале високосного года", rule: "FREQ=MONTHLY;BYMONTHDAY=31", after: date(2024, 1, 31), want: date(2024, 2, 29)},
+		{name: "31 число после февраля", rule: "FREQ=MONTHLY;BYMONTHDAY=31", after: date(2024, 2, 29), want: date(2024, 3, 31)},
+		{name: "последний день месяца", rule: "FREQ=MONTHLY;BYMONTHDAY=-1", after: date(2023, 2, 28), want: date(2023, 3, 31)},
+		{name: "раз в квартал", rule: "FREQ=MONTHLY;INTERVAL=3;BYMONTHDAY=1", after: date(2024, 1, 1), want: date(2024, 4, 1)},
+		{name: "через конец года", rule: "FREQ=MONTHLY;BYMONTHDAY=5", after: date(2024, 12, 5), want: date(2025, 1, 5)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := ParseRRule(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rule.Next(tt.after); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, ожидалось %s", tt.after, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRRuleNextKeepsLocalTime(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule, err := ParseRRule("FREQ=DAILY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 31 марта 2024 года в Берлине переводили часы: сутки длились 23 часа
+	after := time.Date(2024, 3, 30, 9, 0, 0, 0, berlin)
+	want := time.Date(2024, 3, 31, 9, 0, 0, 0, berlin)
+	if got := rule.Next(after); !got.Equal(want) {
+		t.Errorf("Next(%s) = %s, ожидалось %s", after, got, want)
+	}
+}
+
+func TestRRuleAnchor(t *testing.T) {
+	first := time.Date(2024, 1, 31, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		rule string
+		want []time.Time
+	}{
+		{
+			name: "день месяца берётся из первого повторения",
+			rule: "FREQ=MONTHLY",
+			want: []time.Time{
+				time.Date(2024, 2, 29, 9, 0, 0, 0, time.UTC),
+				time.Date(2024, 3, 31, 9, 0, 0, 0, time.UTC),
+				time.Date(2024, 4, 30, 9, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "явный BYMONTHDAY не меняется",
+			rule: "FREQ=MONTHLY;BYMONTHDAY=1",
+			want: []time.Time{
+				time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC),
+				time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "не MONTHLY не меняется",
+			rule: "FREQ=DAILY",
+			want: []time.Time{
+				time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := ParseRRule(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rule.Anchor(first)
+			next := first
+			for _, want := range tt.want {
+				if next = rule.Next(next); !next.Equal(want) {
+					t.Fatalf("Next() = %s, ожидалось %s", next, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRRuleNextAfter(t *testing.T) {
+	rule, err := ParseRRule("FREQ=DAILY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		now  time.Time
+		want time.Time
+	}{
+		{name: "без пропусков", now: from, want: time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)},
+		{name: "пропущенные повторения не создаются", now: time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC), want: time.Date(2024, 1, 11, 9, 0, 0, 0, time.UTC)},
+		{name: "повторение ровно в now пропускается", now: time.Date(2024, 1, 5, 9, 0, 0, 0, time.UTC), want: time.Date(2024, 1, 6, 9, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rule.NextAfter(from, tt.now); !got.Equal(tt.want) {
+				t.Errorf("NextAfter() = %s, ожидалось %s", got, tt.want)
+			}
+		})
+	}
+}
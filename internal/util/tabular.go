@@ -0,0 +1,163 @@
+package util
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/text/encoding/charmap"
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// ReadCSV разбирает CSV, определяя кодировку (UTF-8 или Windows-1251)
+// и разделитель (запятая, точка с запятой или табуляция).
+// Excel с русской локалью сохраняет CSV именно в Windows-1251 через ';'.
+func ReadCSV(r io.Reader) ([][]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, utf8BOM)
+
+	if !utf8.Valid(data) {
+		data, err = charmap.Windows1251.NewDecoder().Bytes(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = detectDelimiter(data)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	return reader.ReadAll()
+}
+
+// detectDelimiter выбирает самый частый разделитель в первой строке,
+// не считая символов внутри кавычек
+func detectDelimiter(data []byte) rune {
+	counts := map[rune]int{',': 0, ';': 0, '\t': 0}
+	inQuotes := false
+	for _, ch := range string(data) {
+		if ch == '"' {
+			inQuotes = !inQuotes
+			continue
+		}
+		if inQuotes {
+			continue
+		}
+		if ch == '\n' || ch == '\r' {
+			break
+		}
+		if _, ok := counts[ch]; ok {
+			counts[ch]++
+		}
+	}
+
+	best := ','
+	for _, d := range []rune{';', '\t'} {
+		if counts[d] > counts[best] {
+			best = d
+		}
+	}
+	return best
+}
+
+// ReadXLSX читает первый лист книги Excel
+func ReadXLSX(r io.Reader) ([][]string, error) {
+	f, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sheets := f.GetSheetList()
+	if len(sheets) == 0 {
+		return nil, errors.New("файл не содержит листов")
+	}
+	return f.GetRows(sheets[0])
+}
+
+// WriteCSV записывает данные в UTF-8 с BOM, чтобы Excel корректно
+// открывал кириллицу. Значения, которые табличный редактор принял бы за формулу,
+// экранируются апострофом
+func WriteCSV(w io.Writer, records [][]string) error {
+	if _, err := w.Write(utf8BOM); err != nil {
+		return err
+	}
+	writer := csv.NewWriter(w)
+	for _, record := range records {
+		escaped := make([]string, len(record))
+		for i, value := range record {
+			escaped[i] = escapeFormula(value)
+		}
+		if err := writer.Write(escaped); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// escapeFormula добавляет апостроф перед значением, начинающимся с = + - @ (а также
+// табуляции или возврата каретки): иначе Excel выполнит его как формулу при открытии файла
+func escapeFormula(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// WriteXLSX записывает данные на единственный лист книги Excel
+func WriteXLSX(w io.Writer, sheetName string, records [][]string) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	sheet := sanitizeSheetName(sheetName)
+	defaultSheet := f.GetSheetName(0)
+	if sheet != defaultSheet {
+		if err := f.SetSheetName(defaultSheet, sheet); err != nil {
+			return err
+		}
+	}
+
+	for i, record := range records {
+		cell, err := excelize.CoordinatesToCellName(1, i+1)
+		if err != nil {
+			return err
+		}
+		values := make([]interface{}, len(record))
+		for j, v := range record {
+			values[j] = v
+		}
+		if err := f.SetSheetRow(sheet, cell, &values); err != nil {
+			return err
+		}
+	}
+
+	_, err := f.WriteTo(w)
+	return err
+}
+
+// sanitizeSheetName приводит имя к ограничениям Excel:
+// не длиннее 31 символа и без символов : \ / ? * [ ]
+func sanitizeSheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`:\/?*[]`, r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if name == "" {
+		return "Sheet1"
+	}
+	if runes := []rune(name); len(runes) > 31 {
+		name = string(runes[:31])
+	}
+	return name
+}
@@ -0,0 +1,35 @@
+package util
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteCSVEscapesFormulas(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "формула", value: "=SUM(A1:A2)", want: "'=SUM(A1:A2)"},
+		{name: "плюс", value: "+1", want: "'+1"},
+		{name: "минус", value: "-2+3", want: "'-2+3"},
+		{name: "собака", value: "@cmd", want: "'@cmd"},
+		{name: "табуляция", value: "\t=1", want: "'\t=1"},
+		{name: "обычный текст", value: "Заметка", want: "Заметка"},
+		{name: "знак не в начале", value: "a=b", want: "a=b"},
+		{name: "пустое значение", value: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteCSV(&buf, [][]string{{tt.value, "x"}}); err != nil {
+				t.Fatal(err)
+			}
+			want := string(utf8BOM) + tt.want + ",x\n"
+			if got := buf.String(); got != want {
+				t.Errorf("WriteCSV(%q) записал %q, ожидалось %q", tt.value, got, want)
+			}
+		})
+	}
+}
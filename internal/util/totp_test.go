@@ -0,0 +1,161 @@
+package util
+
+import (
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret - секрет "12345678901234567890" из тестовых векторов RFC 6238 в base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// Векторы SHA1 из приложения B RFC 6238: восьмизначные коды, у шестизначных те же последние цифры
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+	for _, tt := range tests {
+		t.Run(time.Unix(tt.unix, 0).UTC().Format(time.RFC3339), func(t *testing.T) {
+			got, err := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(tt.unix, 0)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("TOTPCode() = %s, ожидалось %s", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("секрет в нижнем регистре", func(t *testing.T) {
+		got, err := TOTPCode("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", TOTPStep(time.Unix(59, 0)))
+		if err != nil || got != "287082" {
+			t.Errorf("TOTPCode() = %s, %v", got, err)
+		}
+	})
+	t.Run("неверный секрет", func(t *testing.T) {
+		if _, err := TOTPCode("не base32!", 1); err == nil {
+			t.Error("TOTPCode() без ошибки")
+		}
+	})
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := TOTPStep(now)
+	code := func(step int64) string {
+		c, err := TOTPCode(rfc6238Secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{name: "текущий интервал", code: code(step), wantStep: step, wantOK: true},
+		{name: "предыдущий интервал", code: code(step - 1), wantStep: step - 1, wantOK: true},
+		{name: "следующий интервал", code: code(step + 1), wantStep: step + 1, wantOK: true},
+		{name: "два интервала назад", code: code(step - 2)},
+		{name: "два интервала вперёд", code: code(step + 2)},
+		{name: "код с пробелом", code: code(step)[:3] + " " + code(step)[3:], wantStep: step, wantOK: true},
+		{name: "неверная длина", code: code(step)[:5]},
+		{name: "код уже использован", code: code(step), lastStep: step},
+		{name: "использован более новый код", code: code(step - 1), lastStep: step},
+		{name: "после предыдущего интервала", code: code(step), lastStep: step - 1, wantStep: step, wantOK: true},
+		{name: "неверный код", code: "000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := ValidateTOTP(rfc6238Secret, tt.code, now, tt.lastStep)
+			if ok != tt.wantOK || gotStep != tt.wantStep {
+				t.Errorf("ValidateTOTP() = %d, %v, ожидалось %d, %v", gotStep, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("секрет %s не в base32: %v", secret, err)
+	}
+	if len(key) != 20 {
+		t.Errorf("длина секрета %d байт, ожидалось 20", len(key))
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri, err := url.Parse(TOTPURI("Notes API", "user@example.com", rfc6238Secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Notes API:user@example.com" {
+		t.Errorf("неверная ссылка %s", uri)
+	}
+	want := map[string]string{
+		"secret":    rfc6238Secret,
+		"issuer":    "Notes API",
+		"algorithm": "SHA1",
+		"digits":    "6",
+		"period":    "30",
+	}
+	for param, value := range want {
+		if got := uri.Query().Get(param); got != value {
+			t.Errorf("%s = %q, ожидалось %q", param, got, value)
+		}
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("получено %d кодов, ожидалось 10", len(codes))
+	}
+	format := regexp.MustCompile(`^[a-z2-7]{5}-[a-z2-7]{5}$`)
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("код %q не в формате xxxxx-xxxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("код %q повторяется", code)
+		}
+		seen[code] = true
+	}
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: "abcde-fghij", want: "abcdefghij"},
+		{input: "ABCDE-FGHIJ", want: "abcdefghij"},
+		{input: " abcde fghij ", want: "abcdefghij"},
+		{input: "abcdefghij", want: "abcdefghij"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeRecoveryCode(tt.input); got != tt.want {
+			t.Errorf("NormalizeRecoveryCode(%q) = %q, ожидалось %q", tt.input, got, tt.want)
+		}
+	}
+}
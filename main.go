@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"net/http"
 	"notes-api/internal/api/handler"
 	"notes-api/internal/config"
+	"notes-api/internal/model"
+	"notes-api/internal/notify"
+	"notes-api/internal/oidc"
 	"notes-api/internal/repository"
 	"notes-api/internal/service"
+	"notes-api/internal/storage"
+	"notes-api/internal/util"
 
 	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
@@ -51,36 +57,138 @@ func main() {
 
 	userRepo := repository.NewUserRepository(db)
 	noteRepo := repository.NewPostgresNoteRepository(db)
+	noteLinkRepo := repository.NewPostgresNoteLinkRepository(db)
+	noteTemplateRepo := repository.NewPostgresNoteTemplateRepository(db)
 	checklistItemRepo := repository.NewPostgresChecklistItemRepository(db)
 	noteTableRepo := repository.NewPostgresNoteTableRepository(db)
+	attachmentRepo := repository.NewPostgresAttachmentRepository(db)
+	imageRepo := repository.NewPostgresImageRepository(db)
+	apiTokenRepo := repository.NewPostgresAPITokenRepository(db)
+	userIdentityRepo := repository.NewPostgresUserIdentityRepository(db)
+	statsRepo := repository.NewPostgresStatsRepository(db)
+	sessionRepo := repository.NewPostgresSessionRepository(db)
+
+	blobStore, err := storage.New(cfg.Storage)
+	if err != nil {
+		log.Fatalf("Ошибка настройки хранилища файлов: %v", err)
+	}
+
+	mailer, err := notify.NewMailer(cfg.Mailer)
+	if err != nil {
+		log.Fatalf("Ошибка настройки отправки писем: %v", err)
+	}
+
+	apiTokenService := service.NewAPITokenService(apiTokenRepo)
+	jwtManager, err := util.NewJWTManager(cfg.Auth.JWT)
+	if err != nil {
+		log.Fatalf("Ошибка загрузки ключей подписи токенов: %v", err)
+	}
+
+	authService, err := service.NewAuthService(userRepo, apiTokenService, sessionRepo, jwtManager, mailer, cfg.Auth)
+	if err != nil {
+		log.Fatalf("Ошибка настройки аутентификации: %v", err)
+	}
 
-	authService := service.NewAuthService(userRepo)
-	noteService := service.NewNoteService(noteRepo)
+	var oidcProviders []*oidc.Provider
+	for _, providerCfg := range cfg.Auth.OIDCProviders {
+		provider, err := oidc.NewProvider(providerCfg)
+		if err != nil {
+			log.Fatalf("Ошибка настройки входа через OpenID Connect: %v", err)
+		}
+		oidcProviders = append(oidcProviders, provider)
+	}
+	oidcService := service.NewOIDCService(oidcProviders, userIdentityRepo, userRepo, authService)
+	adminService := service.NewAdminService(userRepo, statsRepo, authService)
+	if err := adminService.PromoteAdmins(cfg.Auth.AdminUsernames); err != nil {
+		log.Fatalf("Ошибка назначения администраторов: %v", err)
+	}
+	profileService := service.NewProfileService(userRepo, authService)
+	attachmentService := service.NewAttachmentService(attachmentRepo, noteRepo, blobStore, cfg.Attachments)
+	imageService := service.NewImageService(imageRepo, blobStore, cfg.Attachments.UserQuota)
+	noteService := service.NewNoteService(noteRepo, noteLinkRepo, userRepo, attachmentService, imageService)
 	checklistItemService := service.NewChecklistItemService(checklistItemRepo, noteRepo)
 	noteTableService := service.NewNoteTableService(noteTableRepo, noteRepo)
+	noteTemplateService := service.NewNoteTemplateService(noteTemplateRepo, noteRepo, checklistItemRepo, noteTableRepo, noteLinkRepo, userRepo)
+
+	if cfg.Reminders.Enabled {
+		notifier, err := notify.New(cfg.Reminders.Notifier)
+		if err != nil {
+			log.Fatalf("Ошибка настройки уведомлений: %v", err)
+		}
+		service.NewReminderScheduler(checklistItemRepo, notifier, cfg.Reminders.Interval).Start(context.Background())
+		log.Printf("Планировщик напоминаний запущен (уведомления: %s)", cfg.Reminders.Notifier.Type)
+	}
 
-	authHandler := handler.NewAuthHandler(authService)
+	service.NewRecurringNoteScheduler(noteRepo, userRepo, cfg.RecurringNotesInterval).Start(context.Background())
+	service.NewAccountPurger(userRepo, attachmentService, imageService, cfg.Auth.AccountPurgeInterval).Start(context.Background())
+
+	authHandler := handler.NewAuthHandler(authService, cfg.Auth.TrustProxyHeaders)
+	apiTokenHandler := handler.NewAPITokenHandler(apiTokenService)
+	oidcHandler := handler.NewOIDCHandler(oidcService, authHandler)
+	adminHandler := handler.NewAdminHandler(adminService)
+	profileHandler := handler.NewProfileHandler(profileService, authHandler)
 	noteHandler := handler.NewNoteHandler(noteService)
 	checklistItemHandler := handler.NewChecklistItemHandler(checklistItemService)
 	noteTableHandler := handler.NewNoteTableHandler(noteTableService)
+	attachmentHandler := handler.NewAttachmentHandler(attachmentService, cfg.Attachments.MaxSize)
+	imageHandler := handler.NewImageHandler(imageService)
+	noteTemplateHandler := handler.NewNoteTemplateHandler(noteTemplateService)
 
 	r := mux.NewRouter()
+	r.HandleFunc("/.well-known/jwks.json", authHandler.JWKS).Methods("GET")
+
 	api := r.PathPrefix("/api/v1").Subrouter()
 
 	authRouter := api.PathPrefix("/auth").Subrouter()
 	authHandler.RegisterRoutes(authRouter)
+	oidcHandler.RegisterRoutes(authRouter)
+
+	tokensRouter := authRouter.PathPrefix("/tokens").Subrouter()
+	tokensRouter.Use(authHandler.SessionMiddleware)
+	apiTokenHandler.RegisterRoutes(tokensRouter)
+
+	adminRouter := api.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(authHandler.SessionMiddleware, authHandler.RequireRole(model.RoleAdmin))
+	adminHandler.RegisterRoutes(adminRouter)
+
+	// Профиль можно читать по API-токену, а менять - только после входа пользователя
+	api.Handle("/me", authHandler.Middleware(http.HandlerFunc(profileHandler.Get))).Methods("GET")
+	api.Handle("/me", authHandler.SessionMiddleware(http.HandlerFunc(profileHandler.Update))).Methods("PATCH")
 
 	notesRouter := api.PathPrefix("/notes").Subrouter()
-	notesRouter.Use(handler.AuthMiddleware)
+	notesRouter.Use(authHandler.Middleware)
 
 	notesRouter.HandleFunc("", noteHandler.GetNotes).Methods("GET")
 	notesRouter.HandleFunc("", noteHandler.CreateNote).Methods("POST")
 	notesRouter.HandleFunc("/{id:[0-9]+}", noteHandler.GetNote).Methods("GET")
 	notesRouter.HandleFunc("/{id:[0-9]+}", noteHandler.UpdateNote).Methods("PUT")
 	notesRouter.HandleFunc("/{id:[0-9]+}", noteHandler.DeleteNote).Methods("DELETE")
+	notesRouter.HandleFunc("/{id:[0-9]+}/content", noteHandler.GetContent).Methods("GET")
+	notesRouter.HandleFunc("/{id:[0-9]+}/content", noteHandler.SetContent).Methods("PUT")
+	notesRouter.HandleFunc("/{id:[0-9]+}/render", noteHandler.RenderNote).Methods("GET")
+	notesRouter.HandleFunc("/{id:[0-9]+}/links", noteHandler.GetLinks).Methods("GET")
+	notesRouter.HandleFunc("/{id:[0-9]+}/backlinks", noteHandler.GetBacklinks).Methods("GET")
+	notesRouter.HandleFunc("/{id:[0-9]+}/pinned", noteHandler.SetPinned).Methods("PUT")
+	notesRouter.HandleFunc("/{id:[0-9]+}/archived", noteHandler.SetArchived).Methods("PUT")
+	notesRouter.HandleFunc("/{id:[0-9]+}/color", noteHandler.SetColor).Methods("PUT")
+	notesRouter.HandleFunc("/{id:[0-9]+}/duplicate", noteHandler.DuplicateNote).Methods("POST")
+	notesRouter.HandleFunc("/from-template/{id:[0-9]+}", noteTemplateHandler.CreateNote).Methods("POST")
 
 	checklistItemHandler.RegisterRoutes(notesRouter)
 	noteTableHandler.RegisterRoutes(notesRouter)
+	attachmentHandler.RegisterRoutes(notesRouter)
+
+	checklistRouter := api.PathPrefix("/checklist").Subrouter()
+	checklistRouter.Use(authHandler.Middleware)
+	checklistRouter.HandleFunc("/due", checklistItemHandler.Due).Methods("GET")
+
+	templatesRouter := api.PathPrefix("/templates").Subrouter()
+	templatesRouter.Use(authHandler.Middleware)
+	noteTemplateHandler.RegisterRoutes(templatesRouter)
+
+	imagesRouter := api.PathPrefix("/images").Subrouter()
+	imagesRouter.Use(authHandler.Middleware)
+	imagesRouter.HandleFunc("/{id:[0-9]+}", imageHandler.GetImage).Methods("GET", "HEAD")
 
 	r.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
 